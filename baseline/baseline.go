@@ -0,0 +1,225 @@
+package baseline
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nathanthorell/dataspy/config"
+)
+
+// Default windows and sample counts, used when a rule's Baseline leaves them
+// unset
+const (
+	defaultStdDevWindow     = 20
+	defaultStdDevMinSamples = 5
+	defaultPercentWindow    = 1
+)
+
+// maxHistoryAge is how far back a baseline looks for its window of runs, so a
+// rule with little history doesn't read the whole store
+const maxHistoryAge = 90 * 24 * time.Hour
+
+// sameHourTolerance is how far from exactly one week earlier a run may be
+// and still count as the same hour last week
+const sameHourTolerance = 30 * time.Minute
+
+// Sample is a metric recorded by a previous run
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Result is the verdict on a metric
+type Result struct {
+	// Ready is false while there is too little history to judge the metric
+	Ready bool
+	// Expected is the baseline value the metric was compared against
+	Expected float64
+	// Deviation is how far the metric is from Expected: standard deviations
+	// for stddev, percent for the percent methods
+	Deviation float64
+	Anomaly   bool
+
+	method string
+	value  float64
+}
+
+// String describes the comparison, e.g. "60 is 40.0% below 100"
+func (r Result) String() string {
+	if !r.Ready {
+		return fmt.Sprintf("%s: not enough history yet", r.method)
+	}
+	dir := "above"
+	if r.value < r.Expected {
+		dir = "below"
+	}
+	if r.method == config.BaselineStdDev {
+		return fmt.Sprintf("%g is %.1f stddev %s mean %g", r.value, math.Abs(r.Deviation), dir, r.Expected)
+	}
+	label := "recent runs"
+	if r.method == config.BaselineSameHourLastWeek {
+		label = "same hour last week"
+	}
+	return fmt.Sprintf("%g is %.1f%% %s %g (%s)", r.value, math.Abs(r.Deviation), dir, r.Expected, label)
+}
+
+// Evaluate compares a metric taken at now against history, which holds the
+// rule's earlier samples on the same server, oldest first
+func Evaluate(spec config.Baseline, value float64, now time.Time, history []Sample) Result {
+	res := Result{method: spec.Method, value: value}
+
+	switch spec.Method {
+	case config.BaselineStdDev:
+		values := recent(history, window(spec.Window, defaultStdDevWindow))
+		if len(values) < minSamples(spec.MinSamples, defaultStdDevMinSamples) || len(values) < 2 {
+			return res
+		}
+		res.Ready = true
+		res.Expected = Mean(values)
+		sd := StdDev(values)
+		switch {
+		case sd > 0:
+			res.Deviation = (value - res.Expected) / sd
+		case value == res.Expected:
+			res.Deviation = 0
+		default:
+			// A perfectly flat history makes any change infinitely unusual
+			res.Deviation = math.Copysign(math.Inf(1), value-res.Expected)
+		}
+
+	case config.BaselinePercentChange:
+		w := window(spec.Window, defaultPercentWindow)
+		values := recent(history, w)
+		if len(values) < minSamples(spec.MinSamples, w) || len(values) == 0 {
+			return res
+		}
+		res.Ready = true
+		res.Expected = Mean(values)
+		res.Deviation = PercentChange(res.Expected, value)
+
+	case config.BaselineSameHourLastWeek:
+		prev, ok := SameHourLastWeek(history, now)
+		if !ok {
+			return res
+		}
+		res.Ready = true
+		res.Expected = prev
+		res.Deviation = PercentChange(prev, value)
+
+	default:
+		return res
+	}
+
+	res.Anomaly = exceeds(res.Deviation, spec.Threshold, spec.Direction)
+	return res
+}
+
+// History returns the history Evaluate needs for a metric taken at now: the
+// samples taken since the returned time, and only the last n of them when n
+// is positive
+func History(spec config.Baseline, now time.Time) (since time.Time, n int) {
+	switch spec.Method {
+	case config.BaselineStdDev:
+		return now.Add(-maxHistoryAge), window(spec.Window, defaultStdDevWindow)
+	case config.BaselinePercentChange:
+		return now.Add(-maxHistoryAge), window(spec.Window, defaultPercentWindow)
+	default:
+		return now.AddDate(0, 0, -7).Add(-sameHourTolerance), 0
+	}
+}
+
+// exceeds reports whether a signed deviation passes the threshold in a
+// direction the rule cares about
+func exceeds(deviation, threshold float64, direction string) bool {
+	switch direction {
+	case config.DirectionDrop:
+		return deviation <= -threshold
+	case config.DirectionRise:
+		return deviation >= threshold
+	default:
+		return math.Abs(deviation) >= threshold
+	}
+}
+
+func window(configured, def int) int {
+	if configured > 0 {
+		return configured
+	}
+	return def
+}
+
+func minSamples(configured, def int) int {
+	if configured > 0 {
+		return configured
+	}
+	return def
+}
+
+// recent returns the values of the last n samples
+func recent(history []Sample, n int) []float64 {
+	if len(history) > n {
+		history = history[len(history)-n:]
+	}
+	values := make([]float64, len(history))
+	for i, s := range history {
+		values[i] = s.Value
+	}
+	return values
+}
+
+// Mean returns the arithmetic mean of values, or 0 for none
+func Mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// StdDev returns the sample standard deviation of values, or 0 for fewer
+// than two
+func StdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	mean := Mean(values)
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sq / float64(len(values)-1))
+}
+
+// PercentChange returns the change from base to value as a percentage of
+// base. Any change from zero is infinite.
+func PercentChange(base, value float64) float64 {
+	if base == 0 {
+		if value == 0 {
+			return 0
+		}
+		return math.Copysign(math.Inf(1), value)
+	}
+	return (value - base) / math.Abs(base) * 100
+}
+
+// SameHourLastWeek returns the sample taken closest to exactly one week
+// before now, if one falls within half an hour of it
+func SameHourLastWeek(history []Sample, now time.Time) (float64, bool) {
+	target := now.AddDate(0, 0, -7)
+	var best Sample
+	bestGap := time.Duration(-1)
+	for _, s := range history {
+		gap := s.Time.Sub(target)
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap <= sameHourTolerance && (bestGap < 0 || gap < bestGap) {
+			best, bestGap = s, gap
+		}
+	}
+	return best.Value, bestGap >= 0
+}
@@ -0,0 +1,173 @@
+package baseline
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+// hourly builds a history of samples taken every hour before now
+func hourly(now time.Time, values ...float64) []Sample {
+	samples := make([]Sample, len(values))
+	for i, v := range values {
+		samples[i] = Sample{Time: now.Add(time.Duration(i-len(values)) * time.Hour), Value: v}
+	}
+	return samples
+}
+
+func TestMeanStdDev(t *testing.T) {
+	values := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	assert.Equal(t, 5.0, Mean(values))
+	assert.InDelta(t, 2.138, StdDev(values), 0.001)
+
+	assert.Equal(t, 0.0, Mean(nil))
+	assert.Equal(t, 0.0, StdDev([]float64{3}))
+}
+
+func TestPercentChange(t *testing.T) {
+	assert.Equal(t, -40.0, PercentChange(100, 60))
+	assert.Equal(t, 50.0, PercentChange(-10, -5))
+	assert.Equal(t, 0.0, PercentChange(0, 0))
+	assert.True(t, math.IsInf(PercentChange(0, 3), 1))
+}
+
+func TestSameHourLastWeek(t *testing.T) {
+	weekAgo := start.AddDate(0, 0, -7)
+	history := []Sample{
+		{Time: weekAgo.Add(-50 * time.Minute), Value: 1},
+		{Time: weekAgo.Add(20 * time.Minute), Value: 2},
+		{Time: weekAgo.Add(-5 * time.Minute), Value: 3},
+		{Time: start.Add(-time.Hour), Value: 4},
+	}
+
+	v, ok := SameHourLastWeek(history, start)
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, v)
+
+	_, ok = SameHourLastWeek(history[:1], start)
+	assert.False(t, ok, "a run 50 minutes off isn't the same hour")
+}
+
+func TestEvaluate(t *testing.T) {
+	steady := hourly(start, 98, 102, 100, 99, 101, 100, 97, 103, 100, 100)
+
+	tests := []struct {
+		name          string
+		spec          config.Baseline
+		value         float64
+		history       []Sample
+		expectReady   bool
+		expectAnomaly bool
+		expectDesc    string
+	}{
+		{
+			name:        "stddev within range",
+			spec:        config.Baseline{Method: config.BaselineStdDev, Threshold: 3},
+			value:       102,
+			history:     steady,
+			expectReady: true,
+		},
+		{
+			name:          "stddev spike",
+			spec:          config.Baseline{Method: config.BaselineStdDev, Threshold: 3},
+			value:         130,
+			history:       steady,
+			expectReady:   true,
+			expectAnomaly: true,
+			expectDesc:    "stddev above mean 100",
+		},
+		{
+			name:        "stddev spike ignored when only drops matter",
+			spec:        config.Baseline{Method: config.BaselineStdDev, Threshold: 3, Direction: config.DirectionDrop},
+			value:       130,
+			history:     steady,
+			expectReady: true,
+		},
+		{
+			name:    "stddev needs min samples",
+			spec:    config.Baseline{Method: config.BaselineStdDev, Threshold: 3},
+			value:   500,
+			history: steady[:4],
+		},
+		{
+			name:          "stddev flat history",
+			spec:          config.Baseline{Method: config.BaselineStdDev, Threshold: 3, MinSamples: 3},
+			value:         11,
+			history:       hourly(start, 10, 10, 10),
+			expectReady:   true,
+			expectAnomaly: true,
+		},
+		{
+			name:          "stddev window ignores old outliers",
+			spec:          config.Baseline{Method: config.BaselineStdDev, Threshold: 3, Window: 10},
+			value:         130,
+			history:       append(hourly(start.Add(-24*time.Hour), 1000, 0, 1000, 0), steady...),
+			expectReady:   true,
+			expectAnomaly: true,
+		},
+		{
+			name:          "percent drop from previous run",
+			spec:          config.Baseline{Method: config.BaselinePercentChange, Threshold: 40, Direction: config.DirectionDrop},
+			value:         55,
+			history:       steady,
+			expectReady:   true,
+			expectAnomaly: true,
+			expectDesc:    "55 is 45.0% below 100 (recent runs)",
+		},
+		{
+			name:        "percent drop under threshold",
+			spec:        config.Baseline{Method: config.BaselinePercentChange, Threshold: 40},
+			value:       70,
+			history:     steady,
+			expectReady: true,
+		},
+		{
+			name:          "percent change against windowed mean",
+			spec:          config.Baseline{Method: config.BaselinePercentChange, Threshold: 10, Window: 4},
+			value:         112,
+			history:       hourly(start, 500, 100, 100, 100, 100),
+			expectReady:   true,
+			expectAnomaly: true,
+		},
+		{
+			name:    "percent change without history",
+			spec:    config.Baseline{Method: config.BaselinePercentChange, Threshold: 40},
+			value:   10,
+			history: nil,
+		},
+		{
+			name:  "same hour last week",
+			spec:  config.Baseline{Method: config.BaselineSameHourLastWeek, Threshold: 40},
+			value: 50,
+			history: []Sample{
+				{Time: start.AddDate(0, 0, -7).Add(2 * time.Minute), Value: 100},
+				{Time: start.Add(-time.Hour), Value: 50},
+			},
+			expectReady:   true,
+			expectAnomaly: true,
+			expectDesc:    "50 is 50.0% below 100 (same hour last week)",
+		},
+		{
+			name:    "same hour last week missing",
+			spec:    config.Baseline{Method: config.BaselineSameHourLastWeek, Threshold: 40},
+			value:   50,
+			history: steady,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Evaluate(tt.spec, tt.value, start, tt.history)
+			assert.Equal(t, tt.expectReady, res.Ready)
+			assert.Equal(t, tt.expectAnomaly, res.Anomaly)
+			if tt.expectDesc != "" {
+				assert.Contains(t, res.String(), tt.expectDesc)
+			}
+		})
+	}
+}
@@ -33,7 +33,7 @@ func runDaemon(cmd *cobra.Command, args []string) {
 	}
 
 	// bbolt storage
-	store, err := storage.NewStore("data/dataspy.db")
+	store, err := storage.NewStore(storePath)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -48,4 +48,5 @@ func runDaemon(cmd *cobra.Command, args []string) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+	sched.Stop()
 }
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/logger"
+	"github.com/nathanthorell/dataspy/runner"
+	"github.com/nathanthorell/dataspy/storage"
+)
+
+// Exit codes returned by `dataspy run` and `dataspy validate`, so they can
+// gate CI and deploy pipelines
+const (
+	ExitOK             = 0 // every rule passed
+	ExitViolations     = 1 // at least one rule at or above --fail-on found violations
+	ExitExecutionError = 2 // at least one rule failed to execute
+	ExitConfigError    = 3 // invalid flags, environment or configuration
+)
+
+// exitWith logs err and terminates the process with the given exit code
+func exitWith(code int, err error) {
+	logger.Error(err, "dataspy failed", "exit_code", code)
+	os.Exit(code)
+}
+
+// parseFailOn validates a --fail-on threshold
+func parseFailOn(s string) (string, error) {
+	switch s {
+	case config.SeverityWarning, config.SeverityCritical:
+		return s, nil
+	default:
+		return "", fmt.Errorf("invalid --fail-on %q (expected %q or %q)", s, config.SeverityWarning, config.SeverityCritical)
+	}
+}
+
+// exitCodeFor picks the exit code for a set of results. Execution errors take
+// precedence over violations; violations only count when their rule's
+// severity meets the failOn threshold. A rule skipped because its server is
+// down wasn't checked, so it counts as an execution error; other skips don't
+// count, nor do failures muted by a maintenance window.
+func exitCodeFor(results []runner.RunResult, failOn string) int {
+	code := ExitOK
+	for _, r := range results {
+		if r.MutedBy != "" {
+			continue
+		}
+		if r.ServerUnavailable() {
+			return ExitExecutionError
+		}
+		switch r.Status {
+		case storage.StatusError:
+			return ExitExecutionError
+		case storage.StatusViolation:
+			if config.SeverityRank(r.Severity) >= config.SeverityRank(failOn) {
+				code = ExitViolations
+			}
+		}
+	}
+	return code
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/runner"
+	"github.com/nathanthorell/dataspy/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	result := func(status, severity, skipReason, mutedBy string) runner.RunResult {
+		return runner.RunResult{ExecutionRecord: storage.ExecutionRecord{
+			Status: status, Severity: severity, SkipReason: skipReason, MutedBy: mutedBy,
+		}}
+	}
+	passed := result(storage.StatusSuccess, "", "", "")
+
+	tests := []struct {
+		name    string
+		results []runner.RunResult
+		failOn  string
+		expect  int
+	}{
+		{"all passed", []runner.RunResult{passed, passed}, config.SeverityWarning, ExitOK},
+		{"warning violation", []runner.RunResult{passed, result(storage.StatusViolation, config.SeverityWarning, "", "")}, config.SeverityWarning, ExitViolations},
+		{"warning below threshold", []runner.RunResult{result(storage.StatusViolation, config.SeverityWarning, "", "")}, config.SeverityCritical, ExitOK},
+		{"error over violation", []runner.RunResult{result(storage.StatusViolation, config.SeverityCritical, "", ""), result(storage.StatusError, "", "", "")}, config.SeverityWarning, ExitExecutionError},
+		{"muted error", []runner.RunResult{result(storage.StatusError, "", "", "upgrade")}, config.SeverityWarning, ExitOK},
+		{"server unavailable", []runner.RunResult{passed, result(storage.StatusSkipped, config.SeverityCritical, "server unavailable", "")}, config.SeverityWarning, ExitExecutionError},
+		{"reconciliation server unavailable", []runner.RunResult{result(storage.StatusSkipped, config.SeverityCritical, "server unavailable: warehouse", "")}, config.SeverityCritical, ExitExecutionError},
+		{"prerequisite skip", []runner.RunResult{result(storage.StatusSkipped, config.SeverityCritical, "prerequisite load did not pass (violation)", "")}, config.SeverityWarning, ExitOK},
+		{"maintenance skip", []runner.RunResult{result(storage.StatusSkipped, config.SeverityCritical, "maintenance window upgrade", "")}, config.SeverityWarning, ExitOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, exitCodeFor(tt.results, tt.failOn))
+		})
+	}
+}
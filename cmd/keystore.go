@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/nathanthorell/dataspy/logger"
+	"github.com/nathanthorell/dataspy/secrets"
+	"github.com/spf13/cobra"
+)
+
+var keystoreCmd = &cobra.Command{
+	Use:   "keystore",
+	Short: "Manage the encrypted local keystore",
+	Long: fmt.Sprintf(`Manage secrets in the encrypted local keystore, referenced from a server's
+ConnString as "keystore:<name>".
+
+The keystore is stored at $%s (default: %s) and encrypted with the
+passphrase in $%s.`, secrets.KeystorePathVar, secrets.DefaultKeystorePath, secrets.KeystorePassphraseVar),
+}
+
+var keystoreSetCmd = &cobra.Command{
+	Use:   "set <name>",
+	Short: "Store a secret read from stdin",
+	Args:  cobra.ExactArgs(1),
+	Run:   keystoreSet,
+}
+
+var keystoreListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List stored secret names",
+	Args:  cobra.NoArgs,
+	Run:   keystoreList,
+}
+
+var keystoreDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Remove a secret",
+	Args:  cobra.ExactArgs(1),
+	Run:   keystoreDelete,
+}
+
+func init() {
+	rootCmd.AddCommand(keystoreCmd)
+	keystoreCmd.AddCommand(keystoreSetCmd, keystoreListCmd, keystoreDeleteCmd)
+}
+
+func openKeystore() *secrets.Keystore {
+	// The passphrase may live in .env, but the file is optional here
+	_ = loadEnv()
+
+	ks, err := secrets.OpenKeystore(secrets.KeystorePath(), os.Getenv(secrets.KeystorePassphraseVar))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return ks
+}
+
+func keystoreSet(cmd *cobra.Command, args []string) {
+	ks := openKeystore()
+
+	// Read from stdin so secrets don't end up in shell history
+	value, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	secret := strings.TrimRight(string(value), "\r\n")
+	if secret == "" {
+		log.Fatal("no secret provided on stdin")
+	}
+
+	ks.Set(args[0], secret)
+	if err := ks.Save(); err != nil {
+		log.Fatal(err)
+	}
+	logger.Success(fmt.Sprintf("Stored secret %s", args[0]))
+}
+
+func keystoreList(cmd *cobra.Command, args []string) {
+	ks := openKeystore()
+	for _, name := range ks.Names() {
+		fmt.Println(name)
+	}
+}
+
+func keystoreDelete(cmd *cobra.Command, args []string) {
+	ks := openKeystore()
+	if _, ok := ks.Get(args[0]); !ok {
+		log.Fatalf("secret %s not found", args[0])
+	}
+	ks.Delete(args[0])
+	if err := ks.Save(); err != nil {
+		log.Fatal(err)
+	}
+	logger.Success(fmt.Sprintf("Deleted secret %s", args[0]))
+}
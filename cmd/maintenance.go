@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/logger"
+	"github.com/nathanthorell/dataspy/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	maintenanceName    string
+	maintenanceFor     time.Duration
+	maintenanceStart   string
+	maintenanceServers []string
+	maintenanceRules   []string
+	maintenanceMode    string
+	maintenanceReason  string
+)
+
+var maintenanceCmd = &cobra.Command{
+	Use:   "maintenance",
+	Short: "Manage maintenance windows",
+	Long: `Manage the windows during which rules are skipped, or run with their failures muted.
+
+Recurring windows are configured under [[maintenance]]. Open an ad-hoc window
+for unplanned work; it closes on its own when its time is up.`,
+}
+
+var maintenanceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List configured and ad-hoc maintenance windows",
+	Args:  cobra.NoArgs,
+	Run:   maintenanceList,
+}
+
+var maintenanceAdHocCmd = &cobra.Command{
+	Use:   "ad-hoc",
+	Short: "Open a temporary maintenance window",
+	Args:  cobra.NoArgs,
+	Run:   maintenanceAdHoc,
+}
+
+var maintenanceCloseCmd = &cobra.Command{
+	Use:   "close <name>",
+	Short: "Close an ad-hoc maintenance window early",
+	Args:  cobra.ExactArgs(1),
+	Run:   maintenanceClose,
+}
+
+func init() {
+	rootCmd.AddCommand(maintenanceCmd)
+	maintenanceCmd.AddCommand(maintenanceListCmd, maintenanceAdHocCmd, maintenanceCloseCmd)
+
+	f := maintenanceAdHocCmd.Flags()
+	f.StringVarP(&maintenanceName, "name", "n", "", "name of the window")
+	f.DurationVar(&maintenanceFor, "for", 0, "how long the window stays open, e.g. 2h")
+	f.StringVar(&maintenanceStart, "start", "", "when the window opens, RFC 3339 (default: now)")
+	f.StringSliceVarP(&maintenanceServers, "server", "s", nil, "limit the window to rules on this server (repeatable)")
+	f.StringSliceVarP(&maintenanceRules, "rule", "r", nil, "limit the window to this rule (repeatable)")
+	f.StringVar(&maintenanceMode, "mode", config.MaintenanceSkip,
+		fmt.Sprintf("%s rules, or %s them to run without reporting failures", config.MaintenanceSkip, config.MaintenanceMute))
+	f.StringVar(&maintenanceReason, "reason", "", "why the window was opened")
+	maintenanceAdHocCmd.MarkFlagRequired("name")
+	maintenanceAdHocCmd.MarkFlagRequired("for")
+}
+
+func maintenanceList(cmd *cobra.Command, args []string) {
+	cfg := mustLoadConfig()
+
+	// Ad-hoc windows are kept outside the database, so this works while the
+	// daemon has it open
+	adHoc, err := storage.NewMaintenanceWindows(storePath).List()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSOURCE\tMODE\tSTATUS\tOPENS\tCLOSES\tSCOPE")
+	for _, mw := range cfg.Maintenance {
+		loc, err := cfg.MaintenanceLocation(mw)
+		if err != nil {
+			log.Fatal(err)
+		}
+		start, end, err := mw.Range(now, loc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(w, "%s\tconfig\t%s\t%s\t%s\t%s\t%s\n", mw.Name, maintenanceModeName(mw.Mode),
+			maintenanceStatus(now, start, end), start.Format(time.RFC3339), end.Format(time.RFC3339),
+			maintenanceScope(mw.Servers, mw.Rules))
+	}
+	for _, mw := range adHoc {
+		fmt.Fprintf(w, "%s\tad-hoc\t%s\t%s\t%s\t%s\t%s\n", mw.Name, maintenanceModeName(mw.Mode),
+			maintenanceStatus(now, mw.Start, mw.End), mw.Start.Format(time.RFC3339), mw.End.Format(time.RFC3339),
+			maintenanceScope(mw.Servers, mw.Rules))
+	}
+	w.Flush()
+}
+
+func maintenanceAdHoc(cmd *cobra.Command, args []string) {
+	if maintenanceFor <= 0 {
+		log.Fatalf("invalid --for %s: must be positive", maintenanceFor)
+	}
+	start := time.Now()
+	if maintenanceStart != "" {
+		var err error
+		if start, err = time.Parse(time.RFC3339, maintenanceStart); err != nil {
+			log.Fatalf("invalid --start %q: expected RFC 3339 (2006-01-02T15:04:05Z)", maintenanceStart)
+		}
+	}
+	switch maintenanceMode {
+	case config.MaintenanceSkip, config.MaintenanceMute:
+	default:
+		log.Fatalf("invalid --mode %q (expected %s or %s)", maintenanceMode, config.MaintenanceSkip, config.MaintenanceMute)
+	}
+
+	// Check the names against the configuration so a typo doesn't open a
+	// window that covers nothing
+	cfg := mustLoadConfig()
+	for _, name := range maintenanceServers {
+		if _, ok := findConfigServer(cfg, name); !ok {
+			log.Fatalf("server not found: %s", name)
+		}
+	}
+	for _, name := range maintenanceRules {
+		if _, ok := findConfigRule(cfg, name); !ok {
+			log.Fatalf("rule not found: %s", name)
+		}
+	}
+	for _, mw := range cfg.Maintenance {
+		if mw.Name == maintenanceName {
+			log.Fatalf("maintenance window %s is already configured", maintenanceName)
+		}
+	}
+
+	if !start.Add(maintenanceFor).After(time.Now()) {
+		log.Fatalf("maintenance window %s would already have ended", maintenanceName)
+	}
+
+	mw := storage.MaintenanceWindow{
+		Name:    maintenanceName,
+		Start:   start,
+		End:     start.Add(maintenanceFor),
+		Mode:    maintenanceMode,
+		Servers: maintenanceServers,
+		Rules:   maintenanceRules,
+		Reason:  maintenanceReason,
+	}
+	if err := storage.NewMaintenanceWindows(storePath).Save(mw); err != nil {
+		log.Fatal(err)
+	}
+	logger.Success(fmt.Sprintf("Opened maintenance window %s (%s) until %s for %s", mw.Name, mw.Mode,
+		mw.End.Format(time.RFC3339), maintenanceScope(mw.Servers, mw.Rules)))
+}
+
+func maintenanceClose(cmd *cobra.Command, args []string) {
+	deleted, err := storage.NewMaintenanceWindows(storePath).Delete(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !deleted {
+		log.Fatalf("no ad-hoc maintenance window named %s", args[0])
+	}
+	logger.Success(fmt.Sprintf("Closed maintenance window %s", args[0]))
+}
+
+func maintenanceModeName(mode string) string {
+	if mode == "" {
+		return config.MaintenanceSkip
+	}
+	return mode
+}
+
+func maintenanceStatus(now, start, end time.Time) string {
+	switch {
+	case now.Before(start):
+		return "upcoming"
+	case now.Before(end):
+		return "open"
+	default:
+		return "ended"
+	}
+}
+
+// maintenanceScope describes which rules a window covers
+func maintenanceScope(servers, rules []string) string {
+	var parts []string
+	if len(servers) > 0 {
+		parts = append(parts, "servers "+strings.Join(servers, ", "))
+	}
+	if len(rules) > 0 {
+		parts = append(parts, "rules "+strings.Join(rules, ", "))
+	}
+	if len(parts) == 0 {
+		return "all rules"
+	}
+	return strings.Join(parts, "; ")
+}
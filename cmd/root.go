@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -8,9 +9,29 @@ import (
 
 	"github.com/joho/godotenv"
 	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/db"
+	"github.com/nathanthorell/dataspy/logger"
+	"github.com/nathanthorell/dataspy/storage"
 	"github.com/spf13/cobra"
 )
 
+// storePath is the bbolt database holding execution history and rule metadata
+const storePath = "data/dataspy.db"
+
+// queueChange queues a change to rule metadata when the store couldn't be
+// opened because another process, usually the daemon, holds it. It reports
+// whether it did; any other error is left to the caller.
+func queueChange(openErr error, change storage.QueuedChange) bool {
+	if !errors.Is(openErr, storage.ErrLocked) {
+		return false
+	}
+	if err := storage.NewChangeQueue(storePath).Add(change); err != nil {
+		log.Fatal(err)
+	}
+	logger.Success(fmt.Sprintf("The database is in use, so the change is queued: %s. The daemon applies it before its next run.", change))
+	return true
+}
+
 var (
 	envFile    string
 	configData []byte
@@ -50,11 +71,50 @@ func loadEnv() error {
 	return nil
 }
 
-// loadConfig loads the configuration from embedded TOML
+// loadConfig loads and validates the configuration from embedded TOML
 func loadConfig() (config.Config, error) {
 	cfg, err := config.LoadConfigBytes(configData)
 	if err != nil {
-		log.Fatal(err)
+		return config.Config{}, err
+	}
+	if err := cfg.NormalizeDbTypes(db.CanonicalType); err != nil {
+		return config.Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := cfg.LoadHolidayFiles(); err != nil {
+		return config.Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return config.Config{}, fmt.Errorf("invalid configuration: %w", err)
 	}
 	return cfg, nil
 }
+
+// mustLoadConfig loads the configuration for commands that don't connect to
+// servers, exiting when it's invalid
+func mustLoadConfig() config.Config {
+	// Secrets aren't needed here, so a missing .env is fine
+	_ = loadEnv()
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg
+}
+
+func findConfigRule(cfg config.Config, name string) (config.Rule, bool) {
+	for _, r := range cfg.Rules {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return config.Rule{}, false
+}
+
+func findConfigServer(cfg config.Config, name string) (config.DbServer, bool) {
+	for _, srv := range cfg.DBServers {
+		if srv.Name == name {
+			return srv, true
+		}
+	}
+	return config.DbServer{}, false
+}
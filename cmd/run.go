@@ -1,23 +1,37 @@
 package cmd
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/logger"
+	"github.com/nathanthorell/dataspy/report"
 	"github.com/nathanthorell/dataspy/runner"
 	"github.com/nathanthorell/dataspy/storage"
 	"github.com/spf13/cobra"
 )
 
 var (
-	ruleName string
-	runAll   bool
+	ruleName     string
+	runAll       bool
+	outputFormat string
+	failOn       string
 )
 
 var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Run rules on-demand",
-	Long:  `Execute one or all rules immediately without waiting for scheduled execution.`,
-	Run:   runRules,
+	Long: `Execute one or all rules immediately without waiting for scheduled execution.
+
+Exit codes:
+  0  all rules passed
+  1  violations found in rules at or above --fail-on
+  2  one or more rules failed to execute
+  3  invalid flags, environment or configuration`,
+	Run: runRules,
 }
 
 func init() {
@@ -25,37 +39,74 @@ func init() {
 	runCmd.Flags().StringVarP(&ruleName, "rule", "r", "", "name of the rule to run")
 	runCmd.Flags().BoolVarP(&runAll, "all", "a", false, "run all rules")
 	runCmd.MarkFlagsMutuallyExclusive("rule", "all")
+
+	formats := make([]string, len(report.Formats))
+	for i, f := range report.Formats {
+		formats[i] = string(f)
+	}
+	runCmd.Flags().StringVarP(&outputFormat, "output", "o", string(report.FormatText),
+		fmt.Sprintf("output format (%s)", strings.Join(formats, "|")))
+	runCmd.Flags().StringVar(&failOn, "fail-on", config.SeverityWarning,
+		"lowest rule severity whose violations fail the run (warning|critical)")
 }
 
 func runRules(cmd *cobra.Command, args []string) {
 	if !runAll && ruleName == "" {
-		log.Fatal("must specify either --rule or --all")
+		exitWith(ExitConfigError, errors.New("must specify either --rule or --all"))
+	}
+
+	format, err := report.ParseFormat(outputFormat)
+	if err != nil {
+		exitWith(ExitConfigError, err)
+	}
+
+	threshold, err := parseFailOn(failOn)
+	if err != nil {
+		exitWith(ExitConfigError, err)
+	}
+
+	// Keep stdout clean for machine-readable output; human-readable results
+	// still go to stderr alongside the logs
+	if format != report.FormatText {
+		logger.DefaultLogger.SetResultOutput(os.Stderr)
 	}
 
 	if err := loadEnv(); err != nil {
-		log.Fatal(err)
+		exitWith(ExitConfigError, err)
 	}
 
 	cfg, err := loadConfig()
 	if err != nil {
-		log.Fatal(err)
+		exitWith(ExitConfigError, err)
+	}
+
+	if _, ok := findConfigRule(cfg, ruleName); !runAll && !ok {
+		exitWith(ExitConfigError, fmt.Errorf("rule not found: %s", ruleName))
 	}
 
 	// bbolt storage
-	store, err := storage.NewStore("data/dataspy.db")
+	store, err := storage.NewStore(storePath)
 	if err != nil {
-		log.Fatal(err)
+		exitWith(ExitExecutionError, err)
 	}
-	defer store.Close()
 
 	// Create scheduler (without starting it) to use execution methods
 	sched := runner.NewScheduler(cfg, store)
 
+	var results []runner.RunResult
 	if runAll {
-		sched.ExecuteAllRules()
+		results = sched.ExecuteAllRules()
 	} else {
-		if err := sched.ExecuteRuleByName(ruleName); err != nil {
-			log.Fatal(err)
-		}
+		// Failures are captured in the result and reflected in the exit code
+		result, _ := sched.ExecuteRuleByName(ruleName)
+		results = append(results, result)
+	}
+	sched.Stop()
+	store.Close()
+
+	if err := report.Write(os.Stdout, format, results); err != nil {
+		exitWith(ExitExecutionError, err)
 	}
+
+	os.Exit(exitCodeFor(results, threshold))
 }
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nathanthorell/dataspy/runner"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scheduleRule  string
+	scheduleCount int
+	scheduleFrom  string
+)
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Inspect rule schedules",
+}
+
+var schedulePreviewCmd = &cobra.Command{
+	Use:   "preview",
+	Short: "List the next times each schedule fires",
+	Long: `List the next times each schedule fires, in the schedule's own timezone and
+after its calendar's weekends and holidays are left out.`,
+	Args: cobra.NoArgs,
+	Run:  schedulePreview,
+}
+
+func init() {
+	rootCmd.AddCommand(scheduleCmd)
+	scheduleCmd.AddCommand(schedulePreviewCmd)
+
+	schedulePreviewCmd.Flags().StringVarP(&scheduleRule, "rule", "r", "", "only show schedules of this rule")
+	schedulePreviewCmd.Flags().IntVarP(&scheduleCount, "count", "n", 5, "number of fire times per schedule")
+	schedulePreviewCmd.Flags().StringVar(&scheduleFrom, "from", "", "list fire times after this RFC 3339 time (default: now)")
+}
+
+func schedulePreview(cmd *cobra.Command, args []string) {
+	if scheduleCount < 1 {
+		log.Fatalf("invalid --count %d: must be at least 1", scheduleCount)
+	}
+	from := time.Now()
+	if scheduleFrom != "" {
+		var err error
+		if from, err = time.Parse(time.RFC3339, scheduleFrom); err != nil {
+			log.Fatalf("invalid --from %q: expected RFC 3339 (2006-01-02T15:04:05Z)", scheduleFrom)
+		}
+	}
+
+	cfg := mustLoadConfig()
+	if scheduleRule != "" {
+		if _, ok := findConfigRule(cfg, scheduleRule); !ok {
+			log.Fatalf("rule not found: %s", scheduleRule)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tSERVER\tSCHEDULE\tNEXT RUN")
+	for _, schedule := range cfg.Schedules {
+		if scheduleRule != "" && schedule.Rule != scheduleRule {
+			continue
+		}
+		spec, loc, err := runner.ParseSchedule(cfg, schedule)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		desc := schedule.CronStr
+		if schedule.Calendar != "" {
+			desc = fmt.Sprintf("%s (%s)", desc, schedule.Calendar)
+		}
+		next := from
+		for i := 0; i < scheduleCount; i++ {
+			if next = spec.Next(next); next.IsZero() {
+				fmt.Fprintf(w, "%s\t%s\t%s\tnever\n", schedule.Rule, schedule.Server, desc)
+				break
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", schedule.Rule, schedule.Server, desc, next.In(loc).Format("Mon 2006-01-02 15:04:05 MST"))
+		}
+	}
+	w.Flush()
+}
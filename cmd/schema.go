@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nathanthorell/dataspy/logger"
+	"github.com/nathanthorell/dataspy/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	schemaRule   string
+	schemaServer string
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Manage schema check snapshots",
+	Long: `Manage the snapshots taken by schema checks (Kind = "schema").
+
+Each run stores the latest snapshot of the rule's tables per server and
+reports changes since the previous run. Pin a snapshot to report every change
+since that point instead, until it is unpinned or pinned again.`,
+}
+
+var schemaListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List stored schema snapshots",
+	Args:  cobra.NoArgs,
+	Run:   schemaList,
+}
+
+var schemaShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the columns and indexes in a rule's latest or pinned snapshot",
+	Args:  cobra.NoArgs,
+	Run:   schemaShow,
+}
+
+var schemaPinCmd = &cobra.Command{
+	Use:   "pin",
+	Short: "Pin a rule's latest snapshot on a server as its baseline",
+	Args:  cobra.NoArgs,
+	Run:   schemaPin,
+}
+
+var schemaUnpinCmd = &cobra.Command{
+	Use:   "unpin",
+	Short: "Compare a rule against its previous snapshot again",
+	Args:  cobra.NoArgs,
+	Run:   schemaUnpin,
+}
+
+var schemaShowPinned bool
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+	schemaCmd.AddCommand(schemaListCmd, schemaShowCmd, schemaPinCmd, schemaUnpinCmd)
+
+	for _, c := range []*cobra.Command{schemaShowCmd, schemaPinCmd, schemaUnpinCmd} {
+		c.Flags().StringVarP(&schemaRule, "rule", "r", "", "name of the rule")
+		c.Flags().StringVarP(&schemaServer, "server", "s", "", "name of the server")
+		c.MarkFlagRequired("rule")
+		c.MarkFlagRequired("server")
+	}
+	schemaShowCmd.Flags().BoolVar(&schemaShowPinned, "pinned", false, "show the pinned snapshot instead of the latest")
+}
+
+func schemaList(cmd *cobra.Command, args []string) {
+	store, err := storage.NewStore(storePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	snaps, err := store.ListSchemaSnapshots()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tSERVER\tTAKEN\tOBJECTS\tPINNED")
+	for _, snap := range snaps {
+		pinned := "-"
+		pin, found, err := store.GetPinnedSchema(snap.RuleName, snap.ServerName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if found {
+			pinned = pin.TakenAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n",
+			snap.RuleName, snap.ServerName, snap.TakenAt.Format(time.RFC3339), len(snap.Objects), pinned)
+	}
+	w.Flush()
+}
+
+func schemaShow(cmd *cobra.Command, args []string) {
+	store, err := storage.NewStore(storePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	get := store.GetSchemaSnapshot
+	if schemaShowPinned {
+		get = store.GetPinnedSchema
+	}
+	snap, found, err := get(schemaRule, schemaServer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !found {
+		log.Fatalf("no schema snapshot for rule %s on %s", schemaRule, schemaServer)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tTYPE\tNAME\tDEFINITION")
+	for _, o := range snap.Objects {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", o.Table, o.Type, o.Name, o.Definition)
+	}
+	w.Flush()
+}
+
+func schemaPin(cmd *cobra.Command, args []string) {
+	store, err := storage.NewStore(storePath)
+	if queueChange(err, storage.QueuedChange{Kind: storage.ChangePinSchema, RuleName: schemaRule, ServerName: schemaServer}) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	snap, err := store.PinSchemaSnapshot(schemaRule, schemaServer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger.Success(fmt.Sprintf("Pinned schema snapshot of %s on %s taken at %s",
+		schemaRule, schemaServer, snap.TakenAt.Format(time.RFC3339)))
+}
+
+func schemaUnpin(cmd *cobra.Command, args []string) {
+	store, err := storage.NewStore(storePath)
+	if queueChange(err, storage.QueuedChange{Kind: storage.ChangeUnpinSchema, RuleName: schemaRule, ServerName: schemaServer}) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	unpinned, err := store.UnpinSchemaSnapshot(schemaRule, schemaServer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !unpinned {
+		log.Fatalf("no pinned schema snapshot for rule %s on %s", schemaRule, schemaServer)
+	}
+	logger.Success(fmt.Sprintf("Unpinned schema snapshot of %s on %s", schemaRule, schemaServer))
+}
@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/db"
+	"github.com/nathanthorell/dataspy/logger"
+	"github.com/spf13/cobra"
+)
+
+var lintSQL bool
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the configuration",
+	Long: `Check that the configuration loads and is internally consistent, without
+connecting to any database. With --lint-sql, also reject rules whose SQL
+contains DML or DDL keywords for their dialect.`,
+	Run: validateConfig,
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().BoolVar(&lintSQL, "lint-sql", false, "reject rules containing DML/DDL keywords")
+}
+
+func validateConfig(cmd *cobra.Command, args []string) {
+	cfg, err := loadConfig()
+	if err != nil {
+		exitWith(ExitConfigError, err)
+	}
+
+	// Check structured connection settings produce a DSN the driver accepts.
+	// Passwords aren't resolved here, so a placeholder stands in.
+	for _, srv := range cfg.DBServers {
+		if !srv.IsStructured() {
+			continue
+		}
+		if _, err := db.BuildConnString(srv, "placeholder"); err != nil {
+			exitWith(ExitConfigError, err)
+		}
+	}
+
+	if err := checkParams(cfg); err != nil {
+		exitWith(ExitConfigError, err)
+	}
+	if err := checkReadOnlyPhases(cfg); err != nil {
+		exitWith(ExitConfigError, err)
+	}
+
+	if lintSQL {
+		issueCount := 0
+		for _, rule := range cfg.Rules {
+			// Errors compiling checks were reported by checkParams
+			queries, _ := ruleQueries(cfg, rule)
+			var session []string
+			for _, q := range queries {
+				if q.phase != "" {
+					session = append(session, q.query)
+					continue
+				}
+				for _, issue := range db.LintQuery(q.dbType, q.query) {
+					logger.Error(fmt.Errorf("%s", issue), "SQL lint failed", "rule", rule.Name)
+					issueCount++
+				}
+			}
+			// Setup and teardown may change the session, but nothing else
+			for _, issue := range db.LintSession(rule.DbType, session) {
+				logger.Error(fmt.Errorf("%s", issue), "SQL lint failed", "rule", rule.Name, "phase", "setup/teardown")
+				issueCount++
+			}
+		}
+		if issueCount > 0 {
+			exitWith(ExitConfigError, fmt.Errorf("found %d SQL lint issues", issueCount))
+		}
+	}
+
+	logger.Success(fmt.Sprintf("Configuration is valid: %d servers, %d rules, %d schedules",
+		len(cfg.DBServers), len(cfg.Rules), len(cfg.Schedules)))
+}
+
+// ruleQuery is one query a rule runs and the server type it runs on
+type ruleQuery struct {
+	dbType string
+	query  string
+	server string        // set for reconciliation sides, which run on fixed servers
+	params config.Params // set for declarative checks, the values they bind
+	phase  string        // set for setup and teardown statements
+}
+
+// ruleQueries returns the queries a rule runs: its own, the SQL compiled from
+// its check kind, or both sides of a reconciliation, along with its setup and
+// teardown statements
+func ruleQueries(cfg config.Config, rule config.Rule) ([]ruleQuery, error) {
+	queries, err := mainQueries(cfg, rule)
+	if err != nil || rule.Reconcile != nil {
+		return queries, err
+	}
+	// Setup and teardown are rendered with the same values as the query
+	params := queries[0].params
+	for _, stmt := range rule.Setup {
+		queries = append(queries, ruleQuery{dbType: rule.DbType, query: stmt, params: params, phase: db.PhaseSetup})
+	}
+	for _, stmt := range rule.Teardown {
+		queries = append(queries, ruleQuery{dbType: rule.DbType, query: stmt, params: params, phase: db.PhaseTeardown})
+	}
+	return queries, nil
+}
+
+// mainQueries returns the queries that produce a rule's result
+func mainQueries(cfg config.Config, rule config.Rule) ([]ruleQuery, error) {
+	if rule.IsDeclarative() {
+		dialect, err := db.LookupDialect(rule.DbType)
+		if err != nil {
+			return nil, err
+		}
+		// Only the check's own values; the rule's are layered in per server
+		check := rule
+		check.Params = nil
+		query, params, err := db.CompileCheck(dialect, check, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return []ruleQuery{{dbType: rule.DbType, query: query, params: params}}, nil
+	}
+	if rule.Reconcile == nil {
+		return []ruleQuery{{dbType: rule.DbType, query: rule.Query}}, nil
+	}
+	var queries []ruleQuery
+	for _, side := range []config.ReconcileSide{rule.Reconcile.Source, rule.Reconcile.Target} {
+		if srv, ok := findConfigServer(cfg, side.Server); ok {
+			queries = append(queries, ruleQuery{dbType: srv.Type, query: side.Query, server: srv.Name})
+		}
+	}
+	return queries, nil
+}
+
+// checkReadOnlyPhases checks the setup and teardown statements of rules that
+// can run on a ReadOnly server only change the session, since they run
+// outside its read-only transaction. The executor refuses them otherwise.
+func checkReadOnlyPhases(cfg config.Config) error {
+	var errs []error
+	for _, rule := range cfg.Rules {
+		statements := append(slices.Clone(rule.Setup), rule.Teardown...)
+		if len(statements) == 0 {
+			continue
+		}
+		for _, srv := range cfg.DBServers {
+			if srv.Type != rule.DbType || !srv.ReadOnly {
+				continue
+			}
+			for _, issue := range db.LintSession(srv.Type, statements) {
+				errs = append(errs, fmt.Errorf("rule %q on read-only server %q: setup and teardown may only change the session: %s",
+					rule.Name, srv.Name, issue))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkParams renders every rule's queries with the parameters of each place
+// they run: ad hoc on the first server of the rule's type (or a
+// reconciliation's own servers), and on each schedule
+func checkParams(cfg config.Config) error {
+	var errs []error
+	render := func(rule config.Rule, q ruleQuery, server config.DbServer, schedule config.Schedule) {
+		dialect, err := db.LookupDialect(q.dbType)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: %w", rule.Name, err))
+			return
+		}
+		params := cfg.EffectiveParams(rule, server, schedule)
+		for name, val := range q.params {
+			params[name] = val
+		}
+		undefined, err := db.UndefinedParams(dialect, q.query, params)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %q on server %q: %w", rule.Name, server.Name, err))
+		}
+		// Left as written when the rule runs, which is right for SQL such
+		// as arr[1:n] but usually means a missing parameter
+		for _, name := range undefined {
+			logger.Warn(fmt.Sprintf("Undefined parameter :%s is sent as written", name),
+				"rule", rule.Name, "server", server.Name)
+		}
+	}
+	// renderAll renders each of a rule's queries on its fixed server, or on
+	// server when it has none
+	renderAll := func(rule config.Rule, server config.DbServer, schedule config.Schedule) {
+		queries, err := ruleQueries(cfg, rule)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: %w", rule.Name, err))
+			return
+		}
+		for _, q := range queries {
+			srv := server
+			if q.server != "" {
+				srv, _ = findConfigServer(cfg, q.server)
+			}
+			render(rule, q, srv, schedule)
+		}
+	}
+
+	for _, rule := range cfg.Rules {
+		if rule.Reconcile != nil {
+			renderAll(rule, config.DbServer{}, config.Schedule{})
+			continue
+		}
+		for _, srv := range cfg.DBServers {
+			if srv.Type == rule.DbType {
+				renderAll(rule, srv, config.Schedule{})
+				break
+			}
+		}
+	}
+	for _, sched := range cfg.Schedules {
+		rule, ok := findConfigRule(cfg, sched.Rule)
+		if !ok {
+			continue
+		}
+		if rule.Reconcile != nil {
+			renderAll(rule, config.DbServer{}, sched)
+			continue
+		}
+		if srv, ok := findConfigServer(cfg, sched.Server); ok {
+			renderAll(rule, srv, sched)
+		}
+	}
+	return errors.Join(errs...)
+}
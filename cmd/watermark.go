@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/logger"
+	"github.com/nathanthorell/dataspy/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watermarkRule   string
+	watermarkServer string
+)
+
+var watermarkCmd = &cobra.Command{
+	Use:   "watermark",
+	Short: "Manage incremental rule watermarks",
+	Long: fmt.Sprintf(`Manage the high-water marks bound to :%s and :%s in rule queries.
+
+Each rule keeps one watermark per server, advanced to the start time of every
+successful run. Set it to an earlier time to backfill, or reset it so the next
+run starts from the rule's default again.`, config.ParamWatermark, config.ParamLastSuccessAt),
+}
+
+var watermarkListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List stored watermarks",
+	Args:  cobra.NoArgs,
+	Run:   watermarkList,
+}
+
+var watermarkSetCmd = &cobra.Command{
+	Use:   "set <time>",
+	Short: "Set a rule's watermark on a server (RFC 3339 time or YYYY-MM-DD)",
+	Args:  cobra.ExactArgs(1),
+	Run:   watermarkSet,
+}
+
+var watermarkResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Remove a rule's watermark on one or all servers",
+	Args:  cobra.NoArgs,
+	Run:   watermarkReset,
+}
+
+func init() {
+	rootCmd.AddCommand(watermarkCmd)
+	watermarkCmd.AddCommand(watermarkListCmd, watermarkSetCmd, watermarkResetCmd)
+
+	for _, c := range []*cobra.Command{watermarkSetCmd, watermarkResetCmd} {
+		c.Flags().StringVarP(&watermarkRule, "rule", "r", "", "name of the rule")
+		c.MarkFlagRequired("rule")
+	}
+	watermarkSetCmd.Flags().StringVarP(&watermarkServer, "server", "s", "", "name of the server")
+	watermarkSetCmd.MarkFlagRequired("server")
+	watermarkResetCmd.Flags().StringVarP(&watermarkServer, "server", "s", "", "name of the server (default: all servers)")
+}
+
+func watermarkList(cmd *cobra.Command, args []string) {
+	store, err := storage.NewStore(storePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	watermarks, err := store.ListWatermarks()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tSERVER\tWATERMARK\tSET BY")
+	for _, wm := range watermarks {
+		setBy := wm.ExecutionID
+		if setBy == "" {
+			setBy = "manual"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", wm.RuleName, wm.ServerName, wm.Value.Format(time.RFC3339), setBy)
+	}
+	w.Flush()
+}
+
+func watermarkSet(cmd *cobra.Command, args []string) {
+	value, err := parseWatermark(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Check the names against the configuration so a typo doesn't store a
+	// watermark nothing will read
+	cfg := mustLoadConfig()
+	rule, ok := findConfigRule(cfg, watermarkRule)
+	if !ok {
+		log.Fatalf("rule not found: %s", watermarkRule)
+	}
+	server, ok := findConfigServer(cfg, watermarkServer)
+	if !ok {
+		log.Fatalf("server not found: %s", watermarkServer)
+	}
+	if server.Type != rule.DbType {
+		log.Fatalf("server %s is %s but rule %s needs %s", server.Name, server.Type, rule.Name, rule.DbType)
+	}
+
+	// While the daemon holds the store, the change waits for it to apply
+	store, err := storage.NewStore(storePath)
+	if queueChange(err, storage.QueuedChange{
+		Kind: storage.ChangeSetWatermark, RuleName: rule.Name, ServerName: server.Name, Value: value,
+	}) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	wm := storage.Watermark{RuleName: rule.Name, ServerName: server.Name, Value: value}
+	if err := store.SetWatermark(wm); err != nil {
+		log.Fatal(err)
+	}
+	logger.Success(fmt.Sprintf("Set watermark for %s on %s to %s", rule.Name, server.Name, value.Format(time.RFC3339)))
+}
+
+func watermarkReset(cmd *cobra.Command, args []string) {
+	store, err := storage.NewStore(storePath)
+	if queueChange(err, storage.QueuedChange{
+		Kind: storage.ChangeResetWatermark, RuleName: watermarkRule, ServerName: watermarkServer,
+	}) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	// Reset by stored names rather than the configuration, so watermarks of
+	// renamed or removed servers can still be cleaned up
+	count, err := store.ResetWatermarks(watermarkRule, watermarkServer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if count == 0 {
+		log.Fatalf("no watermark found for rule %s", watermarkRule)
+	}
+	logger.Success(fmt.Sprintf("Reset %d watermark(s) for %s", count, watermarkRule))
+}
+
+// parseWatermark accepts an RFC 3339 time or a date, taken as midnight UTC
+func parseWatermark(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected RFC 3339 (2006-01-02T15:04:05Z) or YYYY-MM-DD", s)
+}
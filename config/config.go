@@ -1,59 +1,1420 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/nathanthorell/dataspy/expression"
+	"github.com/nathanthorell/dataspy/secrets"
 	"github.com/pelletier/go-toml/v2"
+	"github.com/robfig/cron/v3"
 )
 
 type Config struct {
+	// Timezone is the IANA timezone schedules and maintenance windows are
+	// read in when they don't set their own; local time by default
+	Timezone  string     `toml:"Timezone"`
 	DBServers []DbServer `toml:"db_servers"`
 	Rules     []Rule     `toml:"rules"`
 	Schedules []Schedule `toml:"scheduler"`
+	Masking   []MaskRule `toml:"masking"`
+	// MaskingKey is a secret reference to the key hash masks are computed
+	// with, e.g. "env:DATASPY_MASKING_KEY"
+	MaskingKey string `toml:"MaskingKey"`
+	// Maintenance windows skip or mute rules while they're open
+	Maintenance []MaintenanceWindow `toml:"maintenance"`
+	// Calendars define the business days schedules can be limited to
+	Calendars []Calendar `toml:"calendars"`
 }
 
 type DbServer struct {
 	Name          string `toml:"Name"`
 	Type          string `toml:"Type"`
 	ConnStringVar string `toml:"ConnStringVar"`
+	ConnString    string `toml:"ConnString"`
+	ReadOnly      bool   `toml:"ReadOnly"`
+	// Params override rule parameters for every rule run on this server
+	Params Params `toml:"Params"`
+	// Retry applies to rules on this server without a Retry of their own
+	Retry *RetryPolicy `toml:"Retry"`
+
+	// Structured connection settings, used instead of a raw connection
+	// string. The db package builds the driver-specific DSN from them.
+	// File-based databases (SQLite) set Path instead of Host.
+	Host           string            `toml:"Host"`
+	Path           string            `toml:"Path"`
+	Port           int               `toml:"Port"`
+	Database       string            `toml:"Database"`
+	User           string            `toml:"User"`
+	PasswordSecret string            `toml:"PasswordSecret"`
+	TLS            string            `toml:"TLS"`
+	Options        map[string]string `toml:"Options"`
+}
+
+// TLS modes for structured connections, mapped onto each driver's settings
+const (
+	TLSDisable    = "disable"     // plain text
+	TLSRequire    = "require"     // encrypt without verifying the certificate
+	TLSVerifyCA   = "verify-ca"   // encrypt and verify the certificate chain
+	TLSVerifyFull = "verify-full" // also verify the host name
+)
+
+// IsStructured reports whether the server is configured with connection
+// fields rather than a raw connection string
+func (server DbServer) IsStructured() bool {
+	return server.Host != "" || server.Path != ""
 }
 
 type Schedule struct {
 	Server  string `toml:"Server"`
 	Rule    string `toml:"Rule"`
 	CronStr string `toml:"CronStr"`
+	// Timezone is the IANA timezone CronStr is read in, overriding the global
+	// Timezone. A CRON_TZ= prefix on CronStr works too.
+	Timezone string `toml:"Timezone"`
+	// Calendar limits the schedule to the business days of the named
+	// calendar, judged in the schedule's timezone
+	Calendar string `toml:"Calendar"`
+	// BusinessDay further limits it to the Nth business day of each month,
+	// counting back from the end when negative (-1 is the last)
+	BusinessDay int `toml:"BusinessDay"`
+	// Params override rule and server parameters for this schedule
+	Params Params `toml:"Params"`
+}
+
+// Calendar is a set of business days: its Weekdays, except Holidays
+type Calendar struct {
+	Name string `toml:"Name"`
+	// Weekdays are day names such as "MON" or "Monday"; MON to FRI by default
+	Weekdays []string `toml:"Weekdays"`
+	// Holidays are dates (YYYY-MM-DD) that aren't business days
+	Holidays []string `toml:"Holidays"`
+	// HolidaysFile lists more holidays, one date per line. Blank lines and
+	// lines starting with # are ignored.
+	HolidaysFile string `toml:"HolidaysFile"`
+}
+
+var defaultWeekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+// BusinessWeekdays returns the calendar's Weekdays
+func (c Calendar) BusinessWeekdays() ([]time.Weekday, error) {
+	if len(c.Weekdays) == 0 {
+		return defaultWeekdays, nil
+	}
+	var days []time.Weekday
+	for _, name := range c.Weekdays {
+		day, ok := parseWeekday(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", name)
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || name == full[:3] {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// LoadHolidayFiles adds the dates listed in each calendar's HolidaysFile to
+// its Holidays. Dates are checked by Validate.
+func (c *Config) LoadHolidayFiles() error {
+	var errs []error
+	for i, cal := range c.Calendars {
+		if cal.HolidaysFile == "" {
+			continue
+		}
+		data, err := os.ReadFile(cal.HolidaysFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("calendar %q: failed to read HolidaysFile: %w", cal.Name, err))
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			c.Calendars[i].Holidays = append(c.Calendars[i].Holidays, line)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c Calendar) Validate() error {
+	var errs []error
+	if _, err := c.BusinessWeekdays(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, date := range c.Holidays {
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			errs = append(errs, fmt.Errorf("invalid holiday %q: expected YYYY-MM-DD", date))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cronParser reads schedules and maintenance windows: six fields starting
+// with seconds, or a descriptor such as @daily
+var cronParser = cron.NewParser(
+	cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
+// splitCronTZ separates a CRON_TZ= or TZ= prefix from a cron expression
+func splitCronTZ(spec string) (tz string, rest string) {
+	for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+		if after, ok := strings.CutPrefix(spec, prefix); ok {
+			tz, rest, _ = strings.Cut(after, " ")
+			return tz, strings.TrimSpace(rest)
+		}
+	}
+	return "", spec
+}
+
+// loadTimezone loads an IANA timezone, or local time when name is empty
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Timezone %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// ScheduleLocation returns the timezone a schedule runs in: its CronStr's
+// CRON_TZ prefix, its own Timezone, the global Timezone, or local time
+func (c Config) ScheduleLocation(s Schedule) (*time.Location, error) {
+	tz, _ := splitCronTZ(s.CronStr)
+	switch {
+	case tz != "" && s.Timezone != "" && tz != s.Timezone:
+		return nil, fmt.Errorf("CronStr's CRON_TZ=%s conflicts with Timezone %q", tz, s.Timezone)
+	case tz != "":
+		return loadTimezone(tz)
+	case s.Timezone != "":
+		return loadTimezone(s.Timezone)
+	default:
+		return loadTimezone(c.Timezone)
+	}
+}
+
+// MaintenanceLocation returns the timezone a maintenance window is read in:
+// its own Timezone, the global Timezone, or local time
+func (c Config) MaintenanceLocation(w MaintenanceWindow) (*time.Location, error) {
+	if w.Timezone != "" {
+		return loadTimezone(w.Timezone)
+	}
+	return loadTimezone(c.Timezone)
+}
+
+// ParseSchedule parses a schedule's CronStr in the timezone it runs in
+func (c Config) ParseSchedule(s Schedule) (cron.Schedule, *time.Location, error) {
+	loc, err := c.ScheduleLocation(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, spec := splitCronTZ(s.CronStr)
+	sched, err := cronParser.Parse(fmt.Sprintf("CRON_TZ=%s %s", loc, spec))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CronStr %q: %w", s.CronStr, err)
+	}
+	return sched, loc, nil
 }
 
 type Rule struct {
-	Name        string `toml:"Name"`
-	Description string `toml:"Description"`
-	DbType      string `toml:"DbType"`
-	Query       string `toml:"Query"`
+	Name        string     `toml:"Name"`
+	Description string     `toml:"Description"`
+	DbType      string     `toml:"DbType"`
+	Query       string     `toml:"Query"`
+	Severity    string     `toml:"Severity"`
+	Masking     []MaskRule `toml:"Masking"`
+	Params      Params     `toml:"Params"`
+	Baseline    *Baseline  `toml:"Baseline"`
+	Reconcile   *Reconcile `toml:"Reconcile"`
+	// DependsOn names rules that must pass before this one runs. In a batch
+	// or a scheduled chain, a rule whose prerequisite didn't pass is skipped.
+	DependsOn []string `toml:"DependsOn"`
+	// Retry reruns the rule after transient failures, overriding the
+	// server's policy
+	Retry *RetryPolicy `toml:"Retry"`
+	// Setup statements run in order before Query, and Teardown statements
+	// after it, all on the same connection. Teardown runs even when setup
+	// or the query fails.
+	Setup    []string `toml:"Setup"`
+	Teardown []string `toml:"Teardown"`
+	// Timeout limits how long the query may run, e.g. "30s"
+	Timeout string `toml:"Timeout"`
+	// Expression is a CEL condition on the result that must hold, e.g.
+	// "rows.all(r, r.balance >= 0)". The rule violates when it is false
+	// rather than whenever rows are returned.
+	Expression string `toml:"Expression"`
+
+	// Kind makes the rule a declarative check on Table, compiled to SQL for
+	// the server's dialect instead of running Query
+	Kind  string `toml:"Kind"`
+	Table string `toml:"Table"`
+	// Columns are the checked columns: the key for unique and references,
+	// the timestamp for freshness
+	Columns []string `toml:"Columns"`
+	// Where optionally restricts the checked rows, as a SQL condition that
+	// may use parameters
+	Where string `toml:"Where"`
+	// RefTable and RefColumns are the referenced key for references
+	RefTable   string   `toml:"RefTable"`
+	RefColumns []string `toml:"RefColumns"`
+	// Tables are the tables whose columns and indexes a schema check
+	// snapshots
+	Tables []string `toml:"Tables"`
+	// TopN is how many of each column's most common values a profile
+	// records; 5 by default
+	TopN int `toml:"TopN"`
+	// Expect lists conditions on a profile's metrics, e.g.
+	// "null_ratio(email) < 0.01"; the rule violates when any fails
+	Expect []string `toml:"Expect"`
+	// MaxAge is how old the newest row may be for freshness, e.g. "26h"
+	MaxAge string `toml:"MaxAge"`
+	// Min and Max bound the column values for range; either may be omitted
+	Min interface{} `toml:"Min"`
+	Max interface{} `toml:"Max"`
+}
+
+// Declarative check kinds
+const (
+	CheckNotNull    = "not_null"   // rows with a NULL in any of Columns
+	CheckUnique     = "unique"     // values of Columns that occur more than once
+	CheckReferences = "references" // rows whose Columns have no match in RefTable
+	CheckFreshness  = "freshness"  // the newest value of Columns[0] is older than MaxAge
+	CheckRange      = "range"      // rows with a value of Columns outside Min and Max
+	CheckSchema     = "schema"     // columns and indexes of Tables that changed
+	CheckProfile    = "profile"    // statistics of Columns, checked against Expect
+)
+
+// DefaultTopN is how many common values a profile records per column
+const DefaultTopN = 5
+
+// Profile metrics usable in expectations. row_count takes no column.
+const (
+	MetricRowCount      = "row_count"
+	MetricNullCount     = "null_count"
+	MetricNullRatio     = "null_ratio"
+	MetricDistinctCount = "distinct_count"
+	MetricDistinctRatio = "distinct_ratio"
+	MetricMin           = "min"
+	MetricMax           = "max"
+)
+
+var profileMetrics = []string{
+	MetricRowCount, MetricNullCount, MetricNullRatio, MetricDistinctCount, MetricDistinctRatio, MetricMin, MetricMax,
+}
+
+// Expectation is a parsed profile condition: Metric(Column) Op Value
+type Expectation struct {
+	Metric string
+	Column string
+	Op     string
+	Value  float64
+}
+
+var expectationPattern = regexp.MustCompile(
+	`^\s*([a-z_]+)\s*(?:\(\s*([A-Za-z_][A-Za-z0-9_]*)?\s*\))?\s*(<=|>=|==|!=|<|>)\s*(\S+)\s*$`)
+
+// ParseExpectation parses a condition such as "null_ratio(email) < 0.01" or
+// "row_count > 0"
+func ParseExpectation(s string) (Expectation, error) {
+	m := expectationPattern.FindStringSubmatch(s)
+	if m == nil {
+		return Expectation{}, fmt.Errorf("invalid expectation %q (expected e.g. null_ratio(email) < 0.01)", s)
+	}
+	e := Expectation{Metric: m[1], Column: m[2], Op: m[3]}
+	if !slices.Contains(profileMetrics, e.Metric) {
+		return Expectation{}, fmt.Errorf("expectation %q: unknown metric %q (expected one of %s)",
+			s, e.Metric, strings.Join(profileMetrics, ", "))
+	}
+	if (e.Metric == MetricRowCount) != (e.Column == "") {
+		if e.Column == "" {
+			return Expectation{}, fmt.Errorf("expectation %q: %s needs a column, e.g. %s(email)", s, e.Metric, e.Metric)
+		}
+		return Expectation{}, fmt.Errorf("expectation %q: %s takes no column", s, e.Metric)
+	}
+	v, err := strconv.ParseFloat(m[4], 64)
+	if err != nil {
+		return Expectation{}, fmt.Errorf("expectation %q: %q is not a number", s, m[4])
+	}
+	e.Value = v
+	return e, nil
+}
+
+// Holds reports whether actual satisfies the expectation
+func (e Expectation) Holds(actual float64) bool {
+	switch e.Op {
+	case "<":
+		return actual < e.Value
+	case "<=":
+		return actual <= e.Value
+	case ">":
+		return actual > e.Value
+	case ">=":
+		return actual >= e.Value
+	case "==":
+		return actual == e.Value
+	default:
+		return actual != e.Value
+	}
+}
+
+// String formats the expectation in its canonical form
+func (e Expectation) String() string {
+	if e.Column == "" {
+		return fmt.Sprintf("%s %s %g", e.Metric, e.Op, e.Value)
+	}
+	return fmt.Sprintf("%s(%s) %s %g", e.Metric, e.Column, e.Op, e.Value)
+}
+
+// IsDeclarative reports whether the rule is a check kind rather than a query
+func (r Rule) IsDeclarative() bool {
+	return r.Kind != ""
+}
+
+// ParseMaxAge returns the rule's MaxAge as a duration
+func (r Rule) ParseMaxAge() (time.Duration, error) {
+	d, err := time.ParseDuration(r.MaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("invalid MaxAge %q: %w", r.MaxAge, err)
+	}
+	return d, nil
+}
+
+// ParseTimeout returns the rule's Timeout as a duration
+func (r Rule) ParseTimeout() (time.Duration, error) {
+	d, err := time.ParseDuration(r.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Timeout %q: %w", r.Timeout, err)
+	}
+	return d, nil
+}
+
+// validateCheck checks a declarative rule's fields suit its Kind, apart from
+// whether the names exist
+func (r Rule) validateCheck() error {
+	var errs []error
+	if r.Kind == CheckSchema {
+		if len(r.Tables) == 0 {
+			errs = append(errs, fmt.Errorf("kind %q requires Tables", r.Kind))
+		}
+		if r.Table != "" || len(r.Columns) > 0 || r.Where != "" {
+			errs = append(errs, fmt.Errorf("kind %q takes Tables instead of Table, Columns and Where", r.Kind))
+		}
+		return errors.Join(errs...)
+	}
+	if r.Table == "" {
+		errs = append(errs, fmt.Errorf("Table is empty"))
+	}
+	if len(r.Columns) == 0 {
+		errs = append(errs, fmt.Errorf("Columns is empty"))
+	}
+	if r.Kind != CheckProfile && (len(r.Expect) > 0 || r.TopN != 0) {
+		errs = append(errs, fmt.Errorf("Expect and TopN only apply to kind %q", CheckProfile))
+	}
+	switch r.Kind {
+	case CheckNotNull, CheckUnique:
+	case CheckProfile:
+		if r.TopN < 0 {
+			errs = append(errs, fmt.Errorf("TopN can't be negative"))
+		}
+		for _, s := range r.Expect {
+			e, err := ParseExpectation(s)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if e.Column != "" && !slices.Contains(r.Columns, e.Column) {
+				errs = append(errs, fmt.Errorf("expectation %q: column %q isn't in Columns", s, e.Column))
+			}
+		}
+		if r.IsCheck() != (len(r.Expect) > 0) {
+			errs = append(errs, fmt.Errorf("kind %q needs both a Severity and Expect to be a check, or neither", r.Kind))
+		}
+	case CheckReferences:
+		if r.RefTable == "" {
+			errs = append(errs, fmt.Errorf("kind %q requires RefTable", r.Kind))
+		}
+		if len(r.RefColumns) != len(r.Columns) {
+			errs = append(errs, fmt.Errorf("RefColumns must list one column for each of Columns"))
+		}
+	case CheckFreshness:
+		if len(r.Columns) > 1 {
+			errs = append(errs, fmt.Errorf("kind %q takes a single timestamp column", r.Kind))
+		}
+		if d, err := r.ParseMaxAge(); err != nil {
+			errs = append(errs, err)
+		} else if d <= 0 {
+			errs = append(errs, fmt.Errorf("MaxAge must be greater than zero"))
+		}
+	case CheckRange:
+		if r.Min == nil && r.Max == nil {
+			errs = append(errs, fmt.Errorf("kind %q requires Min, Max or both", r.Kind))
+		}
+		bounds := Params{}
+		if r.Min != nil {
+			bounds["Min"] = r.Min
+		}
+		if r.Max != nil {
+			bounds["Max"] = r.Max
+		}
+		if err := bounds.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		return fmt.Errorf("unknown Kind %q (expected %s, %s, %s, %s, %s, %s or %s)",
+			r.Kind, CheckNotNull, CheckUnique, CheckReferences, CheckFreshness, CheckRange, CheckSchema, CheckProfile)
+	}
+	return errors.Join(errs...)
+}
+
+// Reconcile turns a rule into a comparison of two queries on two servers,
+// such as an OLTP table and its warehouse copy. The rule's own DbType and
+// Query are unused; every difference found is a violation.
+type Reconcile struct {
+	// Mode is scalar (each side returns one value) or rows (keyed row sets)
+	Mode   string        `toml:"Mode"`
+	Source ReconcileSide `toml:"Source"`
+	Target ReconcileSide `toml:"Target"`
+	// KeyColumns identify matching rows in rows mode
+	KeyColumns []string `toml:"KeyColumns"`
+	// Tolerance is the absolute difference allowed between numeric values
+	Tolerance float64 `toml:"Tolerance"`
+	// TolerancePercent is the difference allowed relative to the source value
+	TolerancePercent float64 `toml:"TolerancePercent"`
+}
+
+// ReconcileSide is the query run on one of the compared servers
+type ReconcileSide struct {
+	Server string `toml:"Server"`
+	Query  string `toml:"Query"`
+}
+
+// Reconciliation modes
+const (
+	ReconcileScalar = "scalar"
+	ReconcileRows   = "rows"
+)
+
+// Validate checks the reconciliation is well formed, apart from whether its
+// servers exist
+func (r Reconcile) Validate() error {
+	var errs []error
+	switch r.Mode {
+	case ReconcileScalar:
+	case ReconcileRows:
+		if len(r.KeyColumns) == 0 {
+			errs = append(errs, fmt.Errorf("mode %q requires KeyColumns", ReconcileRows))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown Mode %q (expected %s or %s)", r.Mode, ReconcileScalar, ReconcileRows))
+	}
+	for _, side := range []struct {
+		name string
+		ReconcileSide
+	}{{"Source", r.Source}, {"Target", r.Target}} {
+		if side.Server == "" {
+			errs = append(errs, fmt.Errorf("%s.Server is empty", side.name))
+		}
+		if strings.TrimSpace(side.Query) == "" {
+			errs = append(errs, fmt.Errorf("%s.Query is empty", side.name))
+		}
+	}
+	if r.Tolerance < 0 || r.TolerancePercent < 0 {
+		errs = append(errs, fmt.Errorf("Tolerance and TolerancePercent can't be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// Baseline turns a rule into a metric check: the query returns a single
+// number, which is compared against the rule's own history on the server and
+// flagged as a violation when it strays too far
+type Baseline struct {
+	// Method is how the expected value is derived from history
+	Method string `toml:"Method"`
+	// Threshold is the allowed deviation: standard deviations for stddev,
+	// percent for percent_change and same_hour_last_week
+	Threshold float64 `toml:"Threshold"`
+	// Direction limits anomalies to drops or rises; both by default
+	Direction string `toml:"Direction"`
+	// Window is how many previous runs form the baseline. Defaults to 20 for
+	// stddev and 1 (the previous run) for percent_change.
+	Window int `toml:"Window"`
+	// MinSamples is how much history is needed before values are judged.
+	// Defaults to 5 for stddev and Window for percent_change.
+	MinSamples int `toml:"MinSamples"`
+}
+
+// Baseline methods
+const (
+	BaselineStdDev           = "stddev"              // rolling mean and standard deviation
+	BaselinePercentChange    = "percent_change"      // change from the mean of recent runs
+	BaselineSameHourLastWeek = "same_hour_last_week" // change from the run a week earlier
+)
+
+// Baseline directions
+const (
+	DirectionBoth = "both"
+	DirectionDrop = "drop"
+	DirectionRise = "rise"
+)
+
+// Validate checks the baseline is well formed
+func (b Baseline) Validate() error {
+	var errs []error
+	switch b.Method {
+	case BaselineStdDev, BaselinePercentChange, BaselineSameHourLastWeek:
+	default:
+		errs = append(errs, fmt.Errorf("unknown Method %q (expected %s, %s or %s)",
+			b.Method, BaselineStdDev, BaselinePercentChange, BaselineSameHourLastWeek))
+	}
+	if b.Threshold <= 0 {
+		errs = append(errs, fmt.Errorf("Threshold must be greater than zero"))
+	}
+	switch b.Direction {
+	case "", DirectionBoth, DirectionDrop, DirectionRise:
+	default:
+		errs = append(errs, fmt.Errorf("unknown Direction %q (expected %s, %s or %s)",
+			b.Direction, DirectionBoth, DirectionDrop, DirectionRise))
+	}
+	if b.Window < 0 || b.MinSamples < 0 {
+		errs = append(errs, fmt.Errorf("Window and MinSamples can't be negative"))
+	}
+	if b.Method == BaselineStdDev && b.MinSamples == 1 {
+		errs = append(errs, fmt.Errorf("MinSamples must be at least 2 for %s", BaselineStdDev))
+	}
+	return errors.Join(errs...)
+}
+
+// RetryPolicy reruns a rule when it fails with an error that's likely to
+// pass on its own, such as being chosen as a deadlock victim or a dropped
+// connection
+type RetryPolicy struct {
+	// MaxAttempts counts the first run; 1 or less never retries
+	MaxAttempts int `toml:"MaxAttempts"`
+	// Backoff is the delay before the first retry, doubling for each retry
+	// after it up to MaxBackoff. They default to 1s and 30s.
+	Backoff    string `toml:"Backoff"`
+	MaxBackoff string `toml:"MaxBackoff"`
+	// Jitter randomly shortens each delay by up to this fraction, from 0 to 1
+	Jitter float64 `toml:"Jitter"`
+}
+
+// Default retry delays
+const (
+	DefaultBackoff    = time.Second
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// ParseBackoff returns the policy's first and longest retry delays
+func (p RetryPolicy) ParseBackoff() (backoff, maxBackoff time.Duration, err error) {
+	backoff, maxBackoff = DefaultBackoff, DefaultMaxBackoff
+	if p.Backoff != "" {
+		if backoff, err = time.ParseDuration(p.Backoff); err != nil {
+			return 0, 0, fmt.Errorf("invalid Backoff %q: %w", p.Backoff, err)
+		}
+	}
+	if p.MaxBackoff != "" {
+		if maxBackoff, err = time.ParseDuration(p.MaxBackoff); err != nil {
+			return 0, 0, fmt.Errorf("invalid MaxBackoff %q: %w", p.MaxBackoff, err)
+		}
+	}
+	return backoff, maxBackoff, nil
+}
+
+func (p RetryPolicy) Validate() error {
+	var errs []error
+	if p.MaxAttempts < 0 {
+		errs = append(errs, fmt.Errorf("MaxAttempts can't be negative"))
+	}
+	backoff, maxBackoff, err := p.ParseBackoff()
+	if err != nil {
+		errs = append(errs, err)
+	} else if backoff < 0 || maxBackoff < backoff {
+		errs = append(errs, fmt.Errorf("Backoff must be between zero and MaxBackoff (%s)", maxBackoff))
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		errs = append(errs, fmt.Errorf("Jitter must be between 0 and 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// Params are named values substituted into a rule's query: :name as a bound
+// parameter, {{name}} as a quoted identifier
+type Params map[string]interface{}
+
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Validate checks parameter names are usable in a query and values are
+// scalars a driver can bind
+func (p Params) Validate() error {
+	var errs []error
+	for name, val := range p {
+		if !paramNamePattern.MatchString(name) {
+			errs = append(errs, fmt.Errorf("invalid parameter name %q", name))
+			continue
+		}
+		switch val.(type) {
+		case string, int64, float64, bool, time.Time:
+		default:
+			errs = append(errs, fmt.Errorf("parameter %q: unsupported value type %T", name, val))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MaskRule hides sensitive data in rule results. Column is a case-insensitive
+// glob matched against column names; Value is a regular expression matched
+// against cell values. Exactly one of them must be set.
+type MaskRule struct {
+	Column string `toml:"Column"`
+	Value  string `toml:"Value"`
+	Mode   string `toml:"Mode"`
+	// KeyRef is the configuration's MaskingKey, filled in by EffectiveMasking
+	KeyRef string `toml:"-"`
+}
+
+// HashKey resolves the key hash mode is computed with
+func (m MaskRule) HashKey() (string, error) {
+	if m.KeyRef == "" {
+		return "", fmt.Errorf("mode %q requires a MaskingKey", MaskHash)
+	}
+	key, err := secrets.Resolve(m.KeyRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve MaskingKey: %w", err)
+	}
+	if key == "" {
+		return "", fmt.Errorf("MaskingKey is empty")
+	}
+	return key, nil
+}
+
+// Masking modes
+const (
+	MaskHash    = "hash"    // replace with a stable HMAC-SHA256 of the value
+	MaskPartial = "partial" // keep a few characters for recognition
+	MaskRedact  = "redact"  // replace with a fixed placeholder
+	MaskDrop    = "drop"    // remove the column entirely (Column rules only)
+)
+
+// Validate checks the mask rule is well formed
+func (m MaskRule) Validate() error {
+	if (m.Column == "") == (m.Value == "") {
+		return fmt.Errorf("exactly one of Column or Value must be set")
+	}
+	if m.Column != "" {
+		if _, err := path.Match(m.Column, ""); err != nil {
+			return fmt.Errorf("invalid Column pattern %q: %w", m.Column, err)
+		}
+	}
+	if m.Value != "" {
+		if _, err := regexp.Compile(m.Value); err != nil {
+			return fmt.Errorf("invalid Value pattern %q: %w", m.Value, err)
+		}
+	}
+	switch m.Mode {
+	case MaskHash, MaskPartial, MaskRedact:
+	case MaskDrop:
+		if m.Column == "" {
+			return fmt.Errorf("mode %q requires a Column pattern", MaskDrop)
+		}
+	default:
+		return fmt.Errorf("unknown Mode %q (expected %s, %s, %s or %s)", m.Mode, MaskHash, MaskPartial, MaskRedact, MaskDrop)
+	}
+	return nil
+}
+
+// Rule severities. A rule with a severity is a check: any row it returns is a
+// violation. Rules without one are informational and never violate.
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// SeverityRank orders severities for threshold comparisons. Informational
+// rules (no severity) rank 0.
+func SeverityRank(severity string) int {
+	switch severity {
+	case SeverityWarning:
+		return 1
+	case SeverityCritical:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// IsCheck reports whether rows returned by the rule count as violations
+func (r Rule) IsCheck() bool {
+	return r.Severity != ""
 }
 
 func LoadConfigBytes(data []byte) (Config, error) {
 	var payload struct {
-		DBServers []DbServer `toml:"db_servers"`
-		Rules     []Rule     `toml:"rules"`
-		Schedules []Schedule `toml:"schedules"`
+		Timezone    string              `toml:"Timezone"`
+		MaskingKey  string              `toml:"MaskingKey"`
+		DBServers   []DbServer          `toml:"db_servers"`
+		Rules       []Rule              `toml:"rules"`
+		Schedules   []Schedule          `toml:"schedules"`
+		Masking     []MaskRule          `toml:"masking"`
+		Maintenance []MaintenanceWindow `toml:"maintenance"`
+		Calendars   []Calendar          `toml:"calendars"`
 	}
 	if err := toml.Unmarshal(data, &payload); err != nil {
 		return Config{}, fmt.Errorf("error during Config Unmarshal(): %w", err)
 	}
 
 	config := Config{
-		DBServers: payload.DBServers,
-		Rules:     payload.Rules,
-		Schedules: payload.Schedules,
+		Timezone:    payload.Timezone,
+		MaskingKey:  payload.MaskingKey,
+		DBServers:   payload.DBServers,
+		Rules:       payload.Rules,
+		Schedules:   payload.Schedules,
+		Masking:     payload.Masking,
+		Maintenance: payload.Maintenance,
+		Calendars:   payload.Calendars,
 	}
 	return config, nil
 }
 
+// NormalizeDbTypes rewrites server Types and rule DbTypes to the canonical
+// names returned by canonical, so aliases like "postgresql" match "postgres".
+// The db package's dialect registry provides canonical; config can't import
+// it directly since db depends on config.
+func (c *Config) NormalizeDbTypes(canonical func(dbType string) (string, error)) error {
+	var errs []error
+	for i, srv := range c.DBServers {
+		name, err := canonical(srv.Type)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %q: %w", srv.Name, err))
+			continue
+		}
+		c.DBServers[i].Type = name
+	}
+	for i, rule := range c.Rules {
+		if rule.Reconcile != nil && rule.DbType == "" {
+			// Reconciliations take their types from their servers
+			continue
+		}
+		name, err := canonical(rule.DbType)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: %w", rule.Name, err))
+			continue
+		}
+		c.Rules[i].DbType = name
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks that the configuration is internally consistent: names are
+// unique, rules can be matched to a server, and schedules reference rules and
+// servers that exist
+func (c Config) Validate() error {
+	var errs []error
+
+	// Schedules are checked against local time instead of an invalid global
+	// Timezone, so it's reported once
+	scheduleConfig := c
+	if _, err := loadTimezone(c.Timezone); err != nil {
+		errs = append(errs, err)
+		scheduleConfig.Timezone = ""
+	}
+
+	servers := make(map[string]bool)
+	serverTypes := make(map[string]bool)
+	serverTypeByName := make(map[string]string)
+	for _, srv := range c.DBServers {
+		if srv.Name == "" {
+			errs = append(errs, fmt.Errorf("db_servers: server with empty Name"))
+			continue
+		}
+		if servers[srv.Name] {
+			errs = append(errs, fmt.Errorf("db_servers: duplicate server name %q", srv.Name))
+		}
+		servers[srv.Name] = true
+		serverTypes[srv.Type] = true
+		serverTypeByName[srv.Name] = srv.Type
+
+		errs = append(errs, srv.validateConnection()...)
+		if err := srv.Params.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("server %q: Params: %w", srv.Name, err))
+		}
+		if srv.Retry != nil {
+			if err := srv.Retry.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("server %q: Retry: %w", srv.Name, err))
+			}
+		}
+	}
+
+	rules := make(map[string]bool)
+	ruleTypes := make(map[string]string)
+	reconciliations := make(map[string]bool)
+	for _, rule := range c.Rules {
+		if rule.Name == "" {
+			errs = append(errs, fmt.Errorf("rules: rule with empty Name"))
+			continue
+		}
+		if rules[rule.Name] {
+			errs = append(errs, fmt.Errorf("rules: duplicate rule name %q", rule.Name))
+		}
+		rules[rule.Name] = true
+		ruleTypes[rule.Name] = rule.DbType
+		if rule.Reconcile != nil {
+			reconciliations[rule.Name] = true
+		}
+
+		if rule.Reconcile != nil {
+			if err := rule.Reconcile.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("rule %q: Reconcile: %w", rule.Name, err))
+			}
+			for _, side := range []ReconcileSide{rule.Reconcile.Source, rule.Reconcile.Target} {
+				if side.Server != "" && !servers[side.Server] {
+					errs = append(errs, fmt.Errorf("rule %q: Reconcile: unknown server %q", rule.Name, side.Server))
+				}
+			}
+			if !rule.IsCheck() {
+				errs = append(errs, fmt.Errorf("rule %q: Reconcile requires a Severity", rule.Name))
+			}
+			if rule.Baseline != nil {
+				errs = append(errs, fmt.Errorf("rule %q: Reconcile and Baseline can't be combined", rule.Name))
+			}
+			if rule.IsDeclarative() {
+				errs = append(errs, fmt.Errorf("rule %q: Reconcile and Kind can't be combined", rule.Name))
+			}
+			if len(rule.Setup) > 0 || len(rule.Teardown) > 0 {
+				errs = append(errs, fmt.Errorf("rule %q: Reconcile can't be combined with Setup or Teardown", rule.Name))
+			}
+		} else {
+			if rule.IsDeclarative() {
+				if err := rule.validateCheck(); err != nil {
+					errs = append(errs, fmt.Errorf("rule %q: %w", rule.Name, err))
+				}
+				if strings.TrimSpace(rule.Query) != "" {
+					errs = append(errs, fmt.Errorf("rule %q: Kind and Query can't be combined", rule.Name))
+				}
+				if !rule.IsCheck() && rule.Kind != CheckProfile {
+					errs = append(errs, fmt.Errorf("rule %q: Kind requires a Severity", rule.Name))
+				}
+				if rule.Baseline != nil {
+					errs = append(errs, fmt.Errorf("rule %q: Kind and Baseline can't be combined", rule.Name))
+				}
+			} else if strings.TrimSpace(rule.Query) == "" {
+				errs = append(errs, fmt.Errorf("rule %q: Query is empty", rule.Name))
+			}
+			if !serverTypes[rule.DbType] {
+				errs = append(errs, fmt.Errorf("rule %q: no server configured for DbType %q", rule.Name, rule.DbType))
+			}
+		}
+		switch rule.Severity {
+		case "", SeverityWarning, SeverityCritical:
+		default:
+			errs = append(errs, fmt.Errorf("rule %q: unknown Severity %q (expected %q or %q)",
+				rule.Name, rule.Severity, SeverityWarning, SeverityCritical))
+		}
+		for i, m := range rule.Masking {
+			if err := m.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("rule %q: Masking[%d]: %w", rule.Name, i, err))
+			}
+		}
+		if err := rule.Params.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: Params: %w", rule.Name, err))
+		}
+		if rule.Retry != nil {
+			if err := rule.Retry.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("rule %q: Retry: %w", rule.Name, err))
+			}
+		}
+		for i, stmt := range rule.Setup {
+			if strings.TrimSpace(stmt) == "" {
+				errs = append(errs, fmt.Errorf("rule %q: Setup[%d] is empty", rule.Name, i))
+			}
+		}
+		for i, stmt := range rule.Teardown {
+			if strings.TrimSpace(stmt) == "" {
+				errs = append(errs, fmt.Errorf("rule %q: Teardown[%d] is empty", rule.Name, i))
+			}
+		}
+		if rule.Timeout != "" {
+			if d, err := rule.ParseTimeout(); err != nil {
+				errs = append(errs, fmt.Errorf("rule %q: %w", rule.Name, err))
+			} else if d <= 0 {
+				errs = append(errs, fmt.Errorf("rule %q: Timeout must be greater than zero", rule.Name))
+			}
+		}
+		if rule.Expression != "" {
+			if _, err := expression.Compile(rule.Expression); err != nil {
+				errs = append(errs, fmt.Errorf("rule %q: Expression: %w", rule.Name, err))
+			}
+			if !rule.IsCheck() {
+				errs = append(errs, fmt.Errorf("rule %q: Expression requires a Severity", rule.Name))
+			}
+			if rule.Baseline != nil || rule.Reconcile != nil || rule.IsDeclarative() {
+				errs = append(errs, fmt.Errorf("rule %q: Expression can't be combined with Baseline, Reconcile or Kind", rule.Name))
+			}
+		}
+		if rule.Baseline != nil {
+			if err := rule.Baseline.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("rule %q: Baseline: %w", rule.Name, err))
+			}
+			if !rule.IsCheck() {
+				errs = append(errs, fmt.Errorf("rule %q: Baseline requires a Severity", rule.Name))
+			}
+		}
+	}
+
+	for i, m := range c.Masking {
+		if err := m.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("masking[%d]: %w", i, err))
+		}
+	}
+	if c.MaskingKey != "" {
+		if err := secrets.Validate(c.MaskingKey); err != nil {
+			errs = append(errs, fmt.Errorf("MaskingKey: %w", err))
+		}
+	} else if c.usesHashMasking() {
+		// An unkeyed digest of a short value such as an SSN can be reversed
+		// by hashing every possible value
+		errs = append(errs, fmt.Errorf("masking: mode %q requires a MaskingKey", MaskHash))
+	}
+
+	calendars := make(map[string]bool)
+	for i, cal := range c.Calendars {
+		if cal.Name == "" {
+			errs = append(errs, fmt.Errorf("calendars[%d]: calendar with empty Name", i))
+			continue
+		}
+		if calendars[cal.Name] {
+			errs = append(errs, fmt.Errorf("calendars: duplicate calendar name %q", cal.Name))
+		}
+		calendars[cal.Name] = true
+		if err := cal.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("calendar %q: %w", cal.Name, err))
+		}
+	}
+
+	for i, sched := range c.Schedules {
+		if !rules[sched.Rule] {
+			errs = append(errs, fmt.Errorf("schedules[%d]: unknown rule %q", i, sched.Rule))
+		}
+		if sched.Server != "" && !servers[sched.Server] {
+			errs = append(errs, fmt.Errorf("schedules[%d]: unknown server %q", i, sched.Server))
+		} else if sched.Server != "" && reconciliations[sched.Rule] {
+			errs = append(errs, fmt.Errorf("schedules[%d]: reconciliation rule %q runs on its own servers; leave Server empty", i, sched.Rule))
+		} else if sched.Server != "" && rules[sched.Rule] && serverTypeByName[sched.Server] != ruleTypes[sched.Rule] {
+			errs = append(errs, fmt.Errorf("schedules[%d]: server %q is %s but rule %q needs %s",
+				i, sched.Server, serverTypeByName[sched.Server], sched.Rule, ruleTypes[sched.Rule]))
+		}
+		if _, _, err := scheduleConfig.ParseSchedule(sched); err != nil {
+			errs = append(errs, fmt.Errorf("schedules[%d]: %w", i, err))
+		}
+		if sched.Calendar != "" && !calendars[sched.Calendar] {
+			errs = append(errs, fmt.Errorf("schedules[%d]: unknown calendar %q", i, sched.Calendar))
+		}
+		if sched.BusinessDay != 0 && sched.Calendar == "" {
+			errs = append(errs, fmt.Errorf("schedules[%d]: BusinessDay needs a Calendar", i))
+		} else if sched.BusinessDay < -31 || sched.BusinessDay > 31 {
+			errs = append(errs, fmt.Errorf("schedules[%d]: BusinessDay %d is outside -31 to 31", i, sched.BusinessDay))
+		}
+		if err := sched.Params.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("schedules[%d]: Params: %w", i, err))
+		}
+	}
+
+	if _, err := c.RuleOrder(); err != nil {
+		errs = append(errs, fmt.Errorf("rules: %w", err))
+	}
+
+	windows := make(map[string]bool)
+	for i, w := range c.Maintenance {
+		if w.Name == "" {
+			errs = append(errs, fmt.Errorf("maintenance[%d]: window with empty Name", i))
+			continue
+		}
+		if windows[w.Name] {
+			errs = append(errs, fmt.Errorf("maintenance: duplicate window name %q", w.Name))
+		}
+		windows[w.Name] = true
+		if loc, err := scheduleConfig.MaintenanceLocation(w); err != nil {
+			errs = append(errs, fmt.Errorf("maintenance %q: %w", w.Name, err))
+		} else if err := w.Validate(loc); err != nil {
+			errs = append(errs, fmt.Errorf("maintenance %q: %w", w.Name, err))
+		}
+		for _, name := range w.Servers {
+			if !servers[name] {
+				errs = append(errs, fmt.Errorf("maintenance %q: unknown server %q", w.Name, name))
+			}
+		}
+		for _, name := range w.Rules {
+			if !rules[name] {
+				errs = append(errs, fmt.Errorf("maintenance %q: unknown rule %q", w.Name, name))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// MaintenanceWindow is a period during which rules are skipped, or run with
+// their results muted. It either recurs, opening at each time Cron matches
+// and staying open for Duration, or covers the single range Start to End.
+type MaintenanceWindow struct {
+	Name string `toml:"Name"`
+	// Cron uses the same six-field format as schedules, e.g. "0 0 2 * * SUN"
+	Cron     string `toml:"Cron"`
+	Duration string `toml:"Duration"`
+	// Start and End are RFC 3339 times, or "2006-01-02 15:04" in Timezone
+	Start string `toml:"Start"`
+	End   string `toml:"End"`
+	// Timezone is an IANA name such as "Europe/London"; the global Timezone
+	// by default
+	Timezone string `toml:"Timezone"`
+	// Mode is skip (the default) or mute
+	Mode string `toml:"Mode"`
+	// Servers and Rules limit the window to rules on those servers and to
+	// those rules. With neither it applies to every rule.
+	Servers []string `toml:"Servers"`
+	Rules   []string `toml:"Rules"`
+	// Reason says why the window exists, and is recorded with the rules it
+	// skips or mutes
+	Reason string `toml:"Reason"`
+}
+
+// Maintenance window modes
+const (
+	MaintenanceSkip = "skip" // don't run the rule; record it as skipped
+	MaintenanceMute = "mute" // run the rule, but don't report its failures
+)
+
+// maintenanceTimeLayout is the local time format accepted for Start and End
+const maintenanceTimeLayout = "2006-01-02 15:04"
+
+func parseWindowTime(field, value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(maintenanceTimeLayout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q: expected RFC 3339 or %q", field, value, maintenanceTimeLayout)
+	}
+	return t, nil
+}
+
+// Range returns when the window is open around t: the occurrence covering t
+// for recurring windows, or its only range. loc is the timezone the window is
+// read in, from Config.MaintenanceLocation.
+func (w MaintenanceWindow) Range(t time.Time, loc *time.Location) (start, end time.Time, err error) {
+	if w.Cron == "" {
+		if start, err = parseWindowTime("Start", w.Start, loc); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if end, err = parseWindowTime("End", w.End, loc); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		return start, end, nil
+	}
+
+	sched, err := cronParser.Parse(w.Cron)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid Cron %q: %w", w.Cron, err)
+	}
+	d, err := time.ParseDuration(w.Duration)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid Duration %q: %w", w.Duration, err)
+	}
+	// The latest opening within Duration before t, if any; otherwise the next
+	start = sched.Next(t.In(loc).Add(-d))
+	return start, start.Add(d), nil
+}
+
+// Active reports whether the window, read in loc, is open at t
+func (w MaintenanceWindow) Active(t time.Time, loc *time.Location) bool {
+	start, end, err := w.Range(t, loc)
+	return err == nil && !t.Before(start) && t.Before(end)
+}
+
+// Applies reports whether the window covers a rule run on any of servers
+func (w MaintenanceWindow) Applies(rule string, servers ...string) bool {
+	if len(w.Servers) == 0 && len(w.Rules) == 0 {
+		return true
+	}
+	if slices.Contains(w.Rules, rule) {
+		return true
+	}
+	for _, server := range servers {
+		if slices.Contains(w.Servers, server) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the window as read in loc
+func (w MaintenanceWindow) Validate(loc *time.Location) error {
+	var errs []error
+	switch {
+	case w.Cron != "" && (w.Start != "" || w.End != ""):
+		errs = append(errs, fmt.Errorf("set either Cron and Duration or Start and End, not both"))
+	case w.Cron != "":
+		if d, err := time.ParseDuration(w.Duration); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("Cron needs a positive Duration, e.g. \"4h\""))
+		}
+	case w.Start == "" || w.End == "":
+		errs = append(errs, fmt.Errorf("set either Cron and Duration or Start and End"))
+	}
+	if len(errs) == 0 {
+		if start, end, err := w.Range(time.Now(), loc); err != nil {
+			errs = append(errs, err)
+		} else if w.Cron == "" && !end.After(start) {
+			errs = append(errs, fmt.Errorf("End must be after Start"))
+		}
+	}
+	switch w.Mode {
+	case "", MaintenanceSkip, MaintenanceMute:
+	default:
+		errs = append(errs, fmt.Errorf("unknown Mode %q (expected %s or %s)", w.Mode, MaintenanceSkip, MaintenanceMute))
+	}
+	return errors.Join(errs...)
+}
+
+// RuleOrder returns the rules ordered so that each follows the rules it
+// depends on, otherwise keeping their configured order. It fails when a rule
+// depends on an unknown rule or the dependencies form a cycle.
+func (c Config) RuleOrder() ([]Rule, error) {
+	byName := make(map[string]Rule, len(c.Rules))
+	for _, rule := range c.Rules {
+		byName[rule.Name] = rule
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var order []Rule
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			cycle := append(slices.Clone(path[slices.Index(path, name):]), name)
+			return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+		state[name] = visiting
+		path = append(path, name)
+		rule := byName[name]
+		for _, dep := range rule.DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return fmt.Errorf("rule %q: DependsOn: unknown rule %q", name, dep)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		order = append(order, rule)
+		return nil
+	}
+
+	for _, rule := range c.Rules {
+		if err := visit(rule.Name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// DependencyChain returns the named rule preceded by every rule it depends
+// on, directly or not, in the order they should run
+func (c Config) DependencyChain(name string) ([]Rule, error) {
+	order, err := c.RuleOrder()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]Rule, len(order))
+	for _, rule := range order {
+		byName[rule.Name] = rule
+	}
+	if _, ok := byName[name]; !ok {
+		return nil, fmt.Errorf("rule not found: %s", name)
+	}
+
+	needed := map[string]bool{name: true}
+	pending := []string{name}
+	for len(pending) > 0 {
+		rule := byName[pending[0]]
+		pending = pending[1:]
+		for _, dep := range rule.DependsOn {
+			if !needed[dep] {
+				needed[dep] = true
+				pending = append(pending, dep)
+			}
+		}
+	}
+
+	var chain []Rule
+	for _, rule := range order {
+		if needed[rule.Name] {
+			chain = append(chain, rule)
+		}
+	}
+	return chain, nil
+}
+
+func (server DbServer) validateConnection() []error {
+	var errs []error
+	sources := 0
+	for _, set := range []bool{server.ConnString != "", server.ConnStringVar != "", server.Host != "", server.Path != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return []error{fmt.Errorf("server %q: exactly one of ConnString, ConnStringVar, Host or Path must be set", server.Name)}
+	}
+
+	if !server.IsStructured() {
+		if err := secrets.Validate(server.SecretRef()); err != nil {
+			errs = append(errs, fmt.Errorf("server %q: ConnString: %w", server.Name, err))
+		}
+		return errs
+	}
+
+	if server.Port < 0 || server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server %q: Port %d out of range", server.Name, server.Port))
+	}
+	if server.PasswordSecret != "" {
+		if err := secrets.Validate(server.PasswordSecret); err != nil {
+			errs = append(errs, fmt.Errorf("server %q: PasswordSecret: %w", server.Name, err))
+		}
+	}
+	switch server.TLS {
+	case "", TLSDisable, TLSRequire, TLSVerifyCA, TLSVerifyFull:
+	default:
+		errs = append(errs, fmt.Errorf("server %q: unknown TLS mode %q (expected %s, %s, %s or %s)",
+			server.Name, server.TLS, TLSDisable, TLSRequire, TLSVerifyCA, TLSVerifyFull))
+	}
+	return errs
+}
+
+// SecretRef returns the secret reference holding the server's connection
+// string. ConnString takes a reference such as "file:/run/secrets/pg";
+// ConnStringVar is shorthand for "env:<var>".
+func (server DbServer) SecretRef() string {
+	if server.ConnString != "" {
+		return server.ConnString
+	}
+	return "env:" + server.ConnStringVar
+}
+
 func (server DbServer) GetConnString() (string, error) {
-	connStr := os.Getenv(server.ConnStringVar)
-	if connStr == "" {
-		return "", fmt.Errorf("environment variable %s not found or empty", server.ConnStringVar)
+	return secrets.Resolve(server.SecretRef())
+}
+
+// GetPassword resolves the password for a structured connection. Servers
+// without a PasswordSecret connect without one.
+func (server DbServer) GetPassword() (string, error) {
+	if server.PasswordSecret == "" {
+		return "", nil
+	}
+	return secrets.Resolve(server.PasswordSecret)
+}
+
+// InvalidateConnString drops any cached connection string or password so the
+// next lookup resolves it again
+func (server DbServer) InvalidateConnString() {
+	if server.IsStructured() {
+		if server.PasswordSecret != "" {
+			secrets.Invalidate(server.PasswordSecret)
+		}
+		return
+	}
+	secrets.Invalidate(server.SecretRef())
+}
+
+// EffectiveMasking returns the mask rules that apply to a rule: its own rules
+// first, so they take precedence, followed by the global ones
+func (c Config) EffectiveMasking(rule Rule) []MaskRule {
+	masks := make([]MaskRule, 0, len(rule.Masking)+len(c.Masking))
+	masks = append(masks, rule.Masking...)
+	masks = append(masks, c.Masking...)
+	for i := range masks {
+		masks[i].KeyRef = c.MaskingKey
+	}
+	return masks
+}
+
+func (c Config) usesHashMasking() bool {
+	for _, m := range c.Masking {
+		if m.Mode == MaskHash {
+			return true
+		}
+	}
+	for _, rule := range c.Rules {
+		for _, m := range rule.Masking {
+			if m.Mode == MaskHash {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Built-in parameters holding a rule's high-water mark on a server: the start
+// time of its last successful run there, unless set by hand. Both names bind
+// the same value; the runner fills them in from storage.
+const (
+	ParamWatermark     = "watermark"
+	ParamLastSuccessAt = "last_success_at"
+)
+
+// DefaultWatermark is bound before a rule's first successful run on a server,
+// unless the rule's Params give its own starting point
+var DefaultWatermark = time.Unix(0, 0).UTC()
+
+// EffectiveRetry returns the retry policy for a rule on a server: the rule's
+// own, else the server's, else none
+func (c Config) EffectiveRetry(rule Rule, server DbServer) RetryPolicy {
+	if rule.Retry != nil {
+		return *rule.Retry
+	}
+	if server.Retry != nil {
+		return *server.Retry
+	}
+	return RetryPolicy{}
+}
+
+// EffectiveParams returns the parameters a rule runs with. Server params
+// override the rule's defaults and schedule params override both, so one
+// rule can be pointed at many tenants. Pass a zero Schedule for ad-hoc runs.
+func (c Config) EffectiveParams(rule Rule, server DbServer, schedule Schedule) Params {
+	params := Params{
+		ParamWatermark:     DefaultWatermark,
+		ParamLastSuccessAt: DefaultWatermark,
+	}
+	for _, layer := range []Params{rule.Params, server.Params, schedule.Params} {
+		for k, v := range layer {
+			params[k] = v
+		}
 	}
-	return connStr, nil
+	return params
 }
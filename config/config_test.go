@@ -0,0 +1,822 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validConfig() Config {
+	return Config{
+		DBServers: []DbServer{
+			{Name: "pg", Type: "postgres", ConnStringVar: "PG_DBCONN"},
+		},
+		Rules: []Rule{
+			{Name: "negative-totals", DbType: "postgres", Query: "SELECT 1", Severity: SeverityCritical},
+		},
+		Schedules: []Schedule{
+			{Server: "pg", Rule: "negative-totals", CronStr: "0 */5 * * * *"},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		modify    func(c *Config)
+		expectErr string
+	}{
+		{
+			name:   "valid config",
+			modify: func(c *Config) {},
+		},
+		{
+			name: "duplicate rule name",
+			modify: func(c *Config) {
+				c.Rules = append(c.Rules, c.Rules[0])
+			},
+			expectErr: `duplicate rule name "negative-totals"`,
+		},
+		{
+			name: "rule without matching server",
+			modify: func(c *Config) {
+				c.Rules[0].DbType = "mysql"
+			},
+			expectErr: `no server configured for DbType "mysql"`,
+		},
+		{
+			name: "unknown severity",
+			modify: func(c *Config) {
+				c.Rules[0].Severity = "fatal"
+			},
+			expectErr: `unknown Severity "fatal"`,
+		},
+		{
+			name: "structured connection",
+			modify: func(c *Config) {
+				c.DBServers[0].ConnStringVar = ""
+				c.DBServers[0].Host = "db.internal"
+				c.DBServers[0].PasswordSecret = "file:/run/secrets/pg"
+				c.DBServers[0].TLS = TLSVerifyFull
+			},
+		},
+		{
+			name: "structured connection alongside connection string",
+			modify: func(c *Config) {
+				c.DBServers[0].Host = "db.internal"
+			},
+			expectErr: "exactly one of ConnString, ConnStringVar, Host or Path",
+		},
+		{
+			name: "unknown TLS mode",
+			modify: func(c *Config) {
+				c.DBServers[0].ConnStringVar = ""
+				c.DBServers[0].Host = "db.internal"
+				c.DBServers[0].TLS = "prefer"
+			},
+			expectErr: `unknown TLS mode "prefer"`,
+		},
+		{
+			name: "unknown secret provider",
+			modify: func(c *Config) {
+				c.DBServers[0].ConnStringVar = ""
+				c.DBServers[0].ConnString = "vault:pg"
+			},
+			expectErr: `unknown secret provider "vault"`,
+		},
+		{
+			name: "schedule with unknown rule",
+			modify: func(c *Config) {
+				c.Schedules[0].Rule = "missing"
+			},
+			expectErr: `unknown rule "missing"`,
+		},
+		{
+			name: "schedule server of another type",
+			modify: func(c *Config) {
+				c.DBServers = append(c.DBServers, DbServer{Name: "my", Type: "mysql", ConnStringVar: "MY_DBCONN"})
+				c.Schedules[0].Server = "my"
+			},
+			expectErr: `server "my" is mysql but rule "negative-totals" needs postgres`,
+		},
+		{
+			name: "baseline rule",
+			modify: func(c *Config) {
+				c.Rules[0].Baseline = &Baseline{Method: BaselineStdDev, Threshold: 3, Direction: DirectionDrop}
+			},
+		},
+		{
+			name: "baseline without severity",
+			modify: func(c *Config) {
+				c.Rules[0].Severity = ""
+				c.Rules[0].Baseline = &Baseline{Method: BaselinePercentChange, Threshold: 40}
+			},
+			expectErr: "Baseline requires a Severity",
+		},
+		{
+			name: "baseline with unknown method",
+			modify: func(c *Config) {
+				c.Rules[0].Baseline = &Baseline{Method: "median", Threshold: 40}
+			},
+			expectErr: `unknown Method "median"`,
+		},
+		{
+			name: "reconciliation rule",
+			modify: func(c *Config) {
+				c.Rules[0].DbType = ""
+				c.Rules[0].Query = ""
+				c.Rules[0].Reconcile = &Reconcile{
+					Mode:       ReconcileRows,
+					KeyColumns: []string{"id"},
+					Source:     ReconcileSide{Server: "pg", Query: "SELECT id, total FROM orders"},
+					Target:     ReconcileSide{Server: "pg", Query: "SELECT id, total FROM orders_copy"},
+				}
+				c.Schedules[0].Server = ""
+			},
+		},
+		{
+			name: "reconciliation with unknown server and missing keys",
+			modify: func(c *Config) {
+				c.Rules[0].Reconcile = &Reconcile{
+					Mode:   ReconcileRows,
+					Source: ReconcileSide{Server: "pg", Query: "SELECT 1"},
+					Target: ReconcileSide{Server: "warehouse", Query: "SELECT 1"},
+				}
+			},
+			expectErr: `unknown server "warehouse"`,
+		},
+		{
+			name: "reconciliation scheduled on a server",
+			modify: func(c *Config) {
+				c.Rules[0].Reconcile = &Reconcile{
+					Mode:   ReconcileScalar,
+					Source: ReconcileSide{Server: "pg", Query: "SELECT 1"},
+					Target: ReconcileSide{Server: "pg", Query: "SELECT 1"},
+				}
+			},
+			expectErr: "runs on its own servers",
+		},
+		{
+			name: "declarative check",
+			modify: func(c *Config) {
+				c.Rules[0].Query = ""
+				c.Rules[0].Kind = CheckReferences
+				c.Rules[0].Table = "sales.orders"
+				c.Rules[0].Columns = []string{"customer_id"}
+				c.Rules[0].RefTable = "sales.customers"
+				c.Rules[0].RefColumns = []string{"id"}
+			},
+		},
+		{
+			name: "declarative check with a query",
+			modify: func(c *Config) {
+				c.Rules[0].Kind = CheckNotNull
+				c.Rules[0].Table = "orders"
+				c.Rules[0].Columns = []string{"total"}
+			},
+			expectErr: "Kind and Query can't be combined",
+		},
+		{
+			name: "unknown check kind",
+			modify: func(c *Config) {
+				c.Rules[0].Query = ""
+				c.Rules[0].Kind = "positive"
+			},
+			expectErr: `unknown Kind "positive"`,
+		},
+		{
+			name: "freshness without max age",
+			modify: func(c *Config) {
+				c.Rules[0].Query = ""
+				c.Rules[0].Kind = CheckFreshness
+				c.Rules[0].Table = "orders"
+				c.Rules[0].Columns = []string{"created_at"}
+				c.Rules[0].MaxAge = "1 day"
+			},
+			expectErr: `invalid MaxAge "1 day"`,
+		},
+		{
+			name: "invalid timeout",
+			modify: func(c *Config) {
+				c.Rules[0].Timeout = "soon"
+			},
+			expectErr: `invalid Timeout "soon"`,
+		},
+		{
+			name: "zero timeout",
+			modify: func(c *Config) {
+				c.Rules[0].Timeout = "0s"
+			},
+			expectErr: "Timeout must be greater than zero",
+		},
+		{
+			name: "range without bounds",
+			modify: func(c *Config) {
+				c.Rules[0].Query = ""
+				c.Rules[0].Kind = CheckRange
+				c.Rules[0].Table = "orders"
+				c.Rules[0].Columns = []string{"total"}
+			},
+			expectErr: `kind "range" requires Min, Max or both`,
+		},
+		{
+			name: "references with mismatched keys",
+			modify: func(c *Config) {
+				c.Rules[0].Query = ""
+				c.Rules[0].Kind = CheckReferences
+				c.Rules[0].Table = "order_lines"
+				c.Rules[0].Columns = []string{"order_id", "line"}
+				c.Rules[0].RefTable = "orders"
+				c.Rules[0].RefColumns = []string{"id"}
+			},
+			expectErr: "RefColumns must list one column for each of Columns",
+		},
+		{
+			name: "schema check",
+			modify: func(c *Config) {
+				c.Rules[0].Query = ""
+				c.Rules[0].Kind = CheckSchema
+				c.Rules[0].Tables = []string{"sales.orders", "sales.customers"}
+			},
+		},
+		{
+			name: "schema check with columns",
+			modify: func(c *Config) {
+				c.Rules[0].Query = ""
+				c.Rules[0].Kind = CheckSchema
+				c.Rules[0].Table = "orders"
+				c.Rules[0].Columns = []string{"id"}
+			},
+			expectErr: `kind "schema" requires Tables`,
+		},
+		{
+			name: "informational profile",
+			modify: func(c *Config) {
+				c.Rules[0].Query = ""
+				c.Rules[0].Severity = ""
+				c.Rules[0].Kind = CheckProfile
+				c.Rules[0].Table = "customers"
+				c.Rules[0].Columns = []string{"email", "country"}
+			},
+		},
+		{
+			name: "profile expectation on an unprofiled column",
+			modify: func(c *Config) {
+				c.Rules[0].Query = ""
+				c.Rules[0].Kind = CheckProfile
+				c.Rules[0].Table = "customers"
+				c.Rules[0].Columns = []string{"email"}
+				c.Rules[0].Expect = []string{"row_count > 0", "null_ratio(phone) < 0.5"}
+			},
+			expectErr: `column "phone" isn't in Columns`,
+		},
+		{
+			name: "profile with severity but no expectations",
+			modify: func(c *Config) {
+				c.Rules[0].Query = ""
+				c.Rules[0].Kind = CheckProfile
+				c.Rules[0].Table = "customers"
+				c.Rules[0].Columns = []string{"email"}
+			},
+			expectErr: "needs both a Severity and Expect",
+		},
+		{
+			name: "expression",
+			modify: func(c *Config) {
+				c.Rules[0].Expression = "rows.all(r, r.balance >= 0) && row_count < 10"
+			},
+		},
+		{
+			name: "invalid expression",
+			modify: func(c *Config) {
+				c.Rules[0].Expression = "rows.all(r, r.balance >= 0) && rowcount < 10"
+			},
+			expectErr: `rule "negative-totals": Expression: invalid expression:
+ERROR: <input>:1:32: undeclared reference to 'rowcount'`,
+		},
+		{
+			name: "expression without severity",
+			modify: func(c *Config) {
+				c.Rules[0].Severity = ""
+				c.Rules[0].Expression = "row_count == 0"
+			},
+			expectErr: "Expression requires a Severity",
+		},
+		{
+			name: "setup and teardown",
+			modify: func(c *Config) {
+				c.Rules[0].Setup = []string{"SET search_path TO sales", "CREATE TEMP TABLE recent AS SELECT * FROM orders"}
+				c.Rules[0].Teardown = []string{"DROP TABLE IF EXISTS recent"}
+			},
+		},
+		{
+			name: "empty setup statement",
+			modify: func(c *Config) {
+				c.Rules[0].Setup = []string{"SET search_path TO sales", "  "}
+			},
+			expectErr: "Setup[1] is empty",
+		},
+		{
+			name: "reconciliation with teardown",
+			modify: func(c *Config) {
+				c.Rules[0].Reconcile = &Reconcile{
+					Mode:   ReconcileScalar,
+					Source: ReconcileSide{Server: "pg", Query: "SELECT 1"},
+					Target: ReconcileSide{Server: "pg", Query: "SELECT 1"},
+				}
+				c.Rules[0].Teardown = []string{"DISCARD ALL"}
+				c.Schedules[0].Server = ""
+			},
+			expectErr: "Reconcile can't be combined with Setup or Teardown",
+		},
+		{
+			name: "dependency on unknown rule",
+			modify: func(c *Config) {
+				c.Rules[0].DependsOn = []string{"etl-finished"}
+			},
+			expectErr: `rule "negative-totals": DependsOn: unknown rule "etl-finished"`,
+		},
+		{
+			name: "dependency cycle",
+			modify: func(c *Config) {
+				c.Rules[0].DependsOn = []string{"etl-finished"}
+				c.Rules = append(c.Rules,
+					Rule{Name: "etl-finished", DbType: "postgres", Query: "SELECT 1", Severity: SeverityCritical, DependsOn: []string{"loaded"}},
+					Rule{Name: "loaded", DbType: "postgres", Query: "SELECT 1", Severity: SeverityCritical, DependsOn: []string{"negative-totals"}})
+			},
+			expectErr: "dependency cycle: negative-totals -> etl-finished -> loaded -> negative-totals",
+		},
+		{
+			name: "retry policies",
+			modify: func(c *Config) {
+				c.DBServers[0].Retry = &RetryPolicy{MaxAttempts: 3, Backoff: "500ms", Jitter: 0.2}
+				c.Rules[0].Retry = &RetryPolicy{MaxAttempts: 1}
+			},
+		},
+		{
+			name: "invalid server retry",
+			modify: func(c *Config) {
+				c.DBServers[0].Retry = &RetryPolicy{MaxAttempts: 3, Backoff: "1m", MaxBackoff: "10s", Jitter: 1.5}
+			},
+			expectErr: `server "pg": Retry: Backoff must be between zero and MaxBackoff (10s)
+Jitter must be between 0 and 1`,
+		},
+		{
+			name: "invalid rule retry",
+			modify: func(c *Config) {
+				c.Rules[0].Retry = &RetryPolicy{MaxAttempts: 3, Backoff: "soon"}
+			},
+			expectErr: `rule "negative-totals": Retry: invalid Backoff "soon"`,
+		},
+		{
+			name: "schedule timezones",
+			modify: func(c *Config) {
+				c.Timezone = "America/New_York"
+				c.Schedules = append(c.Schedules,
+					Schedule{Rule: "negative-totals", CronStr: "0 0 6 * * MON-FRI", Timezone: "Europe/London"},
+					Schedule{Rule: "negative-totals", CronStr: "CRON_TZ=Asia/Tokyo 0 0 6 * * *"},
+					Schedule{Rule: "negative-totals", CronStr: "TZ=UTC @daily", Timezone: "UTC"})
+			},
+		},
+		{
+			name: "invalid cron string",
+			modify: func(c *Config) {
+				c.Schedules[0].CronStr = "*/5 * * * *"
+			},
+			expectErr: `schedules[0]: invalid CronStr "*/5 * * * *"`,
+		},
+		{
+			name: "unknown global timezone",
+			modify: func(c *Config) {
+				c.Timezone = "Business/Hours"
+			},
+			expectErr: `invalid Timezone "Business/Hours"`,
+		},
+		{
+			name: "unknown schedule timezone",
+			modify: func(c *Config) {
+				c.Schedules[0].CronStr = "CRON_TZ=Mars/Olympus 0 0 6 * * *"
+			},
+			expectErr: `schedules[0]: invalid Timezone "Mars/Olympus"`,
+		},
+		{
+			name: "conflicting schedule timezones",
+			modify: func(c *Config) {
+				c.Schedules[0].CronStr = "CRON_TZ=Asia/Tokyo 0 0 6 * * *"
+				c.Schedules[0].Timezone = "Europe/London"
+			},
+			expectErr: `schedules[0]: CronStr's CRON_TZ=Asia/Tokyo conflicts with Timezone "Europe/London"`,
+		},
+		{
+			name: "business calendar",
+			modify: func(c *Config) {
+				c.Calendars = []Calendar{{Name: "finance", Weekdays: []string{"Mon", "tuesday", "WED"}, Holidays: []string{"2026-12-25"}}}
+				c.Schedules[0].Calendar = "finance"
+				c.Schedules[0].BusinessDay = -1
+			},
+		},
+		{
+			name: "invalid calendar",
+			modify: func(c *Config) {
+				c.Calendars = []Calendar{{Name: "finance", Weekdays: []string{"Caturday"}, Holidays: []string{"25/12/2026"}}}
+			},
+			expectErr: `calendar "finance": unknown weekday "Caturday"
+invalid holiday "25/12/2026": expected YYYY-MM-DD`,
+		},
+		{
+			name: "schedule with unknown calendar",
+			modify: func(c *Config) {
+				c.Schedules[0].Calendar = "finance"
+			},
+			expectErr: `schedules[0]: unknown calendar "finance"`,
+		},
+		{
+			name: "business day without a calendar",
+			modify: func(c *Config) {
+				c.Schedules[0].BusinessDay = 1
+			},
+			expectErr: "schedules[0]: BusinessDay needs a Calendar",
+		},
+		{
+			name: "business day out of range",
+			modify: func(c *Config) {
+				c.Calendars = []Calendar{{Name: "finance"}}
+				c.Schedules[0].Calendar = "finance"
+				c.Schedules[0].BusinessDay = 40
+			},
+			expectErr: "schedules[0]: BusinessDay 40 is outside -31 to 31",
+		},
+		{
+			name: "hash masking with a key",
+			modify: func(c *Config) {
+				c.MaskingKey = "env:DATASPY_MASKING_KEY"
+				c.Rules[0].Masking = []MaskRule{{Column: "ssn", Mode: MaskHash}}
+			},
+		},
+		{
+			name: "hash masking without a key",
+			modify: func(c *Config) {
+				c.Rules[0].Masking = []MaskRule{{Column: "ssn", Mode: MaskHash}}
+			},
+			expectErr: `masking: mode "hash" requires a MaskingKey`,
+		},
+		{
+			name: "invalid masking key reference",
+			modify: func(c *Config) {
+				c.MaskingKey = "vault:mask"
+			},
+			expectErr: "MaskingKey: ",
+		},
+		{
+			name: "maintenance windows",
+			modify: func(c *Config) {
+				c.Timezone = "Europe/London"
+				c.Maintenance = []MaintenanceWindow{
+					{Name: "backups", Cron: "0 0 2 * * SUN", Duration: "2h", Timezone: "Europe/London", Servers: []string{"pg"}},
+					{Name: "upgrade", Start: "2026-03-01 22:00", End: "2026-03-02T01:00:00Z", Mode: MaintenanceMute},
+				}
+			},
+		},
+		{
+			name: "maintenance window without a duration",
+			modify: func(c *Config) {
+				c.Maintenance = []MaintenanceWindow{{Name: "backups", Cron: "@daily"}}
+			},
+			expectErr: `maintenance "backups": Cron needs a positive Duration`,
+		},
+		{
+			name: "maintenance window with both cron and range",
+			modify: func(c *Config) {
+				c.Maintenance = []MaintenanceWindow{{Name: "backups", Cron: "@daily", Duration: "1h", Start: "2026-03-01 22:00"}}
+			},
+			expectErr: "set either Cron and Duration or Start and End, not both",
+		},
+		{
+			name: "maintenance window ending before it starts",
+			modify: func(c *Config) {
+				c.Maintenance = []MaintenanceWindow{{Name: "upgrade", Start: "2026-03-02 01:00", End: "2026-03-01 22:00"}}
+			},
+			expectErr: "End must be after Start",
+		},
+		{
+			name: "maintenance window with unknown names",
+			modify: func(c *Config) {
+				c.Maintenance = []MaintenanceWindow{
+					{Name: "upgrade", Start: "2026-03-01 22:00", End: "2026-03-02 01:00", Timezone: "Mars/Olympus",
+						Mode: "silence", Servers: []string{"mysql"}, Rules: []string{"missing"}},
+					{Name: "upgrade", Start: "2026-03-01 22:00", End: "2026-03-02 01:00"},
+				}
+			},
+			expectErr: `maintenance "upgrade": invalid Timezone "Mars/Olympus"`,
+		},
+		{
+			name: "invalid parameter name",
+			modify: func(c *Config) {
+				c.Rules[0].Params = Params{"min-total": int64(0)}
+			},
+			expectErr: `invalid parameter name "min-total"`,
+		},
+		{
+			name: "unsupported parameter value",
+			modify: func(c *Config) {
+				c.Schedules[0].Params = Params{"schemas": []interface{}{"a", "b"}}
+			},
+			expectErr: `parameter "schemas": unsupported value type`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if tt.expectErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectErr)
+		})
+	}
+}
+
+func TestRuleOrder(t *testing.T) {
+	rule := func(name string, deps ...string) Rule {
+		return Rule{Name: name, DependsOn: deps}
+	}
+	cfg := Config{Rules: []Rule{
+		rule("report", "totals", "etl"),
+		rule("totals", "etl"),
+		rule("unrelated"),
+		rule("etl"),
+		rule("self", "self"),
+	}}
+	names := func(rules []Rule) []string {
+		var out []string
+		for _, r := range rules {
+			out = append(out, r.Name)
+		}
+		return out
+	}
+
+	_, err := cfg.RuleOrder()
+	assert.EqualError(t, err, "dependency cycle: self -> self")
+
+	cfg.Rules = cfg.Rules[:4]
+	order, err := cfg.RuleOrder()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"etl", "totals", "report", "unrelated"}, names(order))
+
+	chain, err := cfg.DependencyChain("totals")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"etl", "totals"}, names(chain))
+
+	chain, err = cfg.DependencyChain("unrelated")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"unrelated"}, names(chain))
+
+	_, err = cfg.DependencyChain("missing")
+	assert.EqualError(t, err, "rule not found: missing")
+}
+
+func TestEffectiveRetry(t *testing.T) {
+	cfg := validConfig()
+	server := DbServer{Name: "pg", Retry: &RetryPolicy{MaxAttempts: 3}}
+	rule := Rule{Name: "r"}
+
+	assert.Equal(t, RetryPolicy{}, cfg.EffectiveRetry(rule, DbServer{Name: "pg"}))
+	assert.Equal(t, RetryPolicy{MaxAttempts: 3}, cfg.EffectiveRetry(rule, server))
+	rule.Retry = &RetryPolicy{MaxAttempts: 5, Backoff: "2s"}
+	assert.Equal(t, RetryPolicy{MaxAttempts: 5, Backoff: "2s"}, cfg.EffectiveRetry(rule, server))
+
+	backoff, maxBackoff, err := rule.Retry.ParseBackoff()
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Second, backoff)
+	assert.Equal(t, DefaultMaxBackoff, maxBackoff)
+}
+
+func TestParseSchedule(t *testing.T) {
+	c := validConfig()
+	c.Timezone = "America/New_York"
+	// 2026-03-09 is the day after the US switches to daylight saving time
+	after := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		location string
+		next     time.Time
+	}{
+		{
+			name:     "global timezone",
+			schedule: Schedule{CronStr: "0 0 6 * * *"},
+			location: "America/New_York",
+			next:     time.Date(2026, 3, 9, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "schedule timezone",
+			schedule: Schedule{CronStr: "0 0 6 * * *", Timezone: "Europe/London"},
+			location: "Europe/London",
+			next:     time.Date(2026, 3, 9, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "CRON_TZ prefix",
+			schedule: Schedule{CronStr: "CRON_TZ=Asia/Tokyo 0 0 6 * * *"},
+			location: "Asia/Tokyo",
+			next:     time.Date(2026, 3, 9, 21, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched, loc, err := c.ParseSchedule(tt.schedule)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.location, loc.String())
+			assert.Equal(t, tt.next, sched.Next(after).UTC())
+		})
+	}
+
+	// Without any timezone, schedules run in local time
+	_, loc, err := validConfig().ParseSchedule(Schedule{CronStr: "@hourly"})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Local, loc)
+}
+
+func TestLoadHolidayFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holidays.txt")
+	err := os.WriteFile(path, []byte("# US market holidays\n2026-01-01\n\n  2026-01-19  \n"), 0600)
+	assert.NoError(t, err)
+
+	c := validConfig()
+	c.Calendars = []Calendar{{Name: "finance", Holidays: []string{"2026-12-25"}, HolidaysFile: path}}
+	assert.NoError(t, c.LoadHolidayFiles())
+	assert.Equal(t, []string{"2026-12-25", "2026-01-01", "2026-01-19"}, c.Calendars[0].Holidays)
+	assert.NoError(t, c.Validate())
+
+	c.Calendars = []Calendar{{Name: "finance", HolidaysFile: filepath.Join(t.TempDir(), "missing.txt")}}
+	assert.ErrorContains(t, c.LoadHolidayFiles(), `calendar "finance": failed to read HolidaysFile`)
+}
+
+func TestMaintenanceWindowActive(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	assert.NoError(t, err)
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+	// Sundays at 02:00 London time for two hours; 2026-03-01 is a Sunday in GMT
+	recurring := MaintenanceWindow{Name: "backups", Cron: "0 0 2 * * SUN", Duration: "2h", Timezone: "Europe/London"}
+	once := MaintenanceWindow{Name: "upgrade", Start: "2026-03-01 22:00", End: "2026-03-02 01:00", Timezone: "Europe/London"}
+	// Without its own Timezone a window is read in the global one
+	inherited := MaintenanceWindow{Name: "backups", Cron: "0 0 2 * * SUN", Duration: "2h"}
+	global := Config{Timezone: "America/New_York"}
+
+	tests := []struct {
+		name   string
+		window MaintenanceWindow
+		at     time.Time
+		active bool
+	}{
+		{"before the opening", recurring, time.Date(2026, 3, 1, 1, 59, 0, 0, london), false},
+		{"at the opening", recurring, time.Date(2026, 3, 1, 2, 0, 0, 0, london), true},
+		{"in another timezone", recurring, time.Date(2026, 3, 1, 3, 30, 0, 0, time.UTC), true},
+		{"at the close", recurring, time.Date(2026, 3, 1, 4, 0, 0, 0, london), false},
+		{"on a weekday", recurring, time.Date(2026, 3, 2, 3, 0, 0, 0, london), false},
+		{"inside a range", once, time.Date(2026, 3, 1, 23, 0, 0, 0, london), true},
+		{"after a range", once, time.Date(2026, 3, 2, 1, 0, 0, 0, london), false},
+		{"in the global timezone", inherited, time.Date(2026, 3, 1, 2, 30, 0, 0, newYork), true},
+		{"not in local time", inherited, time.Date(2026, 3, 1, 2, 30, 0, 0, london), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := global.MaintenanceLocation(tt.window)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.active, tt.window.Active(tt.at, loc))
+		})
+	}
+
+	cfg, err := LoadConfigBytes([]byte(`
+[[maintenance]]
+Name = "backups"
+Cron = "0 0 2 * * SUN"
+Duration = "2h"
+Servers = ["pg"]
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []MaintenanceWindow{{Name: "backups", Cron: "0 0 2 * * SUN", Duration: "2h", Servers: []string{"pg"}}}, cfg.Maintenance)
+
+	scoped := MaintenanceWindow{Servers: []string{"pg"}, Rules: []string{"orders"}}
+	assert.True(t, MaintenanceWindow{}.Applies("anything", "mysql"))
+	assert.True(t, scoped.Applies("orders", "mysql"))
+	assert.True(t, scoped.Applies("customers", "mysql", "pg"))
+	assert.False(t, scoped.Applies("customers", "mysql"))
+}
+
+func TestNormalizeDbTypes(t *testing.T) {
+	canonical := func(dbType string) (string, error) {
+		switch dbType {
+		case "postgres", "postgresql":
+			return "postgres", nil
+		}
+		return "", fmt.Errorf("unknown database type %q", dbType)
+	}
+
+	c := validConfig()
+	c.DBServers[0].Type = "postgresql"
+	assert.NoError(t, c.NormalizeDbTypes(canonical))
+	assert.Equal(t, "postgres", c.DBServers[0].Type)
+	assert.NoError(t, c.Validate())
+
+	c = validConfig()
+	c.DBServers[0].Type = "postgers"
+	c.Rules[0].DbType = "oracle"
+	err := c.NormalizeDbTypes(canonical)
+	assert.ErrorContains(t, err, `server "pg": unknown database type "postgers"`)
+	assert.ErrorContains(t, err, `rule "negative-totals": unknown database type "oracle"`)
+}
+
+func TestEffectiveParams(t *testing.T) {
+	c := validConfig()
+	rule := c.Rules[0]
+	rule.Params = Params{"schema": "public", "threshold": int64(10)}
+	server := c.DBServers[0]
+	server.Params = Params{"schema": "tenant_a"}
+	schedule := Schedule{Params: Params{"threshold": int64(50)}}
+
+	params := c.EffectiveParams(rule, server, schedule)
+	assert.Equal(t, "tenant_a", params["schema"])
+	assert.Equal(t, int64(50), params["threshold"])
+	assert.Equal(t, DefaultWatermark, params[ParamWatermark])
+	assert.Equal(t, int64(10), c.EffectiveParams(rule, server, Schedule{})["threshold"])
+	assert.Equal(t, Params{"schema": "public", "threshold": int64(10)}, rule.Params, "rule params must not be modified")
+}
+
+func TestLoadConfigBytesReconcile(t *testing.T) {
+	data := []byte(`
+[[db_servers]]
+Name = "oltp"
+Type = "mysql"
+ConnStringVar = "MYSQL_DBCONN"
+
+[[db_servers]]
+Name = "warehouse"
+Type = "postgres"
+ConnStringVar = "PG_DBCONN"
+
+[[rules]]
+Name = "Orders Match"
+Severity = "critical"
+
+[rules.Reconcile]
+Mode = "rows"
+KeyColumns = ["id"]
+Tolerance = 0.01
+Source = { Server = "oltp", Query = "SELECT id, total FROM orders" }
+Target = { Server = "warehouse", Query = "SELECT id, total FROM fact_orders" }
+`)
+
+	c, err := LoadConfigBytes(data)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Validate())
+	if assert.NotNil(t, c.Rules[0].Reconcile) {
+		assert.Equal(t, "warehouse", c.Rules[0].Reconcile.Target.Server)
+		assert.Equal(t, []string{"id"}, c.Rules[0].Reconcile.KeyColumns)
+	}
+}
+
+func TestParseExpectation(t *testing.T) {
+	tests := []struct {
+		input     string
+		expect    Expectation
+		expectErr string
+	}{
+		{input: "null_ratio(email) < 0.01", expect: Expectation{Metric: MetricNullRatio, Column: "email", Op: "<", Value: 0.01}},
+		{input: " distinct_count( country ) >= 2", expect: Expectation{Metric: MetricDistinctCount, Column: "country", Op: ">=", Value: 2}},
+		{input: "row_count > 0", expect: Expectation{Metric: MetricRowCount, Op: ">", Value: 0}},
+		{input: "row_count() != 0", expect: Expectation{Metric: MetricRowCount, Op: "!=", Value: 0}},
+		{input: "null_ratio < 0.01", expectErr: "null_ratio needs a column"},
+		{input: "row_count(id) > 0", expectErr: "row_count takes no column"},
+		{input: "median(total) > 0", expectErr: `unknown metric "median"`},
+		{input: "max(total) < ten", expectErr: `"ten" is not a number`},
+		{input: "email is not null", expectErr: "invalid expectation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			e, err := ParseExpectation(tt.input)
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, e)
+		})
+	}
+
+	e, _ := ParseExpectation("max(total)<=100")
+	assert.Equal(t, "max(total) <= 100", e.String())
+	assert.True(t, e.Holds(100))
+	assert.False(t, e.Holds(100.5))
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CatalogColumns are the columns of a schema check's result: one row per
+// column or index of the checked tables
+var CatalogColumns = []string{"table_name", "object_type", "object_name", "definition"}
+
+// Catalog queries list a table's columns and indexes as object_type,
+// object_name and definition. %[1]s is the schema, empty for the
+// connection's default, and %[2]s the table; both are validated identifiers
+// inlined as string literals.
+const (
+	postgresCatalogQuery = `SELECT 'column' AS object_type, a.attname AS object_name,
+	format_type(a.atttypid, a.atttypmod) || CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE ' NULL' END AS definition
+FROM pg_attribute a
+WHERE a.attrelid = to_regclass(quote_ident(COALESCE(NULLIF('%[1]s', ''), current_schema())) || '.' || quote_ident('%[2]s'))
+	AND a.attnum > 0 AND NOT a.attisdropped
+UNION ALL
+SELECT 'index', c.relname, pg_get_indexdef(i.indexrelid)
+FROM pg_index i
+JOIN pg_class c ON c.oid = i.indexrelid
+WHERE i.indrelid = to_regclass(quote_ident(COALESCE(NULLIF('%[1]s', ''), current_schema())) || '.' || quote_ident('%[2]s'))`
+
+	sqlserverCatalogQuery = `SELECT 'column' AS object_type, c.name AS object_name,
+	TYPE_NAME(c.user_type_id)
+	+ CASE
+		WHEN TYPE_NAME(c.user_type_id) IN ('varchar', 'char', 'varbinary', 'binary')
+			THEN '(' + CASE WHEN c.max_length = -1 THEN 'max' ELSE CAST(c.max_length AS varchar(10)) END + ')'
+		WHEN TYPE_NAME(c.user_type_id) IN ('nvarchar', 'nchar')
+			THEN '(' + CASE WHEN c.max_length = -1 THEN 'max' ELSE CAST(c.max_length / 2 AS varchar(10)) END + ')'
+		WHEN TYPE_NAME(c.user_type_id) IN ('decimal', 'numeric')
+			THEN '(' + CAST(c.precision AS varchar(10)) + ',' + CAST(c.scale AS varchar(10)) + ')'
+		ELSE ''
+	END
+	+ CASE WHEN c.is_nullable = 1 THEN ' NULL' ELSE ' NOT NULL' END AS definition
+FROM sys.columns c
+WHERE c.object_id = OBJECT_ID(QUOTENAME(COALESCE(NULLIF('%[1]s', ''), SCHEMA_NAME())) + '.' + QUOTENAME('%[2]s'))
+UNION ALL
+SELECT 'index', i.name,
+	CASE WHEN i.is_unique = 1 THEN 'UNIQUE ' ELSE '' END + LOWER(i.type_desc) + ' ('
+	+ STRING_AGG(COL_NAME(ic.object_id, ic.column_id), ', ') WITHIN GROUP (ORDER BY ic.key_ordinal) + ')'
+FROM sys.indexes i
+JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+WHERE i.object_id = OBJECT_ID(QUOTENAME(COALESCE(NULLIF('%[1]s', ''), SCHEMA_NAME())) + '.' + QUOTENAME('%[2]s'))
+	AND i.name IS NOT NULL
+GROUP BY i.name, i.is_unique, i.type_desc`
+
+	mysqlCatalogQuery = `SELECT 'column' AS object_type, column_name AS object_name,
+	CONCAT(column_type, IF(is_nullable = 'YES', ' NULL', ' NOT NULL')) AS definition
+FROM information_schema.columns
+WHERE table_schema = COALESCE(NULLIF('%[1]s', ''), DATABASE()) AND table_name = '%[2]s'
+UNION ALL
+SELECT 'index', index_name,
+	CONCAT(IF(MAX(non_unique) = 0, 'UNIQUE ', ''), '(', GROUP_CONCAT(column_name ORDER BY seq_in_index SEPARATOR ', '), ')')
+FROM information_schema.statistics
+WHERE table_schema = COALESCE(NULLIF('%[1]s', ''), DATABASE()) AND table_name = '%[2]s'
+GROUP BY index_name`
+
+	sqliteCatalogQuery = `SELECT 'column' AS object_type, name AS object_name,
+	type || CASE WHEN "notnull" = 1 THEN ' NOT NULL' ELSE ' NULL' END AS definition
+FROM pragma_table_info('%[2]s', COALESCE(NULLIF('%[1]s', ''), 'main'))
+UNION ALL
+SELECT 'index', il.name,
+	CASE WHEN il."unique" = 1 THEN 'UNIQUE ' ELSE '' END || '(' || group_concat(ii.name, ', ') || ')'
+FROM pragma_index_list('%[2]s', COALESCE(NULLIF('%[1]s', ''), 'main')) il
+JOIN pragma_index_info(il.name, COALESCE(NULLIF('%[1]s', ''), 'main')) ii
+GROUP BY il.name, il."unique"`
+)
+
+// compileCatalog builds a query listing the columns and indexes of tables,
+// with each row labelled by the table as configured
+func compileCatalog(dialect *Dialect, tables []string) (string, error) {
+	if dialect.catalogQuery == "" {
+		return "", fmt.Errorf("schema checks aren't supported on %s", dialect.Name)
+	}
+	if len(tables) == 0 {
+		return "", fmt.Errorf("Tables is empty")
+	}
+	parts := make([]string, len(tables))
+	for i, table := range tables {
+		if _, ok := quoteQualified(dialect, table); !ok {
+			return "", fmt.Errorf("table %q is not a valid identifier", table)
+		}
+		schema, name := "", table
+		if dot := strings.LastIndex(table, "."); dot >= 0 {
+			schema, name = table[:dot], table[dot+1:]
+		}
+		parts[i] = fmt.Sprintf("SELECT '%s' AS table_name, object_type, object_name, definition FROM (%s) t%d",
+			table, fmt.Sprintf(dialect.catalogQuery, schema, name), i+1)
+	}
+	return strings.Join(parts, "\nUNION ALL\n"), nil
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nathanthorell/dataspy/config"
+)
+
+// Parameters bound by compiled checks. They are added to the rule's own
+// parameters, so a Where condition can still use those.
+const (
+	checkParamMin    = "check_min"
+	checkParamMax    = "check_max"
+	checkParamCutoff = "check_cutoff"
+)
+
+// CompileCheck turns a declarative rule into a query for the dialect. Like a
+// hand-written rule, the query returns one row per violation and uses :name
+// parameters, which RenderQuery binds from the returned params. now is when
+// the check runs, which freshness measures MaxAge back from. Schema and
+// profile checks are the exception: their queries return the tables' current
+// schema or column statistics, under CatalogColumns or ProfileColumns, for
+// the caller to evaluate.
+func CompileCheck(dialect *Dialect, rule config.Rule, now time.Time) (string, config.Params, error) {
+	if rule.Kind == config.CheckSchema {
+		query, err := compileCatalog(dialect, rule.Tables)
+		return query, rule.Params, err
+	}
+	table, ok := quoteQualified(dialect, rule.Table)
+	if !ok {
+		return "", nil, fmt.Errorf("Table %q is not a valid identifier", rule.Table)
+	}
+	cols, err := quoteColumns(dialect, rule.Columns)
+	if err != nil {
+		return "", nil, err
+	}
+	params := config.Params{}
+	filter := ""
+	if strings.TrimSpace(rule.Where) != "" {
+		filter = "(" + rule.Where + ")"
+	}
+
+	var query string
+	switch rule.Kind {
+	case config.CheckNotNull:
+		query = fmt.Sprintf("SELECT * FROM %s WHERE %s",
+			table, and(anyOf(cols, "%s IS NULL"), filter))
+
+	case config.CheckUnique:
+		list := strings.Join(cols, ", ")
+		query = fmt.Sprintf("SELECT %s, COUNT(*) AS duplicates FROM %s", list, table)
+		if filter != "" {
+			query += " WHERE " + filter
+		}
+		query += fmt.Sprintf(" GROUP BY %s HAVING COUNT(*) > 1", list)
+
+	case config.CheckReferences:
+		refTable, ok := quoteQualified(dialect, rule.RefTable)
+		if !ok {
+			return "", nil, fmt.Errorf("RefTable %q is not a valid identifier", rule.RefTable)
+		}
+		refCols, err := quoteColumns(dialect, rule.RefColumns)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(refCols) != len(cols) {
+			return "", nil, fmt.Errorf("RefColumns must list one column for each of Columns")
+		}
+		joins := make([]string, len(cols))
+		for i := range cols {
+			joins[i] = fmt.Sprintf("r.%s = c.%s", refCols[i], cols[i])
+		}
+		// Rows with a NULL key reference nothing, as with a foreign key
+		query = fmt.Sprintf("SELECT c.* FROM %s c WHERE %s",
+			table, and(
+				allOf(cols, "c.%s IS NOT NULL"),
+				fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s r WHERE %s)", refTable, strings.Join(joins, " AND ")),
+				filter,
+			))
+
+	case config.CheckFreshness:
+		if len(cols) != 1 {
+			return "", nil, fmt.Errorf("kind %q takes a single timestamp column", rule.Kind)
+		}
+		maxAge, err := rule.ParseMaxAge()
+		if err != nil {
+			return "", nil, err
+		}
+		params[checkParamCutoff] = now.Add(-maxAge).UTC().Truncate(time.Second)
+		inner := fmt.Sprintf("SELECT MAX(%s) AS latest FROM %s", cols[0], table)
+		if filter != "" {
+			inner += " WHERE " + filter
+		}
+		// An empty table has no latest row, which is as stale as it gets
+		query = fmt.Sprintf("SELECT latest FROM (%s) f WHERE latest IS NULL OR latest < :%s",
+			inner, checkParamCutoff)
+
+	case config.CheckRange:
+		var bounds []string
+		if rule.Min != nil {
+			params[checkParamMin] = rule.Min
+			bounds = append(bounds, "%[1]s < :"+checkParamMin)
+		}
+		if rule.Max != nil {
+			params[checkParamMax] = rule.Max
+			bounds = append(bounds, "%[1]s > :"+checkParamMax)
+		}
+		if len(bounds) == 0 {
+			return "", nil, fmt.Errorf("kind %q requires Min, Max or both", rule.Kind)
+		}
+		query = fmt.Sprintf("SELECT * FROM %s WHERE %s",
+			table, and(anyOf(cols, strings.Join(bounds, " OR ")), filter))
+
+	case config.CheckProfile:
+		query = compileProfile(dialect, rule, table, cols)
+
+	default:
+		return "", nil, fmt.Errorf("unknown check kind %q", rule.Kind)
+	}
+
+	for name, val := range rule.Params {
+		if _, taken := params[name]; !taken {
+			params[name] = val
+		}
+	}
+	return query, params, nil
+}
+
+func quoteColumns(dialect *Dialect, columns []string) ([]string, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("Columns is empty")
+	}
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		if !identifierPart.MatchString(col) {
+			return nil, fmt.Errorf("column %q is not a valid identifier", col)
+		}
+		quoted[i] = dialect.QuoteIdentifier(col)
+	}
+	return quoted, nil
+}
+
+// anyOf formats each column into a condition and ORs them together
+func anyOf(cols []string, format string) string {
+	conds := make([]string, len(cols))
+	for i, col := range cols {
+		conds[i] = fmt.Sprintf(format, col)
+	}
+	return "(" + strings.Join(conds, " OR ") + ")"
+}
+
+// allOf formats each column into a condition and ANDs them together
+func allOf(cols []string, format string) string {
+	conds := make([]string, len(cols))
+	for i, col := range cols {
+		conds[i] = fmt.Sprintf(format, col)
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// and combines conditions, skipping empty ones
+func and(conds ...string) string {
+	var parts []string
+	for _, c := range conds {
+		if c != "" {
+			parts = append(parts, c)
+		}
+	}
+	return strings.Join(parts, " AND ")
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileCheck(t *testing.T) {
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		dbType       string
+		rule         config.Rule
+		expectQuery  string
+		expectParams config.Params
+		expectErr    string
+	}{
+		{
+			name:        "not null",
+			dbType:      "postgres",
+			rule:        config.Rule{Kind: config.CheckNotNull, Table: "sales.orders", Columns: []string{"customer_id", "total"}},
+			expectQuery: `SELECT * FROM "sales"."orders" WHERE ("customer_id" IS NULL OR "total" IS NULL)`,
+		},
+		{
+			name:   "unique with filter",
+			dbType: "sqlserver",
+			rule: config.Rule{
+				Kind: config.CheckUnique, Table: "dbo.users", Columns: []string{"tenant_id", "email"},
+				Where: "deleted_at IS NULL",
+			},
+			expectQuery: "SELECT [tenant_id], [email], COUNT(*) AS duplicates FROM [dbo].[users] " +
+				"WHERE (deleted_at IS NULL) GROUP BY [tenant_id], [email] HAVING COUNT(*) > 1",
+		},
+		{
+			name:   "references",
+			dbType: "mysql",
+			rule: config.Rule{
+				Kind: config.CheckReferences, Table: "orders", Columns: []string{"customer_id"},
+				RefTable: "customers", RefColumns: []string{"id"},
+			},
+			expectQuery: "SELECT c.* FROM `orders` c WHERE c.`customer_id` IS NOT NULL AND " +
+				"NOT EXISTS (SELECT 1 FROM `customers` r WHERE r.`id` = c.`customer_id`)",
+		},
+		{
+			name:   "freshness",
+			dbType: "postgres",
+			rule: config.Rule{
+				Kind: config.CheckFreshness, Table: "events", Columns: []string{"created_at"}, MaxAge: "90m",
+				Where: "source = :source", Params: config.Params{"source": "web"},
+			},
+			expectQuery: `SELECT latest FROM (SELECT MAX("created_at") AS latest FROM "events" WHERE (source = :source)) f ` +
+				`WHERE latest IS NULL OR latest < :check_cutoff`,
+			expectParams: config.Params{"source": "web", "check_cutoff": now.Add(-90 * time.Minute)},
+		},
+		{
+			name:   "range",
+			dbType: "sqlite",
+			rule: config.Rule{
+				Kind: config.CheckRange, Table: "orders", Columns: []string{"total", "discount"},
+				Min: int64(0), Max: 10000.0,
+			},
+			expectQuery: `SELECT * FROM "orders" WHERE ("total" < :check_min OR "total" > :check_max OR ` +
+				`"discount" < :check_min OR "discount" > :check_max)`,
+			expectParams: config.Params{"check_min": int64(0), "check_max": 10000.0},
+		},
+		{
+			name:      "invalid table",
+			dbType:    "postgres",
+			rule:      config.Rule{Kind: config.CheckNotNull, Table: "orders; DROP TABLE x", Columns: []string{"id"}},
+			expectErr: "is not a valid identifier",
+		},
+		{
+			name:      "invalid column",
+			dbType:    "postgres",
+			rule:      config.Rule{Kind: config.CheckUnique, Table: "orders", Columns: []string{"lower(email)"}},
+			expectErr: `column "lower(email)" is not a valid identifier`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialect, err := LookupDialect(tt.dbType)
+			require.NoError(t, err)
+
+			query, params, err := CompileCheck(dialect, tt.rule, now)
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectQuery, query)
+			if tt.expectParams == nil {
+				tt.expectParams = config.Params{}
+			}
+			assert.Equal(t, tt.expectParams, params)
+		})
+	}
+}
+
+func TestCompileCheckSchema(t *testing.T) {
+	rule := config.Rule{Kind: config.CheckSchema, Tables: []string{"sales.orders", "customers"}}
+
+	for _, name := range DialectNames() {
+		t.Run(name, func(t *testing.T) {
+			dialect, err := LookupDialect(name)
+			require.NoError(t, err)
+
+			query, _, err := CompileCheck(dialect, rule, time.Now())
+			require.NoError(t, err)
+			assert.Contains(t, query, "SELECT 'sales.orders' AS table_name")
+			assert.Contains(t, query, "SELECT 'customers' AS table_name")
+			assert.Contains(t, query, "'sales'", "schema passed to the catalog query")
+
+			// Rendering must leave the catalog SQL intact
+			rendered, args, err := RenderQuery(dialect, query, nil)
+			require.NoError(t, err)
+			assert.Equal(t, query, rendered)
+			assert.Empty(t, args)
+			assert.Empty(t, LintQuery(name, query))
+		})
+	}
+
+	dialect, err := LookupDialect("postgres")
+	require.NoError(t, err)
+	_, _, err = CompileCheck(dialect, config.Rule{Kind: config.CheckSchema, Tables: []string{"orders'--"}}, time.Now())
+	assert.ErrorContains(t, err, "is not a valid identifier")
+}
+
+func TestCompileCheckProfile(t *testing.T) {
+	rule := config.Rule{Kind: config.CheckProfile, Table: "customers", Columns: []string{"email"}, TopN: 3, Where: "active = 1"}
+
+	dialect, err := LookupDialect("sqlserver")
+	require.NoError(t, err)
+	query, _, err := CompileCheck(dialect, rule, time.Now())
+	require.NoError(t, err)
+	assert.Contains(t, query, "WITH s AS (SELECT COUNT(*) AS n, COUNT([email]) AS nn0, "+
+		"COUNT(DISTINCT [email]) AS d0, MIN([email]) AS min0, MAX([email]) AS max0 FROM [customers] WHERE (active = 1))")
+	assert.Contains(t, query, "SELECT 'email' AS column_name, 'null_count' AS metric, CAST(n - nn0 AS NVARCHAR(4000)) AS value")
+	assert.Contains(t, query, "(SELECT TOP 3 [email] AS v, COUNT(*) AS c FROM [customers] WHERE [email] IS NOT NULL AND (active = 1) "+
+		"GROUP BY [email] ORDER BY COUNT(*) DESC, [email]) top0")
+
+	dialect, err = LookupDialect("mysql")
+	require.NoError(t, err)
+	rule.TopN = 0
+	query, _, err = CompileCheck(dialect, rule, time.Now())
+	require.NoError(t, err)
+	assert.Contains(t, query, "ORDER BY COUNT(*) DESC, `email` LIMIT 5) top0")
+	assert.Empty(t, LintQuery("mysql", query))
+}
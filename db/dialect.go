@@ -0,0 +1,253 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Dialect describes a database engine: how to reach it through database/sql
+// and the bits of SQL that differ between engines
+type Dialect struct {
+	// Name is the canonical type used in configuration and logs
+	Name string
+	// Driver is the database/sql driver name passed to sql.Open
+	Driver string
+	// Aliases are alternative type names accepted in configuration
+	Aliases []string
+	// DefaultPort is used by structured connections without a Port
+	DefaultPort int
+	// VersionQuery returns the server version as a single value
+	VersionQuery string
+
+	// readOnlyTxOptions starts read-only transactions through
+	// sql.TxOptions, which the driver turns into BEGIN READ ONLY or similar
+	readOnlyTxOptions bool
+	// readOnlyStatement runs at the start of a transaction to make it
+	// read-only when the driver can't
+	readOnlyStatement string
+	// readOnlyReset undoes readOnlyStatement when the setting outlives the
+	// transaction, so teardown can still write on the same connection
+	readOnlyReset string
+	// timeoutStatement formats a session statement limiting query run time,
+	// or is nil when the engine has none and only context cancellation works
+	timeoutStatement func(time.Duration) string
+	// quoteOpen and quoteClose delimit quoted identifiers
+	quoteOpen, quoteClose string
+	// placeholder formats the nth (1-based) bind parameter
+	placeholder func(n int) string
+	// catalogQuery lists a table's columns and indexes for schema checks;
+	// see compileCatalog
+	catalogQuery string
+	// textType is what CAST converts any value to for display
+	textType string
+	// selectTop limits a query with SELECT TOP n rather than LIMIT n
+	selectTop bool
+
+	// lintAnywhere and lintLeading are the engine's own write and admin
+	// keywords, on top of lintKeywords. Leading ones are only rejected at the
+	// start of a statement because they double as function or common column
+	// names elsewhere (e.g. MySQL's REPLACE(), a "comment" column).
+	lintAnywhere []string
+	lintLeading  []string
+	// hashComments starts a line comment with # as well as --
+	hashComments bool
+	// backslashEscapes lets a backslash escape a quote in quoted strings
+	backslashEscapes bool
+	// dollarQuotes allows $tag$ ... $tag$ strings
+	dollarQuotes bool
+	// hashTempTables names temporary tables with a leading #
+	hashTempTables bool
+	// otherQuotes maps the opening delimiter of each other quoted identifier
+	// syntax the engine accepts, besides double quotes, to its closing one
+	otherQuotes map[rune]rune
+}
+
+func questionPlaceholder(int) string { return "?" }
+
+var dialects = make(map[string]*Dialect)
+
+func init() {
+	RegisterDialect(&Dialect{
+		Name:              "postgres",
+		Driver:            "postgres",
+		Aliases:           []string{"postgresql", "pg"},
+		DefaultPort:       5432,
+		VersionQuery:      "SELECT version()",
+		readOnlyTxOptions: true,
+		timeoutStatement: func(d time.Duration) string {
+			return fmt.Sprintf("SET statement_timeout = %d", d.Milliseconds())
+		},
+		quoteOpen:    `"`,
+		quoteClose:   `"`,
+		placeholder:  func(n int) string { return fmt.Sprintf("$%d", n) },
+		catalogQuery: postgresCatalogQuery,
+		textType:     "TEXT",
+		lintLeading:  []string{"COPY", "VACUUM", "REINDEX", "CLUSTER", "REFRESH", "COMMENT", "LOCK", "CALL", "DO"},
+		dollarQuotes: true,
+	})
+	RegisterDialect(&Dialect{
+		Name:         "sqlserver",
+		Driver:       "sqlserver",
+		Aliases:      []string{"mssql"},
+		DefaultPort:  1433,
+		VersionQuery: "SELECT @@VERSION",
+		quoteOpen:    "[",
+		quoteClose:   "]",
+		placeholder:  func(n int) string { return fmt.Sprintf("@p%d", n) },
+		catalogQuery: sqlserverCatalogQuery,
+		textType:     "NVARCHAR(4000)",
+		selectTop:    true,
+		// Batches don't need semicolons between statements, so these apply
+		// anywhere
+		lintAnywhere:   []string{"EXEC", "EXECUTE", "BULK", "DBCC", "BACKUP", "RESTORE"},
+		otherQuotes:    map[rune]rune{'[': ']'},
+		hashTempTables: true,
+	})
+	RegisterDialect(&Dialect{
+		Name:              "mysql",
+		Driver:            "mysql",
+		Aliases:           []string{"mariadb"},
+		DefaultPort:       3306,
+		VersionQuery:      "SELECT VERSION()",
+		readOnlyTxOptions: true,
+		timeoutStatement: func(d time.Duration) string {
+			// Only applies to SELECT statements
+			return fmt.Sprintf("SET SESSION max_execution_time = %d", d.Milliseconds())
+		},
+		quoteOpen:        "`",
+		quoteClose:       "`",
+		placeholder:      questionPlaceholder,
+		catalogQuery:     mysqlCatalogQuery,
+		textType:         "CHAR",
+		lintLeading:      []string{"REPLACE", "RENAME", "LOAD", "HANDLER", "LOCK", "CALL"},
+		hashComments:     true,
+		backslashEscapes: true,
+		otherQuotes:      map[rune]rune{'`': '`'},
+	})
+	RegisterDialect(&Dialect{
+		Name:              "sqlite",
+		Driver:            "sqlite",
+		Aliases:           []string{"sqlite3"},
+		VersionQuery:      "SELECT sqlite_version()",
+		readOnlyStatement: "PRAGMA query_only = ON",
+		readOnlyReset:     "PRAGMA query_only = OFF",
+		quoteOpen:         `"`,
+		quoteClose:        `"`,
+		placeholder:       questionPlaceholder,
+		catalogQuery:      sqliteCatalogQuery,
+		textType:          "TEXT",
+		lintLeading:       []string{"REPLACE", "ATTACH", "DETACH", "VACUUM", "REINDEX", "PRAGMA"},
+		otherQuotes:       map[rune]rune{'`': '`', '[': ']'},
+	})
+}
+
+// RegisterDialect adds a dialect under its name and aliases, replacing any
+// dialect already registered under them
+func RegisterDialect(d *Dialect) {
+	dialects[strings.ToLower(d.Name)] = d
+	for _, alias := range d.Aliases {
+		dialects[strings.ToLower(alias)] = d
+	}
+}
+
+// LookupDialect returns the dialect for a type name or alias, ignoring case
+func LookupDialect(dbType string) (*Dialect, error) {
+	if d, ok := dialects[strings.ToLower(strings.TrimSpace(dbType))]; ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("unknown database type %q (supported: %s)", dbType, strings.Join(DialectNames(), ", "))
+}
+
+// CanonicalType returns the canonical name for a type name or alias
+func CanonicalType(dbType string) (string, error) {
+	d, err := LookupDialect(dbType)
+	if err != nil {
+		return "", err
+	}
+	return d.Name, nil
+}
+
+// DialectNames returns the canonical names of all registered dialects
+func DialectNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, d := range dialects {
+		if !seen[d.Name] {
+			seen[d.Name] = true
+			names = append(names, d.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// txBeginner is satisfied by both *sql.DB and *sql.Conn
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// BeginReadOnly starts a transaction that rejects writes. Postgres and MySQL
+// enforce this natively (BEGIN READ ONLY / START TRANSACTION READ ONLY) and
+// SQLite through PRAGMA query_only. SQL Server has no read-only transactions,
+// so there the caller's unconditional rollback is the only protection.
+func (d *Dialect) BeginReadOnly(db txBeginner) (*sql.Tx, error) {
+	if d.readOnlyTxOptions {
+		return db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	}
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.readOnlyStatement != "" {
+		if _, err := tx.Exec(d.readOnlyStatement); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+// EndReadOnly rolls back a transaction started by BeginReadOnly on conn and
+// restores the connection to read-write
+func (d *Dialect) EndReadOnly(conn *sql.Conn, tx *sql.Tx) error {
+	if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return err
+	}
+	if d.readOnlyReset == "" {
+		return nil
+	}
+	_, err := conn.ExecContext(context.Background(), d.readOnlyReset)
+	return err
+}
+
+// TimeoutStatement returns the session statement that limits how long a
+// query may run, and false when the engine has no such setting
+func (d *Dialect) TimeoutStatement(timeout time.Duration) (string, bool) {
+	if d.timeoutStatement == nil {
+		return "", false
+	}
+	return d.timeoutStatement(timeout), true
+}
+
+// isHashTemp reports whether name is a temporary table by its # prefix
+func (d *Dialect) isHashTemp(name string) bool {
+	return d.hashTempTables && strings.HasPrefix(name, "#")
+}
+
+// QuoteIdentifier quotes a single identifier, escaping any closing quote
+// characters it contains
+func (d *Dialect) QuoteIdentifier(name string) string {
+	escaped := strings.ReplaceAll(name, d.quoteClose, d.quoteClose+d.quoteClose)
+	return d.quoteOpen + escaped + d.quoteClose
+}
+
+// Placeholder returns the bind parameter marker for the nth (1-based)
+// argument of a query
+func (d *Dialect) Placeholder(n int) string {
+	return d.placeholder(n)
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupDialect(t *testing.T) {
+	tests := []struct {
+		dbType     string
+		expectName string
+		expectErr  bool
+	}{
+		{dbType: "postgres", expectName: "postgres"},
+		{dbType: "PostgreSQL", expectName: "postgres"},
+		{dbType: "mssql", expectName: "sqlserver"},
+		{dbType: "mariadb", expectName: "mysql"},
+		{dbType: "sqlite3", expectName: "sqlite"},
+		{dbType: "postgers", expectErr: true},
+		{dbType: "", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dbType, func(t *testing.T) {
+			d, err := LookupDialect(tt.dbType)
+			if tt.expectErr {
+				assert.ErrorContains(t, err, "supported: mysql, postgres, sqlite, sqlserver")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectName, d.Name)
+		})
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		dbType string
+		name   string
+		expect string
+	}{
+		{dbType: "postgres", name: "order", expect: `"order"`},
+		{dbType: "postgres", name: `a"b`, expect: `"a""b"`},
+		{dbType: "sqlserver", name: "a]b", expect: "[a]]b]"},
+		{dbType: "mysql", name: "a`b", expect: "`a``b`"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dbType+" "+tt.name, func(t *testing.T) {
+			d, err := LookupDialect(tt.dbType)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, d.QuoteIdentifier(tt.name))
+		})
+	}
+}
+
+func TestTimeoutStatement(t *testing.T) {
+	pg, _ := LookupDialect("postgres")
+	stmt, ok := pg.TimeoutStatement(1500 * time.Millisecond)
+	assert.True(t, ok)
+	assert.Equal(t, "SET statement_timeout = 1500", stmt)
+
+	mssql, _ := LookupDialect("sqlserver")
+	_, ok = mssql.TimeoutStatement(time.Second)
+	assert.False(t, ok)
+}
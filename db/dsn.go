@@ -0,0 +1,204 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/nathanthorell/dataspy/config"
+)
+
+// resolveConnString returns the DSN for a server, either resolved from its
+// connection string secret or built from its structured fields
+func resolveConnString(server config.DbServer) (string, error) {
+	if !server.IsStructured() {
+		return server.GetConnString()
+	}
+
+	password, err := server.GetPassword()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve password: %w", err)
+	}
+	registerSecret(password)
+	return BuildConnString(server, password)
+}
+
+// BuildConnString builds and validates the driver-specific DSN for a server
+// configured with structured connection fields
+func BuildConnString(server config.DbServer, password string) (string, error) {
+	dialect, err := LookupDialect(server.Type)
+	if err != nil {
+		return "", err
+	}
+	port := server.Port
+	if port == 0 {
+		port = dialect.DefaultPort
+	}
+
+	var dsn string
+	switch dialect.Name {
+	case "postgres":
+		dsn = postgresDSN(server, port, password)
+	case "sqlserver":
+		dsn = sqlserverDSN(server, port, password)
+	case "mysql":
+		dsn = mysqlDSN(server, port, password)
+	case "sqlite":
+		dsn = sqliteDSN(server)
+	default:
+		return "", fmt.Errorf("structured connections are not supported for type %q", server.Type)
+	}
+
+	if err := validateDSN(dialect.Name, dsn); err != nil {
+		return "", fmt.Errorf("invalid connection settings for server %s: %w", server.Name, err)
+	}
+	return dsn, nil
+}
+
+// sortedOptions returns driver options in a stable order
+func sortedOptions(options map[string]string) []string {
+	keys := make([]string, 0, len(options))
+	for k := range options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// postgresDSN builds a lib/pq key/value connection string
+func postgresDSN(server config.DbServer, port int, password string) string {
+	params := []string{
+		"host=" + pqQuote(server.Host),
+		"port=" + strconv.Itoa(port),
+	}
+	if server.Database != "" {
+		params = append(params, "dbname="+pqQuote(server.Database))
+	}
+	if server.User != "" {
+		params = append(params, "user="+pqQuote(server.User))
+	}
+	if password != "" {
+		params = append(params, "password="+pqQuote(password))
+	}
+	if server.TLS != "" {
+		// lib/pq's sslmode names match ours
+		params = append(params, "sslmode="+server.TLS)
+	}
+	for _, k := range sortedOptions(server.Options) {
+		params = append(params, k+"="+pqQuote(server.Options[k]))
+	}
+	return strings.Join(params, " ")
+}
+
+// pqQuote quotes a key/value parameter when it is empty or contains spaces,
+// quotes or backslashes
+func pqQuote(val string) string {
+	if val != "" && !strings.ContainsAny(val, ` '\`) {
+		return val
+	}
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(val) + "'"
+}
+
+// sqlserverDSN builds a go-mssqldb URL connection string
+func sqlserverDSN(server config.DbServer, port int, password string) string {
+	u := url.URL{
+		Scheme: "sqlserver",
+		Host:   net.JoinHostPort(server.Host, strconv.Itoa(port)),
+	}
+	if server.User != "" {
+		u.User = url.UserPassword(server.User, password)
+	}
+
+	q := url.Values{}
+	if server.Database != "" {
+		q.Set("database", server.Database)
+	}
+	switch server.TLS {
+	case config.TLSDisable:
+		q.Set("encrypt", "disable")
+	case config.TLSRequire:
+		q.Set("encrypt", "true")
+		q.Set("TrustServerCertificate", "true")
+	case config.TLSVerifyCA, config.TLSVerifyFull:
+		q.Set("encrypt", "true")
+		q.Set("TrustServerCertificate", "false")
+	}
+	for _, k := range sortedOptions(server.Options) {
+		q.Set(k, server.Options[k])
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// mysqlDSN builds a go-sql-driver/mysql connection string. The driver has no
+// CA-only verification, so verify-ca verifies the host name too.
+func mysqlDSN(server config.DbServer, port int, password string) string {
+	cfg := mysql.NewConfig()
+	cfg.User = server.User
+	cfg.Passwd = password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(server.Host, strconv.Itoa(port))
+	cfg.DBName = server.Database
+	switch server.TLS {
+	case config.TLSDisable:
+		cfg.TLSConfig = "false"
+	case config.TLSRequire:
+		cfg.TLSConfig = "skip-verify"
+	case config.TLSVerifyCA, config.TLSVerifyFull:
+		cfg.TLSConfig = "true"
+	}
+	dsn := cfg.FormatDSN()
+
+	if len(server.Options) > 0 {
+		q := url.Values{}
+		for _, k := range sortedOptions(server.Options) {
+			q.Set(k, server.Options[k])
+		}
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + q.Encode()
+	}
+	return dsn
+}
+
+// sqliteDSN builds a modernc.org/sqlite file URI. Read-only servers open the
+// file with mode=ro so SQLite itself refuses writes and won't create a
+// missing database.
+func sqliteDSN(server config.DbServer) string {
+	q := url.Values{}
+	if server.ReadOnly {
+		q.Set("mode", "ro")
+	}
+	for _, k := range sortedOptions(server.Options) {
+		q.Set(k, server.Options[k])
+	}
+	dsn := "file:" + server.Path
+	if len(q) > 0 {
+		dsn += "?" + q.Encode()
+	}
+	return dsn
+}
+
+// validateDSN parses a DSN with the driver's own parser, without connecting.
+// SQLite only parses its DSN when opening the file, so it isn't checked here.
+func validateDSN(dbType, dsn string) error {
+	var err error
+	switch dbType {
+	case "postgres":
+		_, err = pq.NewConnector(dsn)
+	case "sqlserver":
+		_, err = mssql.NewConnector(dsn)
+	case "mysql":
+		_, err = mysql.ParseDSN(dsn)
+	}
+	return err
+}
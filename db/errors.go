@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	mssql "github.com/microsoft/go-mssqldb"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// isAuthError reports whether a driver error means the server rejected the
+// credentials, as opposed to being unreachable or failing the query
+func isAuthError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// invalid_password, invalid_authorization_specification
+		return pqErr.Code == "28P01" || pqErr.Code == "28000"
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// ER_ACCESS_DENIED_ERROR, ER_DBACCESS_DENIED_ERROR
+		return mysqlErr.Number == 1045 || mysqlErr.Number == 1044
+	}
+
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		// Login failed for user
+		return mssqlErr.Number == 18456
+	}
+
+	return false
+}
+
+// ErrUnavailable marks errors from a server that couldn't be reached, as
+// opposed to one that rejected the credentials or failed the query
+var ErrUnavailable = errors.New("server unavailable")
+
+// unavailableError keeps the message of the underlying error while matching
+// ErrUnavailable
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string   { return e.err.Error() }
+func (e *unavailableError) Unwrap() []error { return []error{ErrUnavailable, e.err} }
+
+// pingError wraps a failed ping, marking it as ErrUnavailable when the
+// server couldn't be reached. Anything else, such as rejected credentials or
+// a misconfigured database name, means it answered.
+func pingError(err error) error {
+	err = fmt.Errorf("failed to ping database: %w", err)
+	if !isNetworkError(err) {
+		return err
+	}
+	return &unavailableError{err: err}
+}
+
+// isNetworkError reports whether err means the server couldn't be reached:
+// the connection was refused, dropped or timed out, or a SQLite file
+// couldn't be opened
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_CANTOPEN {
+		return true
+	}
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// IsRetryable reports whether a rule that failed with err is likely to pass
+// when run again: it lost a deadlock or serialization conflict, or its
+// connection dropped. Servers that can't be reached at all aren't retried;
+// they're left to the health checks.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, ErrUnavailable) {
+		return false
+	}
+	return isConflictError(err) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isConflictError reports whether a driver error means the statement lost
+// out to another transaction: a deadlock, serialization failure or lock
+func isConflictError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// serialization_failure, deadlock_detected
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// ER_LOCK_DEADLOCK, ER_LOCK_WAIT_TIMEOUT
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		// Chosen as deadlock victim, snapshot isolation update conflict
+		return mssqlErr.Number == 1205 || mssqlErr.Number == 3960
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// The primary code, without the extended detail in the upper bits
+		code := sqliteErr.Code() & 0xff
+		return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+	}
+
+	return false
+}
@@ -1,9 +1,13 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"math/rand/v2"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/nathanthorell/dataspy/config"
 )
@@ -11,10 +15,43 @@ import (
 // dbOpener is a function type that matches sql.Open's signature
 type dbOpener func(driverName, dataSource string) (*sql.DB, error)
 
+// queryer is satisfied by both *sql.Conn and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
 type ExecutionResult struct {
-	RowCount  int64
-	Results   string
-	LogEvents []LogEvent
+	ExecutionID string
+	RowCount    int64
+	Results     string
+	Columns     []string
+	Rows        [][]string
+	LogEvents   []LogEvent
+	// Phases are how long each phase of the rule took, in the order they ran
+	Phases []PhaseTiming
+	// Attempts are each run of a rule that was retried, the last being the
+	// one that produced the result
+	Attempts []Attempt
+}
+
+// Attempt is one run of a rule under a retry policy
+type Attempt struct {
+	Duration time.Duration
+	Err      error // nil when the attempt succeeded
+}
+
+// Rule phases, in the order they run
+const (
+	PhaseSetup    = "setup"
+	PhaseQuery    = "query"
+	PhaseTeardown = "teardown"
+)
+
+// PhaseTiming is how long one phase of a rule took, whether or not it
+// succeeded
+type PhaseTiming struct {
+	Phase    string
+	Duration time.Duration
 }
 
 type LogEvent struct {
@@ -24,24 +61,161 @@ type LogEvent struct {
 	Error   error // Only for error events
 }
 
+// addEvent records a log event tagged with the result's execution ID, with
+// credentials scrubbed from its error and fields
+func (r *ExecutionResult) addEvent(event LogEvent) {
+	if event.Fields == nil {
+		event.Fields = make(map[string]interface{})
+	}
+	for k, v := range event.Fields {
+		if str, ok := v.(string); ok {
+			event.Fields[k] = RedactString(str)
+		}
+	}
+	event.Fields["execution_id"] = r.ExecutionID
+	event.Error = RedactError(event.Error)
+	r.LogEvents = append(r.LogEvents, event)
+}
+
+// sleep waits between retries; tests replace it to run without delay
+var sleep = time.Sleep
+
 func executeRuleWithOpener(
+	executionID string,
 	server config.DbServer,
 	rule config.Rule,
 	opener dbOpener,
 ) (ExecutionResult, error) {
-	result := ExecutionResult{
-		LogEvents: make([]LogEvent, 0),
+	var policy config.RetryPolicy
+	if rule.Retry != nil {
+		policy = *rule.Retry
+	}
+
+	// Collects the events of every attempt, and why each was retried
+	history := ExecutionResult{ExecutionID: executionID}
+	var attempts []Attempt
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		result, err := runRule(executionID, server, rule, opener)
+		// Driver errors can echo the DSN, password included
+		err = RedactError(err)
+		history.LogEvents = append(history.LogEvents, result.LogEvents...)
+		attempts = append(attempts, Attempt{Duration: time.Since(start), Err: err})
+
+		if err == nil || attempt >= policy.MaxAttempts || !IsRetryable(err) {
+			result.LogEvents = history.LogEvents
+			if len(attempts) > 1 {
+				result.Attempts = attempts
+			}
+			return result, err
+		}
+
+		delay := retryDelay(policy, attempt, rand.Float64())
+		history.addEvent(LogEvent{
+			Level:   "warn",
+			Message: fmt.Sprintf("Transient failure, retrying in %s (attempt %d of %d)", delay, attempt+1, policy.MaxAttempts),
+			Fields:  map[string]interface{}{"rule": rule.Name, "server": server.Name},
+		})
+		sleep(delay)
+	}
+}
+
+// retryDelay is how long to wait after the given failed attempt: the
+// policy's backoff doubled for each earlier retry, capped at its maximum,
+// then shortened by up to the jitter fraction using r in [0, 1)
+func retryDelay(policy config.RetryPolicy, attempt int, r float64) time.Duration {
+	// Validated when the configuration was loaded
+	backoff, maxBackoff, _ := policy.ParseBackoff()
+	delay := backoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxBackoff)
+	return delay - time.Duration(float64(delay)*policy.Jitter*r)
+}
+
+func runRule(
+	executionID string,
+	server config.DbServer,
+	rule config.Rule,
+	opener dbOpener,
+) (result ExecutionResult, err error) {
+	result = ExecutionResult{
+		ExecutionID: executionID,
+		LogEvents:   make([]LogEvent, 0),
 	}
 
-	result.LogEvents = append(result.LogEvents, LogEvent{
+	result.addEvent(LogEvent{
 		Level:   "task",
 		Message: fmt.Sprintf("Executing on %s", server.Name),
 		Fields:  map[string]interface{}{"rule": rule.Name},
 	})
 
-	connStr, err := server.GetConnString()
+	mask, err := newMasker(rule.Masking)
+	if err != nil {
+		result.addEvent(LogEvent{
+			Level:   "error",
+			Message: "Invalid masking configuration",
+			Fields:  map[string]interface{}{"rule": rule.Name},
+			Error:   err,
+		})
+		return result, fmt.Errorf("invalid masking configuration for rule %s: %w", rule.Name, err)
+	}
+
+	dialect, err := LookupDialect(server.Type)
+	if err != nil {
+		result.addEvent(LogEvent{
+			Level:   "error",
+			Message: "Unsupported database type",
+			Fields:  map[string]interface{}{"server": server.Name},
+			Error:   err,
+		})
+		return result, fmt.Errorf("server %s: %w", server.Name, err)
+	}
+
+	query, params := rule.Query, rule.Params
+	if rule.IsDeclarative() {
+		query, params, err = CompileCheck(dialect, rule, time.Now())
+		if err != nil {
+			result.addEvent(LogEvent{
+				Level:   "error",
+				Message: "Failed to compile check",
+				Fields:  map[string]interface{}{"rule": rule.Name, "kind": rule.Kind},
+				Error:   err,
+			})
+			return result, fmt.Errorf("failed to compile %s check for rule %s: %w", rule.Kind, rule.Name, err)
+		}
+	}
+
+	query, args, err := RenderQuery(dialect, query, params)
+	if err != nil {
+		result.addEvent(LogEvent{
+			Level:   "error",
+			Message: "Failed to render query parameters",
+			Fields:  map[string]interface{}{"rule": rule.Name},
+			Error:   err,
+		})
+		return result, fmt.Errorf("failed to render query for rule %s: %w", rule.Name, err)
+	}
+
+	// Setup and teardown run outside the read-only transaction, so they may
+	// only change the session
+	if server.ReadOnly {
+		if issues := LintSession(dialect.Name, append(slices.Clone(rule.Setup), rule.Teardown...)); len(issues) > 0 {
+			err := fmt.Errorf("setup and teardown on read-only server %s may only change the session: %s", server.Name, issues[0])
+			result.addEvent(LogEvent{
+				Level:   "error",
+				Message: "Refused setup or teardown statement",
+				Fields:  map[string]interface{}{"rule": rule.Name, "server": server.Name},
+				Error:   err,
+			})
+			return result, err
+		}
+	}
+
+	connStr, err := resolveConnString(server)
 	if err != nil {
-		result.LogEvents = append(result.LogEvents, LogEvent{
+		result.addEvent(LogEvent{
 			Level:   "error",
 			Message: "Failed to get connection string",
 			Fields:  map[string]interface{}{"server": server.Name},
@@ -49,10 +223,11 @@ func executeRuleWithOpener(
 		})
 		return result, fmt.Errorf("failed to get connection string for server %s: %w", server.Name, err)
 	}
+	registerSecret(connStr)
 
-	db, err := opener(server.Type, connStr)
+	db, err := opener(dialect.Driver, connStr)
 	if err != nil {
-		result.LogEvents = append(result.LogEvents, LogEvent{
+		result.addEvent(LogEvent{
 			Level:   "error",
 			Message: "Failed to open DB connection",
 			Fields:  map[string]interface{}{"server": server.Name},
@@ -60,34 +235,130 @@ func executeRuleWithOpener(
 		})
 		return result, fmt.Errorf("failed to open db connection: %w", err)
 	}
-	defer db.Close()
+	// db may be swapped for a fresh connection below, so close whichever is current
+	defer func() { db.Close() }()
 
 	err = db.Ping()
+	if err != nil && isAuthError(err) {
+		// The credential may have been rotated since it was resolved and
+		// cached, so resolve it again and retry once if it changed
+		server.InvalidateConnString()
+		if fresh, ferr := resolveConnString(server); ferr == nil && fresh != connStr {
+			registerSecret(fresh)
+			result.addEvent(LogEvent{
+				Level:   "warn",
+				Message: "Authentication failed, retrying with re-resolved connection string",
+				Fields:  map[string]interface{}{"server": server.Name},
+			})
+			if freshDB, oerr := opener(dialect.Driver, fresh); oerr == nil {
+				db.Close()
+				db = freshDB
+				err = db.Ping()
+			}
+		}
+	}
 	if err != nil {
-		result.LogEvents = append(result.LogEvents, LogEvent{
+		result.addEvent(LogEvent{
 			Level:   "error",
 			Message: "Failed to ping database",
 			Fields:  map[string]interface{}{"server": server.Name},
 			Error:   err,
 		})
-		return result, fmt.Errorf("failed to ping database: %w", err)
+		return result, pingError(err)
 	}
 
-	result.LogEvents = append(result.LogEvents, LogEvent{
+	result.addEvent(LogEvent{
 		Level:   "db",
 		Message: "Connection established successfully",
 		Fields:  map[string]interface{}{"server": server.Name},
 	})
-	
-	result.LogEvents = append(result.LogEvents, LogEvent{
+
+	// Setup, the query and teardown share one connection, so temp tables
+	// and session settings carry over between them
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		result.addEvent(LogEvent{
+			Level:   "error",
+			Message: "Failed to acquire connection",
+			Fields:  map[string]interface{}{"server": server.Name},
+			Error:   err,
+		})
+		return result, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	if len(rule.Teardown) > 0 {
+		// Deferred before anything that can fail, so it always runs. Its
+		// error only surfaces when the rule otherwise succeeded.
+		defer func() {
+			if terr := runPhase(conn, dialect, PhaseTeardown, rule, rule.Teardown, params, &result); terr != nil && err == nil {
+				err = terr
+			}
+		}()
+	}
+	if len(rule.Setup) > 0 {
+		if err := runPhase(conn, dialect, PhaseSetup, rule, rule.Setup, params, &result); err != nil {
+			return result, err
+		}
+	}
+
+	queryStart := time.Now()
+	defer func() {
+		result.Phases = append(result.Phases, PhaseTiming{Phase: PhaseQuery, Duration: time.Since(queryStart)})
+	}()
+
+	// The context stops the wait on our side; the session setting also
+	// stops the query on the server where the engine has one
+	ctx := context.Background()
+	if rule.Timeout != "" {
+		timeout, err := rule.ParseTimeout()
+		if err != nil {
+			return result, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if stmt, ok := dialect.TimeoutStatement(timeout); ok {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				result.addEvent(LogEvent{
+					Level:   "error",
+					Message: "Failed to set query timeout",
+					Fields:  map[string]interface{}{"server": server.Name},
+					Error:   err,
+				})
+				return result, fmt.Errorf("failed to set query timeout: %w", err)
+			}
+		}
+	}
+
+	// Setup runs outside the read-only transaction, as session settings
+	// such as the isolation level must be in place before it begins
+	var q queryer = conn
+	if server.ReadOnly {
+		tx, err := dialect.BeginReadOnly(conn)
+		if err != nil {
+			result.addEvent(LogEvent{
+				Level:   "error",
+				Message: "Failed to start read-only transaction",
+				Fields:  map[string]interface{}{"server": server.Name},
+				Error:   err,
+			})
+			return result, fmt.Errorf("failed to start read-only transaction: %w", err)
+		}
+		// Nothing a rule does should ever be kept
+		defer dialect.EndReadOnly(conn, tx)
+		q = tx
+	}
+
+	result.addEvent(LogEvent{
 		Level:   "rule",
 		Message: "Executing query",
 		Fields:  map[string]interface{}{"rule": rule.Name},
 	})
 
-	rows, err := db.Query(rule.Query)
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
-		result.LogEvents = append(result.LogEvents, LogEvent{
+		result.addEvent(LogEvent{
 			Level:   "error",
 			Message: "Failed to execute query",
 			Fields:  map[string]interface{}{"rule": rule.Name},
@@ -99,7 +370,7 @@ func executeRuleWithOpener(
 
 	columns, err := rows.Columns()
 	if err != nil {
-		result.LogEvents = append(result.LogEvents, LogEvent{
+		result.addEvent(LogEvent{
 			Level:   "error",
 			Message: "Failed to get columns",
 			Fields:  map[string]interface{}{"rule": rule.Name},
@@ -117,7 +388,7 @@ func executeRuleWithOpener(
 		}
 
 		if err := rows.Scan(valuePtrs...); err != nil {
-			result.LogEvents = append(result.LogEvents, LogEvent{
+			result.addEvent(LogEvent{
 				Level:   "error",
 				Message: "Failed to scan row",
 				Fields:  map[string]interface{}{"rule": rule.Name},
@@ -143,6 +414,12 @@ func executeRuleWithOpener(
 		results = append(results, rowStrings)
 	}
 
+	// Sensitive values must never reach logs, storage or notifications
+	if rule.Kind == config.CheckProfile {
+		results = mask.applyProfile(columns, results)
+	}
+	columns, results = mask.apply(columns, results)
+
 	var resultString string
 	if len(results) > 0 {
 		resultString = fmt.Sprintf("Found %d rows:\n", len(results))
@@ -153,7 +430,7 @@ func executeRuleWithOpener(
 		resultString = "Query completed successfully (0 rows)"
 	}
 
-	result.LogEvents = append(result.LogEvents, LogEvent{
+	result.addEvent(LogEvent{
 		Level:   "success",
 		Message: "Query executed successfully",
 		Fields: map[string]interface{}{
@@ -165,10 +442,93 @@ func executeRuleWithOpener(
 
 	result.RowCount = int64(len(results))
 	result.Results = resultString
-	
+	result.Columns = columns
+	result.Rows = results
+
 	return result, nil
 }
 
-func ExecuteRule(server config.DbServer, rule config.Rule) (ExecutionResult, error) {
-	return executeRuleWithOpener(server, rule, sql.Open)
+// runPhase executes a rule's setup or teardown statements in order on conn,
+// stopping at the first that fails, and records how long they took
+func runPhase(
+	conn *sql.Conn,
+	dialect *Dialect,
+	phase string,
+	rule config.Rule,
+	statements []string,
+	params config.Params,
+	result *ExecutionResult,
+) error {
+	start := time.Now()
+	defer func() {
+		result.Phases = append(result.Phases, PhaseTiming{Phase: phase, Duration: time.Since(start)})
+	}()
+
+	result.addEvent(LogEvent{
+		Level:   "rule",
+		Message: fmt.Sprintf("Running %s", phase),
+		Fields:  map[string]interface{}{"rule": rule.Name, "statements": len(statements)},
+	})
+	for i, stmt := range statements {
+		query, args, err := RenderQuery(dialect, stmt, params)
+		if err == nil {
+			_, err = conn.ExecContext(context.Background(), query, args...)
+		}
+		if err != nil {
+			result.addEvent(LogEvent{
+				Level:   "error",
+				Message: fmt.Sprintf("Failed to run %s statement %d", phase, i+1),
+				Fields:  map[string]interface{}{"rule": rule.Name},
+				Error:   err,
+			})
+			return fmt.Errorf("%s statement %d failed: %w", phase, i+1, err)
+		}
+	}
+	return nil
+}
+
+// pingTimeout bounds how long PingServer waits for a server to answer
+const pingTimeout = 10 * time.Second
+
+// PingServer checks that a server can be reached and returns its version.
+// Errors wrap ErrUnavailable unless the server answered, e.g. to reject the
+// credentials.
+func PingServer(server config.DbServer) (string, error) {
+	version, err := pingWithOpener(server, sql.Open)
+	return version, RedactError(err)
+}
+
+func pingWithOpener(server config.DbServer, opener dbOpener) (string, error) {
+	dialect, err := LookupDialect(server.Type)
+	if err != nil {
+		return "", fmt.Errorf("server %s: %w", server.Name, err)
+	}
+	connStr, err := resolveConnString(server)
+	if err != nil {
+		return "", fmt.Errorf("failed to get connection string for server %s: %w", server.Name, err)
+	}
+	registerSecret(connStr)
+
+	db, err := opener(dialect.Driver, connStr)
+	if err != nil {
+		return "", fmt.Errorf("failed to open db connection: %w", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		return "", pingError(err)
+	}
+	var version string
+	if err := db.QueryRowContext(ctx, dialect.VersionQuery).Scan(&version); err != nil {
+		return "", fmt.Errorf("failed to query server version: %w", err)
+	}
+	return version, nil
+}
+
+// ExecuteRule runs a rule against a server. The execution ID is attached to
+// every log event so callers can correlate them with the stored record.
+func ExecuteRule(executionID string, server config.DbServer, rule config.Rule) (ExecutionResult, error) {
+	return executeRuleWithOpener(executionID, server, rule, sql.Open)
 }
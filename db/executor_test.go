@@ -1,11 +1,22 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	mssql "github.com/microsoft/go-mssqldb"
 	"github.com/nathanthorell/dataspy/config"
 	"github.com/stretchr/testify/assert"
 )
@@ -135,7 +146,11 @@ func TestExecuteRule(t *testing.T) {
 				tt.setupMock(mock)
 			}
 
-			result, err := executeRuleWithOpener(tt.server, tt.rule, dbOpen)
+			result, err := executeRuleWithOpener("test-execution-id", tt.server, tt.rule, dbOpen)
+
+			for _, event := range result.LogEvents {
+				assert.Equal(t, "test-execution-id", event.Fields["execution_id"])
+			}
 
 			// Verify expectations
 			if tt.expectErr {
@@ -155,3 +170,328 @@ func TestExecuteRule(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteRuleReadOnly(t *testing.T) {
+	mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer mockDB.Close()
+
+	dbOpen := func(driverName, dataSource string) (*sql.DB, error) {
+		return mockDB, nil
+	}
+
+	os.Setenv("PG_DBCONN", "mock_conn_string")
+	defer os.Unsetenv("PG_DBCONN")
+
+	server := config.DbServer{
+		Name:          "test-server",
+		Type:          "postgres",
+		ConnStringVar: "PG_DBCONN",
+		ReadOnly:      true,
+	}
+	rule := config.Rule{
+		Name:   "read-only-rule",
+		Query:  "SELECT 1",
+		DbType: "postgres",
+	}
+
+	// The query runs inside a transaction that is always rolled back
+	mock.ExpectPing()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	mock.ExpectRollback()
+
+	result, err := executeRuleWithOpener("test-execution-id", server, rule, dbOpen)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.RowCount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExecuteRuleTimeout(t *testing.T) {
+	mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer mockDB.Close()
+
+	dbOpen := func(driverName, dataSource string) (*sql.DB, error) {
+		return mockDB, nil
+	}
+
+	os.Setenv("PG_DBCONN", "mock_conn_string")
+	defer os.Unsetenv("PG_DBCONN")
+
+	server := config.DbServer{Name: "test-server", Type: "postgres", ConnStringVar: "PG_DBCONN"}
+	rule := config.Rule{Name: "timeout-rule", Query: "SELECT 1", DbType: "postgres", Timeout: "30s"}
+
+	mock.ExpectPing()
+	mock.ExpectExec("SET statement_timeout = 30000").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+
+	result, err := executeRuleWithOpener("test-execution-id", server, rule, dbOpen)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.RowCount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExecuteRuleReresolvesOnAuthFailure(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "pg")
+	assert.NoError(t, os.WriteFile(secretPath, []byte("password=old"), 0600))
+
+	server := config.DbServer{
+		Name:       "test-server",
+		Type:       "postgres",
+		ConnString: "file:" + secretPath,
+	}
+	rule := config.Rule{Name: "auth-rule", Query: "SELECT 1", DbType: "postgres"}
+	defer server.InvalidateConnString()
+
+	staleDB, staleMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+	freshDB, freshMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+
+	staleMock.ExpectPing().WillReturnError(&pq.Error{Code: "28P01", Message: "password authentication failed"})
+	freshMock.ExpectPing()
+	freshMock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+
+	var opened []string
+	dbOpen := func(driverName, dataSource string) (*sql.DB, error) {
+		opened = append(opened, dataSource)
+		if dataSource == "password=old" {
+			// Rotate the secret once the stale credential is in use
+			assert.NoError(t, os.WriteFile(secretPath, []byte("password=new"), 0600))
+			return staleDB, nil
+		}
+		return freshDB, nil
+	}
+
+	result, err := executeRuleWithOpener("test-execution-id", server, rule, dbOpen)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.RowCount)
+	assert.Equal(t, []string{"password=old", "password=new"}, opened)
+	assert.NoError(t, staleMock.ExpectationsWereMet())
+	assert.NoError(t, freshMock.ExpectationsWereMet())
+}
+
+func TestExecuteRuleRedactsConnectionErrors(t *testing.T) {
+	os.Setenv("PG_DBCONN", "host=db user=app password=hunter2")
+	defer os.Unsetenv("PG_DBCONN")
+
+	server := config.DbServer{Name: "test-server", Type: "postgres", ConnStringVar: "PG_DBCONN"}
+	rule := config.Rule{Name: "redact-rule", Query: "SELECT 1", DbType: "postgres"}
+
+	dbOpen := func(driverName, dataSource string) (*sql.DB, error) {
+		return nil, fmt.Errorf("bad connection string %q", dataSource)
+	}
+
+	result, err := executeRuleWithOpener("test-execution-id", server, rule, dbOpen)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "hunter2")
+	for _, event := range result.LogEvents {
+		if event.Error != nil {
+			assert.NotContains(t, event.Error.Error(), "hunter2")
+		}
+	}
+}
+
+func TestPingUnavailable(t *testing.T) {
+	os.Setenv("PG_DBCONN", "mock_conn_string")
+	defer os.Unsetenv("PG_DBCONN")
+	server := config.DbServer{Name: "test-server", Type: "postgres", ConnStringVar: "PG_DBCONN"}
+	rule := config.Rule{Name: "ping-rule", Query: "SELECT 1", DbType: "postgres"}
+
+	tests := []struct {
+		name              string
+		pingErr           error
+		expectUnavailable bool
+	}{
+		{name: "reachable"},
+		{
+			name: "unreachable",
+			pingErr: &net.OpError{
+				Op:   "dial",
+				Net:  "tcp",
+				Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5432},
+				Err:  &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED},
+			},
+			expectUnavailable: true,
+		},
+		{name: "timed out", pingErr: context.DeadlineExceeded, expectUnavailable: true},
+		{name: "rejected credentials", pingErr: &pq.Error{Code: "28P01", Message: "password authentication failed"}},
+		{name: "unknown database", pingErr: &pq.Error{Code: "3D000", Message: `database "nope" does not exist`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock := openTestDB(t)
+			defer mockDB.Close()
+			dbOpen := func(driverName, dataSource string) (*sql.DB, error) {
+				return mockDB, nil
+			}
+			mock.ExpectPing().WillReturnError(tt.pingErr)
+			if tt.pingErr == nil {
+				mock.ExpectQuery("SELECT version()").
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("PostgreSQL 16.2"))
+			}
+
+			version, err := pingWithOpener(server, dbOpen)
+			if tt.pingErr == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, "PostgreSQL 16.2", version)
+				return
+			}
+			assert.ErrorContains(t, err, "failed to ping database")
+			assert.Equal(t, tt.expectUnavailable, errors.Is(err, ErrUnavailable))
+
+			// Rules fail the same way
+			mockDB, mock = openTestDB(t)
+			defer mockDB.Close()
+			mock.ExpectPing().WillReturnError(tt.pingErr)
+			_, err = executeRuleWithOpener("test-execution-id", server, rule, dbOpen)
+			assert.Equal(t, tt.expectUnavailable, errors.Is(err, ErrUnavailable))
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"postgres serialization failure", &pq.Error{Code: "40001"}, true},
+		{"postgres deadlock", fmt.Errorf("failed to execute query: %w", &pq.Error{Code: "40P01"}), true},
+		{"postgres unique violation", &pq.Error{Code: "23505"}, false},
+		{"mysql deadlock", &mysql.MySQLError{Number: 1213}, true},
+		{"mysql syntax error", &mysql.MySQLError{Number: 1064}, false},
+		{"sql server deadlock victim", mssql.Error{Number: 1205}, true},
+		{"sql server login failed", mssql.Error{Number: 18456}, false},
+		{"bad connection", fmt.Errorf("failed to execute query: %w", driver.ErrBadConn), true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"unreachable server", pingError(syscall.ECONNRESET), false},
+		{"lock conflict while connecting", pingError(&pq.Error{Code: "40P01"}), true},
+		{"other", assert.AnError, false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetryable(tt.err))
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := config.RetryPolicy{MaxAttempts: 10, Backoff: "1s", MaxBackoff: "5s", Jitter: 0.5}
+	tests := []struct {
+		attempt int
+		r       float64
+		want    time.Duration
+	}{
+		{1, 0, time.Second},
+		{2, 0, 2 * time.Second},
+		{3, 0, 4 * time.Second},
+		{4, 0, 5 * time.Second},
+		{9, 0, 5 * time.Second},
+		{2, 0.5, 1500 * time.Millisecond},
+		{4, 0.99, 2525 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, retryDelay(policy, tt.attempt, tt.r), "attempt %d, r %v", tt.attempt, tt.r)
+	}
+}
+
+func TestExecuteRuleRetries(t *testing.T) {
+	os.Setenv("PG_DBCONN", "mock_conn_string")
+	defer os.Unsetenv("PG_DBCONN")
+	server := config.DbServer{Name: "test-server", Type: "postgres", ConnStringVar: "PG_DBCONN"}
+
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	defer func() { sleep = time.Sleep }()
+
+	deadlock := &pq.Error{Code: "40P01", Message: "deadlock detected"}
+	tests := []struct {
+		name        string
+		retry       *config.RetryPolicy
+		queryErrs   []error // per attempt; nil succeeds
+		expectErr   bool
+		expectTries int
+	}{
+		{
+			name:        "no policy",
+			queryErrs:   []error{deadlock},
+			expectErr:   true,
+			expectTries: 1,
+		},
+		{
+			name:        "succeeds on retry",
+			retry:       &config.RetryPolicy{MaxAttempts: 3, Backoff: "100ms"},
+			queryErrs:   []error{deadlock, deadlock, nil},
+			expectTries: 3,
+		},
+		{
+			name:        "gives up after max attempts",
+			retry:       &config.RetryPolicy{MaxAttempts: 2, Backoff: "100ms"},
+			queryErrs:   []error{deadlock, deadlock},
+			expectErr:   true,
+			expectTries: 2,
+		},
+		{
+			name:        "permanent errors aren't retried",
+			retry:       &config.RetryPolicy{MaxAttempts: 3},
+			queryErrs:   []error{&pq.Error{Code: "42P01", Message: "relation does not exist"}},
+			expectErr:   true,
+			expectTries: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delays = nil
+			var mocks []sqlmock.Sqlmock
+			tries := 0
+			dbOpen := func(driverName, dataSource string) (*sql.DB, error) {
+				mockDB, mock := openTestDB(t)
+				mock.ExpectPing()
+				if err := tt.queryErrs[tries]; err != nil {
+					mock.ExpectQuery("SELECT 1").WillReturnError(err)
+				} else {
+					mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+				}
+				mocks = append(mocks, mock)
+				tries++
+				return mockDB, nil
+			}
+
+			rule := config.Rule{Name: "retry-rule", Query: "SELECT 1", DbType: "postgres", Retry: tt.retry}
+			result, err := executeRuleWithOpener("test-execution-id", server, rule, dbOpen)
+
+			assert.Equal(t, tt.expectTries, tries)
+			assert.Len(t, delays, tt.expectTries-1)
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, int64(1), result.RowCount)
+			}
+			if tt.expectTries > 1 {
+				if assert.Len(t, result.Attempts, tt.expectTries) {
+					assert.ErrorIs(t, result.Attempts[0].Err, deadlock)
+					assert.Equal(t, tt.expectErr, result.Attempts[tt.expectTries-1].Err != nil)
+				}
+				assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}[:tt.expectTries-1], delays)
+			} else {
+				assert.Nil(t, result.Attempts)
+			}
+			for _, event := range result.LogEvents {
+				assert.Equal(t, "test-execution-id", event.Fields["execution_id"])
+			}
+			for _, mock := range mocks {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// LintIssue is a write or DDL keyword found in a rule's SQL
+type LintIssue struct {
+	Keyword string
+	Line    int
+	// Statement is the 1-based setup or teardown statement the keyword is
+	// in, for issues found by LintSession
+	Statement int
+}
+
+func (i LintIssue) String() string {
+	if i.Statement > 0 {
+		return fmt.Sprintf("statement %d, line %d: %s is not allowed in a read-only rule", i.Statement, i.Line, i.Keyword)
+	}
+	return fmt.Sprintf("line %d: %s is not allowed in a read-only rule", i.Line, i.Keyword)
+}
+
+// Keywords rejected anywhere in a statement, for every dialect. INTO catches
+// SELECT ... INTO, which creates a table or writes a file. Dialects add their
+// own through lintAnywhere and lintLeading.
+var lintKeywords = []string{
+	"INSERT", "UPDATE", "DELETE", "MERGE", "TRUNCATE", "INTO",
+	"CREATE", "ALTER", "DROP", "GRANT", "REVOKE",
+}
+
+// LintQuery scans a rule's SQL for DML and DDL keywords that have no place in
+// a read-only check. Comments, string literals and quoted identifiers are
+// skipped using the quoting rules of the given dialect; an unknown type gets
+// only the standard SQL rules.
+func LintQuery(dbType, query string) []LintIssue {
+	d := lintDialect(dbType)
+	var issues []LintIssue
+	for _, stmt := range splitStatements(tokenizeSQL(d, query)) {
+		issues = append(issues, lintTokens(d, stmt, true, nil)...)
+	}
+	return issues
+}
+
+// LintSession scans a rule's setup and teardown statements, in the order
+// they run. On a ReadOnly server they run outside the read-only transaction,
+// so they may only change the session: SET statements, creating temporary
+// tables, and writing to and dropping the ones the rule created. Anything
+// else is held to the same rules as a query.
+func LintSession(dbType string, statements []string) []LintIssue {
+	d := lintDialect(dbType)
+	temps := make(map[string]bool)
+	var issues []LintIssue
+	for n, stmt := range statements {
+		for _, tokens := range splitStatements(tokenizeSQL(d, stmt)) {
+			for _, issue := range lintSessionStatement(d, tokens, temps) {
+				issue.Statement = n + 1
+				issues = append(issues, issue)
+			}
+		}
+	}
+	return issues
+}
+
+// lintSessionStatement checks one statement of a setup or teardown phase,
+// adding any temporary tables it creates to temps. Writes to those tables
+// are allowed as long as the rest of the statement only reads.
+func lintSessionStatement(d *Dialect, tokens []sqlToken, temps map[string]bool) []LintIssue {
+	words := make([]string, len(tokens))
+	for i, tok := range tokens {
+		words[i] = strings.ToUpper(tok.text)
+	}
+	isTemp := func(i int) bool {
+		return i < len(tokens) && (temps[strings.ToLower(tokens[i].text)] || d.isHashTemp(tokens[i].text))
+	}
+	startsWith := func(prefix ...string) bool {
+		return len(words) >= len(prefix) && slices.Equal(words[:len(prefix)], prefix)
+	}
+	// created adds the table named at i, after any IF NOT EXISTS, and
+	// checks the rest of the statement
+	created := func(i int) []LintIssue {
+		if len(words) > i+2 && slices.Equal(words[i:i+3], []string{"IF", "NOT", "EXISTS"}) {
+			i += 3
+		}
+		if i >= len(tokens) {
+			return nil
+		}
+		temps[strings.ToLower(tokens[i].text)] = true
+		return lintTokens(d, tokens[i+1:], false, temps)
+	}
+
+	switch {
+	case startsWith("SET"):
+		// Settings outlive the session on some engines
+		if len(words) > 1 && (words[1] == "GLOBAL" || words[1] == "PERSIST" || words[1] == "PERSIST_ONLY") {
+			return []LintIssue{{Keyword: "SET " + words[1], Line: tokens[1].line}}
+		}
+		return lintTokens(d, tokens[1:], false, temps)
+	case startsWith("CREATE", "TEMP", "TABLE") || startsWith("CREATE", "TEMPORARY", "TABLE"):
+		return created(3)
+	case startsWith("CREATE", "TABLE") && len(tokens) > 2 && d.isHashTemp(tokens[2].text):
+		return created(2)
+	case (startsWith("INSERT", "INTO") || startsWith("DELETE", "FROM")) && isTemp(2):
+		return lintTokens(d, tokens[3:], false, temps)
+	case (startsWith("INSERT") || startsWith("UPDATE") || startsWith("DELETE")) && isTemp(1):
+		// SQL Server allows INSERT and DELETE without INTO and FROM
+		return lintTokens(d, tokens[2:], false, temps)
+	case startsWith("DROP", "TEMPORARY", "TABLE"):
+		return nil
+	case startsWith("DROP", "TABLE"):
+		names := 2
+		if startsWith("DROP", "TABLE", "IF", "EXISTS") {
+			names = 4
+		}
+		// Quoted names aren't tokens, so a drop with none is rejected
+		allTemp := names < len(tokens)
+		for i := names; i < len(tokens); i++ {
+			allTemp = allTemp && isTemp(i)
+		}
+		if allTemp {
+			return nil
+		}
+	}
+	return lintTokens(d, tokens, true, temps)
+}
+
+// lintDialect returns the dialect whose quoting and keywords lint uses, or
+// just the standard SQL rules for an unknown type
+func lintDialect(dbType string) *Dialect {
+	d, err := LookupDialect(dbType)
+	if err != nil {
+		return &Dialect{}
+	}
+	return d
+}
+
+// lintTokens checks the words of one statement against the dialect's
+// keywords; atStart is whether the first of them starts the statement. With
+// temps, SELECT ... INTO a temporary table is allowed, and adds it.
+func lintTokens(d *Dialect, tokens []sqlToken, atStart bool, temps map[string]bool) []LintIssue {
+	anywhere := make(map[string]bool)
+	for _, kw := range append(lintKeywords, d.lintAnywhere...) {
+		anywhere[kw] = true
+	}
+	leading := make(map[string]bool)
+	for _, kw := range d.lintLeading {
+		leading[kw] = true
+	}
+
+	var issues []LintIssue
+	for i, tok := range tokens {
+		word := strings.ToUpper(tok.text)
+		if temps != nil && word == "INTO" && i+1 < len(tokens) && d.isHashTemp(tokens[i+1].text) {
+			temps[strings.ToLower(tokens[i+1].text)] = true
+			continue
+		}
+		if anywhere[word] || (i == 0 && atStart && leading[word]) {
+			issues = append(issues, LintIssue{Keyword: word, Line: tok.line})
+		}
+	}
+	return issues
+}
+
+// splitStatements splits tokens into statements at each semicolon
+func splitStatements(tokens []sqlToken) [][]sqlToken {
+	var stmts [][]sqlToken
+	var cur []sqlToken
+	for _, tok := range tokens {
+		if tok.text == ";" {
+			stmts = append(stmts, cur)
+			cur = nil
+			continue
+		}
+		cur = append(cur, tok)
+	}
+	return append(stmts, cur)
+}
+
+type sqlToken struct {
+	text string
+	line int
+}
+
+// tokenizeSQL returns the bare words and statement separators in a query,
+// dropping comments, literals and quoted identifiers
+func tokenizeSQL(d *Dialect, query string) []sqlToken {
+	var tokens []sqlToken
+	line := 1
+	runes := []rune(query)
+	n := len(runes)
+
+	for i := 0; i < n; {
+		if end := skipQuoted(d, runes, i); end > i {
+			for _, r := range runes[i:end] {
+				if r == '\n' {
+					line++
+				}
+			}
+			i = end
+			continue
+		}
+
+		c := runes[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ';':
+			tokens = append(tokens, sqlToken{text: ";", line: line})
+			i++
+		case unicode.IsLetter(c) || c == '_' || (c == '#' && d.hashTempTables):
+			start := i
+			i++
+			for i < n && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{text: string(runes[start:i]), line: line})
+		default:
+			i++
+		}
+	}
+	return tokens
+}
+
+// skipQuoted returns the index just past the comment, string literal or
+// quoted identifier starting at i, using the quoting rules of the dialect,
+// or i when none starts there
+func skipQuoted(d *Dialect, runes []rune, i int) int {
+	n := len(runes)
+	c := runes[i]
+	if closing, ok := d.otherQuotes[c]; ok {
+		return scanPast(runes, i+1, string(closing), false)
+	}
+	switch {
+	case c == '-' && i+1 < n && runes[i+1] == '-',
+		c == '#' && d.hashComments:
+		for i < n && runes[i] != '\n' {
+			i++
+		}
+		return i
+	case c == '/' && i+1 < n && runes[i+1] == '*':
+		return scanPast(runes, i+2, "*/", false)
+	case c == '\'':
+		return scanPast(runes, i+1, "'", d.backslashEscapes)
+	case c == '"':
+		return scanPast(runes, i+1, `"`, d.backslashEscapes)
+	case c == '$' && d.dollarQuotes:
+		if tag, ok := dollarTag(runes, i); ok {
+			return scanPast(runes, i+len(tag), string(tag), false)
+		}
+	}
+	return i
+}
+
+// scanPast returns the index just past the closing delimiter, searching from i
+func scanPast(runes []rune, i int, closing string, backslashEscapes bool) int {
+	n := len(runes)
+	cl := []rune(closing)
+	for i < n {
+		if backslashEscapes && runes[i] == '\\' {
+			i += 2
+			continue
+		}
+		if hasPrefixAt(runes, i, cl) {
+			// A doubled quote character is an escaped quote
+			if len(cl) == 1 && i+1 < n && runes[i+1] == cl[0] {
+				i += 2
+				continue
+			}
+			return i + len(cl)
+		}
+		i++
+	}
+	return n
+}
+
+// dollarTag returns the opening tag of a Postgres dollar-quoted string ($$ or
+// $name$) starting at i
+func dollarTag(runes []rune, i int) ([]rune, bool) {
+	j := i + 1
+	for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+		j++
+	}
+	if j < len(runes) && runes[j] == '$' {
+		// $1 is a positional parameter, not a tag
+		if j > i+1 && unicode.IsDigit(runes[i+1]) {
+			return nil, false
+		}
+		return runes[i : j+1], true
+	}
+	return nil, false
+}
+
+func hasPrefixAt(runes []rune, i int, prefix []rune) bool {
+	if i+len(prefix) > len(runes) {
+		return false
+	}
+	for k, r := range prefix {
+		if runes[i+k] != r {
+			return false
+		}
+	}
+	return true
+}
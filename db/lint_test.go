@@ -0,0 +1,197 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		dbType     string
+		query      string
+		expectKeys []string
+	}{
+		{
+			name:   "plain select",
+			dbType: "postgres",
+			query:  "SELECT id, updated_at FROM orders WHERE total < 0",
+		},
+		{
+			name:       "update statement",
+			dbType:     "postgres",
+			query:      "UPDATE orders SET total = 0",
+			expectKeys: []string{"UPDATE"},
+		},
+		{
+			name:       "data-modifying CTE",
+			dbType:     "postgres",
+			query:      "WITH d AS (DELETE FROM orders RETURNING id) SELECT * FROM d",
+			expectKeys: []string{"DELETE"},
+		},
+		{
+			name:   "keywords inside comments and literals",
+			dbType: "postgres",
+			query: `-- DROP TABLE orders
+SELECT 'delete me' AS "insert", $tag$ TRUNCATE $tag$ /* ALTER */ FROM orders`,
+		},
+		{
+			name:       "select into",
+			dbType:     "sqlserver",
+			query:      "SELECT * INTO orders_copy FROM orders",
+			expectKeys: []string{"INTO"},
+		},
+		{
+			name:       "sqlserver exec without semicolon",
+			dbType:     "sqlserver",
+			query:      "SELECT 1 EXEC sp_cleanup",
+			expectKeys: []string{"EXEC"},
+		},
+		{
+			name:   "sqlserver bracketed identifier",
+			dbType: "sqlserver",
+			query:  "SELECT [delete]]flag] FROM [orders]",
+		},
+		{
+			name:   "mysql replace function",
+			dbType: "mysql",
+			query:  "SELECT REPLACE(email, '@', ' at ') FROM `update`",
+		},
+		{
+			name:       "mysql replace statement",
+			dbType:     "mysql",
+			query:      "SELECT 1; REPLACE INTO t VALUES (1)",
+			expectKeys: []string{"REPLACE", "INTO"},
+		},
+		{
+			name:   "mysql hash comment and escaped quote",
+			dbType: "mysql",
+			query:  "SELECT 'it\\'s DROP' # DELETE\nFROM t",
+		},
+		{
+			name:       "sqlite attach and pragma",
+			dbType:     "sqlite",
+			query:      "SELECT [update], `drop` FROM t; ATTACH 'x.db' AS x; PRAGMA journal_mode",
+			expectKeys: []string{"ATTACH", "PRAGMA"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := LintQuery(tt.dbType, tt.query)
+
+			var keys []string
+			for _, issue := range issues {
+				keys = append(keys, issue.Keyword)
+			}
+			assert.Equal(t, tt.expectKeys, keys)
+		})
+	}
+}
+
+func TestLintQueryLineNumbers(t *testing.T) {
+	issues := LintQuery("postgres", "SELECT 1;\n\n/* note\n */ DROP TABLE t")
+	assert.Len(t, issues, 1)
+	assert.Equal(t, 4, issues[0].Line)
+	assert.Equal(t, "line 4: DROP is not allowed in a read-only rule", issues[0].String())
+}
+
+func TestLintSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		dbType     string
+		statements []string
+		expectKeys []string
+	}{
+		{
+			name:       "session settings",
+			dbType:     "postgres",
+			statements: []string{"SET search_path TO billing", "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ"},
+		},
+		{
+			name:   "temp table created and dropped",
+			dbType: "postgres",
+			statements: []string{
+				"CREATE TEMP TABLE IF NOT EXISTS recent AS SELECT * FROM payments",
+				"DROP TABLE IF EXISTS recent",
+			},
+		},
+		{
+			name:       "sqlserver temp tables",
+			dbType:     "sqlserver",
+			statements: []string{"SELECT id INTO #recent FROM payments", "CREATE TABLE #other (id INT)", "DROP TABLE #recent, #other"},
+		},
+		{
+			name:       "mysql temporary table",
+			dbType:     "mysql",
+			statements: []string{"CREATE TEMPORARY TABLE recent SELECT * FROM payments", "DROP TEMPORARY TABLE recent"},
+		},
+		{
+			name:   "writes to temp tables",
+			dbType: "postgres",
+			statements: []string{
+				"CREATE TEMP TABLE recent (id int, total numeric)",
+				"INSERT INTO recent SELECT id, total FROM payments WHERE paid_at > now() - interval '1 day'",
+				"UPDATE recent SET total = 0 WHERE total IS NULL",
+				"DELETE FROM recent WHERE id IN (SELECT id FROM refunds)",
+			},
+		},
+		{
+			name:       "sqlserver writes to temp tables",
+			dbType:     "sqlserver",
+			statements: []string{"INSERT #recent (id) SELECT id FROM payments", "DELETE #recent WHERE id = 0", "UPDATE #recent SET id = 1"},
+		},
+		{
+			name:       "writes to real tables beside a temp table",
+			dbType:     "postgres",
+			statements: []string{"CREATE TEMP TABLE recent (id int)", "INSERT INTO payments SELECT id FROM recent", "UPDATE payments SET id = 0"},
+			expectKeys: []string{"INSERT", "INTO", "UPDATE"},
+		},
+		{
+			name:       "temp table filled by a write",
+			dbType:     "postgres",
+			statements: []string{"CREATE TEMP TABLE recent (id int)", "INSERT INTO recent WITH d AS (DELETE FROM orders RETURNING id) SELECT id FROM d"},
+			expectKeys: []string{"DELETE"},
+		},
+		{
+			name:       "dropping a real table",
+			dbType:     "postgres",
+			statements: []string{"CREATE TEMP TABLE recent (id int)", "DROP TABLE recent, payments"},
+			expectKeys: []string{"DROP"},
+		},
+		{
+			name:       "writes",
+			dbType:     "sqlite",
+			statements: []string{"INSERT INTO audit VALUES (1)", "CREATE TABLE copy AS SELECT 1"},
+			expectKeys: []string{"INSERT", "INTO", "CREATE"},
+		},
+		{
+			name:       "global settings",
+			dbType:     "mysql",
+			statements: []string{"SET GLOBAL max_connections = 10"},
+			expectKeys: []string{"SET GLOBAL"},
+		},
+		{
+			name:       "temp table created from a write",
+			dbType:     "postgres",
+			statements: []string{"CREATE TEMP TABLE gone AS WITH d AS (DELETE FROM orders RETURNING id) SELECT * FROM d"},
+			expectKeys: []string{"DELETE"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keys []string
+			for _, issue := range LintSession(tt.dbType, tt.statements) {
+				keys = append(keys, issue.Keyword)
+			}
+			assert.Equal(t, tt.expectKeys, keys)
+		})
+	}
+
+	issues := LintSession("postgres", []string{"SET search_path TO billing", "TRUNCATE orders"})
+	if assert.Len(t, issues, 1) {
+		assert.Equal(t, "statement 2, line 1: TRUNCATE is not allowed in a read-only rule", issues[0].String())
+	}
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/nathanthorell/dataspy/config"
+)
+
+const redactedValue = "[REDACTED]"
+
+type compiledMask struct {
+	column string
+	value  *regexp.Regexp
+	mode   string
+	// key is the HMAC key for hash mode
+	key []byte
+}
+
+// masker applies mask rules to a result set before it leaves the package
+type masker struct {
+	masks []compiledMask
+}
+
+func newMasker(rules []config.MaskRule) (*masker, error) {
+	m := &masker{}
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+		cm := compiledMask{column: strings.ToLower(r.Column), mode: r.Mode}
+		if r.Value != "" {
+			cm.value = regexp.MustCompile(r.Value)
+		}
+		if r.Mode == config.MaskHash {
+			key, err := r.HashKey()
+			if err != nil {
+				return nil, err
+			}
+			cm.key = []byte(key)
+		}
+		m.masks = append(m.masks, cm)
+	}
+	return m, nil
+}
+
+// columnMask returns the first column rule matching the column name
+func (m *masker) columnMask(column string) (compiledMask, bool) {
+	name := strings.ToLower(column)
+	for _, cm := range m.masks {
+		if cm.column == "" {
+			continue
+		}
+		if ok, _ := path.Match(cm.column, name); ok {
+			return cm, true
+		}
+	}
+	return compiledMask{}, false
+}
+
+// apply masks rows in place and returns the columns and rows with any
+// dropped columns removed. Column rules take the whole value; value rules
+// then mask every match in the cells no column rule claimed.
+func (m *masker) apply(columns []string, rows [][]string) ([]string, [][]string) {
+	if len(m.masks) == 0 {
+		return columns, rows
+	}
+
+	colMasks := make([]*compiledMask, len(columns))
+	var keep []int
+	for i, col := range columns {
+		if cm, ok := m.columnMask(col); ok {
+			if cm.mode == config.MaskDrop {
+				continue
+			}
+			colMasks[i] = &cm
+		}
+		keep = append(keep, i)
+	}
+
+	outColumns := make([]string, len(keep))
+	for j, i := range keep {
+		outColumns[j] = columns[i]
+	}
+
+	for r, row := range rows {
+		out := make([]string, len(keep))
+		for j, i := range keep {
+			val := row[i]
+			if cm := colMasks[i]; cm != nil {
+				out[j] = cm.mask(val)
+				continue
+			}
+			out[j] = m.maskMatches(val)
+		}
+		rows[r] = out
+	}
+	return outColumns, rows
+}
+
+// maskMatches applies the value rules to every match in val
+func (m *masker) maskMatches(val string) string {
+	for _, vm := range m.masks {
+		if vm.value == nil {
+			continue
+		}
+		val = vm.value.ReplaceAllStringFunc(val, func(match string) string {
+			return vm.mask(match)
+		})
+	}
+	return val
+}
+
+// ValueMasker returns a function that masks one value of the named column
+// the way the rules would mask it in a result set, for output built from
+// unmasked results such as a reconciliation's differences. A dropped
+// column's values are redacted, since there is no column to drop.
+func ValueMasker(rules []config.MaskRule) (func(column, value string) string, error) {
+	m, err := newMasker(rules)
+	if err != nil {
+		return nil, err
+	}
+	return func(column, value string) string {
+		cm, ok := m.columnMask(column)
+		switch {
+		case !ok:
+			return m.maskMatches(value)
+		case cm.mode == config.MaskDrop:
+			if value == "NULL" {
+				return value
+			}
+			return redactedValue
+		default:
+			return cm.mask(value)
+		}
+	}, nil
+}
+
+// applyProfile masks a profile's result, where a column's values sit in the
+// value column beside its name rather than under it. Column rules mask the
+// minimum, maximum and common values of the profiled columns they match; a
+// dropped column keeps only its counts.
+func (m *masker) applyProfile(columns []string, rows [][]string) [][]string {
+	if len(m.masks) == 0 {
+		return rows
+	}
+	idx := make(map[string]int, len(columns))
+	for i, col := range columns {
+		idx[strings.ToLower(col)] = i
+	}
+	nameCol, okName := idx[ProfileColumns[0]]
+	metricCol, okMetric := idx[ProfileColumns[1]]
+	valueCol, okValue := idx[ProfileColumns[2]]
+	if !okName || !okMetric || !okValue {
+		return rows
+	}
+
+	out := rows[:0]
+	for _, row := range rows {
+		switch row[metricCol] {
+		case config.MetricMin, config.MetricMax, ProfileTopValue:
+			if cm, ok := m.columnMask(row[nameCol]); ok {
+				if cm.mode == config.MaskDrop {
+					continue
+				}
+				row[valueCol] = cm.mask(row[valueCol])
+			}
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+// mask hides a single value. NULLs are left alone since they carry no
+// sensitive data and are useful to see. Hashes are keyed, so values from a
+// small set such as SSNs can't be recovered by hashing every candidate, and
+// stable, so the same value masks the same way on every server.
+func (cm compiledMask) mask(val string) string {
+	if val == "NULL" {
+		return val
+	}
+	switch cm.mode {
+	case config.MaskHash:
+		mac := hmac.New(sha256.New, cm.key)
+		mac.Write([]byte(val))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	case config.MaskPartial:
+		return partialMask(val)
+	default:
+		return redactedValue
+	}
+}
+
+// partialMask keeps just enough of a value to recognise it: the first
+// character and domain of an email address, otherwise the last four
+// characters
+func partialMask(val string) string {
+	runes := []rune(val)
+	if at := strings.LastIndex(val, "@"); at > 0 {
+		local := []rune(val[:at])
+		return fmt.Sprintf("%c%s%s", local[0], strings.Repeat("*", len(local)-1), val[at:])
+	}
+	if len(runes) <= 4 {
+		return strings.Repeat("*", len(runes))
+	}
+	return strings.Repeat("*", len(runes)-4) + string(runes[len(runes)-4:])
+}
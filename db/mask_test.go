@@ -0,0 +1,124 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskerApply(t *testing.T) {
+	t.Setenv("TEST_MASKING_KEY", "s3cret")
+	columns := []string{"id", "Email", "ssn", "notes", "card_number"}
+	rows := [][]string{
+		{"1", "jane@example.com", "123-45-6789", "card 4111111111111111 on file", "4111111111111111"},
+		{"2", "NULL", "987-65-4321", "no card", "NULL"},
+	}
+
+	m, err := newMasker([]config.MaskRule{
+		{Column: "*email*", Mode: config.MaskPartial},
+		{Column: "ssn", Mode: config.MaskHash, KeyRef: "env:TEST_MASKING_KEY"},
+		{Column: "card_*", Mode: config.MaskDrop},
+		{Value: `\b\d{16}\b`, Mode: config.MaskRedact},
+	})
+	assert.NoError(t, err)
+
+	gotCols, gotRows := m.apply(columns, rows)
+
+	assert.Equal(t, []string{"id", "Email", "ssn", "notes"}, gotCols)
+	assert.Equal(t, "j***@example.com", gotRows[0][1])
+	assert.Equal(t, "NULL", gotRows[1][1])
+	assert.Regexp(t, `^hmac:[0-9a-f]{16}$`, gotRows[0][2])
+	assert.NotEqual(t, gotRows[0][2], gotRows[1][2])
+	assert.Equal(t, "card [REDACTED] on file", gotRows[0][3])
+	assert.Equal(t, "no card", gotRows[1][3])
+	for _, row := range gotRows {
+		assert.Len(t, row, 4)
+	}
+}
+
+func TestValueMasker(t *testing.T) {
+	mask, err := ValueMasker([]config.MaskRule{
+		{Column: "*email*", Mode: config.MaskPartial},
+		{Column: "card_*", Mode: config.MaskDrop},
+		{Value: `\b\d{16}\b`, Mode: config.MaskRedact},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "j***@example.com", mask("Email", "jane@example.com"))
+	assert.Equal(t, redactedValue, mask("card_number", "4111111111111111"))
+	assert.Equal(t, "NULL", mask("card_number", "NULL"))
+	assert.Equal(t, "card [REDACTED] on file", mask("notes", "card 4111111111111111 on file"))
+	assert.Equal(t, "42", mask("id", "42"))
+
+	_, err = ValueMasker([]config.MaskRule{{Column: "ssn", Mode: config.MaskHash}})
+	assert.ErrorContains(t, err, "requires a MaskingKey")
+}
+
+func TestMaskerHashKey(t *testing.T) {
+	t.Setenv("TEST_MASKING_KEY", "s3cret")
+	t.Setenv("OTHER_MASKING_KEY", "different")
+	hash := func(keyRef, val string) string {
+		m, err := newMasker([]config.MaskRule{{Column: "ssn", Mode: config.MaskHash, KeyRef: keyRef}})
+		assert.NoError(t, err)
+		_, rows := m.apply([]string{"ssn"}, [][]string{{val}})
+		return rows[0][0]
+	}
+
+	// Stable for the same key, so reconciliations still compare equal, but
+	// unlike a bare digest it depends on the key
+	assert.Equal(t, hash("env:TEST_MASKING_KEY", "123-45-6789"), hash("env:TEST_MASKING_KEY", "123-45-6789"))
+	assert.NotEqual(t, hash("env:TEST_MASKING_KEY", "123-45-6789"), hash("env:OTHER_MASKING_KEY", "123-45-6789"))
+
+	_, err := newMasker([]config.MaskRule{{Column: "ssn", Mode: config.MaskHash}})
+	assert.ErrorContains(t, err, "requires a MaskingKey")
+}
+
+func TestMaskerApplyProfile(t *testing.T) {
+	rows := [][]string{
+		{"email", config.MetricNullCount, "1", "4"},
+		{"email", config.MetricMin, "a@example.com", "3"},
+		{"email", config.MetricMax, "d@example.com", "3"},
+		{"email", ProfileTopValue, "a@example.com", "1"},
+		{"ssn", config.MetricDistinctCount, "3", "4"},
+		{"ssn", config.MetricMin, "123-45-6789", "4"},
+		{"country", config.MetricMin, "AU", "4"},
+	}
+
+	m, err := newMasker([]config.MaskRule{
+		{Column: "email", Mode: config.MaskRedact},
+		{Column: "ssn", Mode: config.MaskDrop},
+	})
+	assert.NoError(t, err)
+
+	got := m.applyProfile(ProfileColumns, rows)
+	assert.Equal(t, [][]string{
+		{"email", config.MetricNullCount, "1", "4"},
+		{"email", config.MetricMin, "[REDACTED]", "3"},
+		{"email", config.MetricMax, "[REDACTED]", "3"},
+		{"email", ProfileTopValue, "[REDACTED]", "1"},
+		{"ssn", config.MetricDistinctCount, "3", "4"},
+		{"country", config.MetricMin, "AU", "4"},
+	}, got)
+}
+
+func TestMaskerPrecedence(t *testing.T) {
+	// The first matching column rule wins, so rule-level masks listed ahead
+	// of global ones override them
+	m, err := newMasker([]config.MaskRule{
+		{Column: "phone", Mode: config.MaskPartial},
+		{Column: "phone", Mode: config.MaskRedact},
+	})
+	assert.NoError(t, err)
+
+	_, rows := m.apply([]string{"phone"}, [][]string{{"555-867-5309"}})
+	assert.Equal(t, "********5309", rows[0][0])
+}
+
+func TestNewMaskerInvalid(t *testing.T) {
+	_, err := newMasker([]config.MaskRule{{Value: "[", Mode: config.MaskHash}})
+	assert.Error(t, err)
+
+	_, err = newMasker([]config.MaskRule{{Value: "x", Mode: config.MaskDrop}})
+	assert.Error(t, err)
+}
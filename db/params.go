@@ -0,0 +1,157 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/nathanthorell/dataspy/config"
+)
+
+// identifierPart is what a {{name}} parameter may expand to, per dotted part.
+// Quoting alone would make any value safe, but rejecting odd names catches
+// mistakes like a threshold passed where a schema was expected.
+var identifierPart = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+
+// RenderQuery substitutes parameters into a rule's query. :name becomes a
+// bound parameter using the dialect's placeholder syntax; {{name}} becomes a
+// quoted identifier, for schema and table names that can't be bound. Comments,
+// literals and quoted identifiers are left alone, as are Postgres :: casts.
+// A :name with no parameter is left as written, since colons also appear in
+// array slices such as arr[1:n]; UndefinedParams lists them.
+func RenderQuery(dialect *Dialect, query string, params config.Params) (string, []interface{}, error) {
+	rendered, args, _, err := renderQuery(dialect, query, params)
+	return rendered, args, err
+}
+
+// UndefinedParams returns the :name references in a query that have no
+// parameter, which RenderQuery leaves as written. They're usually typos, but
+// may be legitimate SQL such as an array slice.
+func UndefinedParams(dialect *Dialect, query string, params config.Params) ([]string, error) {
+	_, _, undefined, err := renderQuery(dialect, query, params)
+	return undefined, err
+}
+
+func renderQuery(dialect *Dialect, query string, params config.Params) (string, []interface{}, []string, error) {
+	runes := []rune(query)
+	n := len(runes)
+
+	var out strings.Builder
+	var args []interface{}
+	var undefined []string
+	for i := 0; i < n; {
+		if end := skipQuoted(dialect, runes, i); end > i {
+			out.WriteString(string(runes[i:end]))
+			i = end
+			continue
+		}
+
+		c := runes[i]
+		switch {
+		case c == ':' && i+1 < n && runes[i+1] == ':':
+			out.WriteString("::")
+			i += 2
+		case c == ':' && i+1 < n && isParamStart(runes[i+1]):
+			j := i + 1
+			for j < n && isParamChar(runes[j]) {
+				j++
+			}
+			name := string(runes[i+1 : j])
+			if val, ok := params[name]; ok {
+				args = append(args, val)
+				out.WriteString(dialect.Placeholder(len(args)))
+			} else {
+				undefined = append(undefined, name)
+				out.WriteString(string(runes[i:j]))
+			}
+			i = j
+		case c == '{' && hasPrefixAt(runes, i, []rune("{{")):
+			j := i + 2
+			for j < n && !hasPrefixAt(runes, j, []rune("}}")) {
+				j++
+			}
+			if j >= n {
+				return "", nil, nil, fmt.Errorf("unterminated {{ in query")
+			}
+			ident, err := identifierParam(dialect, strings.TrimSpace(string(runes[i+2:j])), params)
+			if err != nil {
+				return "", nil, nil, err
+			}
+			out.WriteString(ident)
+			i = j + 2
+		default:
+			out.WriteRune(c)
+			i++
+		}
+	}
+	return out.String(), args, undefined, nil
+}
+
+// ParamNames returns the names of the :name references in a query, skipping
+// comments, literals and casts the way RenderQuery does
+func ParamNames(dialect *Dialect, query string) []string {
+	runes := []rune(query)
+	n := len(runes)
+
+	var names []string
+	for i := 0; i < n; {
+		if end := skipQuoted(dialect, runes, i); end > i {
+			i = end
+			continue
+		}
+		switch {
+		case runes[i] == ':' && i+1 < n && runes[i+1] == ':':
+			i += 2
+		case runes[i] == ':' && i+1 < n && isParamStart(runes[i+1]):
+			j := i + 1
+			for j < n && isParamChar(runes[j]) {
+				j++
+			}
+			names = append(names, string(runes[i+1:j]))
+			i = j
+		default:
+			i++
+		}
+	}
+	return names
+}
+
+// identifierParam quotes a parameter's value as a possibly schema-qualified
+// identifier
+func identifierParam(dialect *Dialect, name string, params config.Params) (string, error) {
+	val, ok := params[name]
+	if !ok {
+		return "", fmt.Errorf("undefined parameter {{%s}}", name)
+	}
+	str, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("parameter {{%s}} must be a string to be used as an identifier", name)
+	}
+	quoted, ok := quoteQualified(dialect, str)
+	if !ok {
+		return "", fmt.Errorf("parameter {{%s}}: %q is not a valid identifier", name, str)
+	}
+	return quoted, nil
+}
+
+// quoteQualified quotes each dotted part of a possibly schema-qualified
+// name, and reports false if any part isn't a plain identifier
+func quoteQualified(dialect *Dialect, name string) (string, bool) {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if !identifierPart.MatchString(part) {
+			return "", false
+		}
+		parts[i] = dialect.QuoteIdentifier(part)
+	}
+	return strings.Join(parts, "."), true
+}
+
+func isParamStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isParamChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
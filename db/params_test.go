@@ -0,0 +1,124 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderQuery(t *testing.T) {
+	params := config.Params{
+		"schema":    "tenant_a",
+		"table":     "billing.invoices",
+		"threshold": int64(100),
+		"status":    "open",
+		"bad":       "x; DROP TABLE t",
+	}
+
+	tests := []struct {
+		name        string
+		dbType      string
+		query       string
+		expectQuery string
+		expectArgs  []interface{}
+		expectErr   string
+	}{
+		{
+			name:        "postgres bound and identifier",
+			dbType:      "postgres",
+			query:       "SELECT id FROM {{schema}}.orders WHERE total > :threshold AND status = :status",
+			expectQuery: `SELECT id FROM "tenant_a".orders WHERE total > $1 AND status = $2`,
+			expectArgs:  []interface{}{int64(100), "open"},
+		},
+		{
+			name:        "sqlserver placeholders and qualified identifier",
+			dbType:      "sqlserver",
+			query:       "SELECT id FROM {{ table }} WHERE total > :threshold",
+			expectQuery: "SELECT id FROM [billing].[invoices] WHERE total > @p1",
+			expectArgs:  []interface{}{int64(100)},
+		},
+		{
+			name:        "mysql repeated parameter",
+			dbType:      "mysql",
+			query:       "SELECT :threshold, :threshold",
+			expectQuery: "SELECT ?, ?",
+			expectArgs:  []interface{}{int64(100), int64(100)},
+		},
+		{
+			name:        "casts, literals and comments untouched",
+			dbType:      "postgres",
+			query:       "SELECT created::date, ':status' -- :missing\nFROM t /* {{missing}} */ WHERE s = :status",
+			expectQuery: "SELECT created::date, ':status' -- :missing\nFROM t /* {{missing}} */ WHERE s = $1",
+			expectArgs:  []interface{}{"open"},
+		},
+		{
+			name:        "no parameters",
+			dbType:      "sqlite",
+			query:       "SELECT sqlite_version()",
+			expectQuery: "SELECT sqlite_version()",
+		},
+		{
+			name:        "undefined bound parameter left as written",
+			dbType:      "postgres",
+			query:       "SELECT arr[1:n] FROM t WHERE s = :status",
+			expectQuery: "SELECT arr[1:n] FROM t WHERE s = $1",
+			expectArgs:  []interface{}{"open"},
+		},
+		{
+			name:      "invalid identifier value",
+			dbType:    "postgres",
+			query:     "SELECT * FROM {{bad}}",
+			expectErr: "is not a valid identifier",
+		},
+		{
+			name:      "non-string identifier",
+			dbType:    "postgres",
+			query:     "SELECT * FROM {{threshold}}",
+			expectErr: "must be a string",
+		},
+		{
+			name:      "unterminated identifier",
+			dbType:    "postgres",
+			query:     "SELECT * FROM {{schema",
+			expectErr: "unterminated",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialect, err := LookupDialect(tt.dbType)
+			require.NoError(t, err)
+
+			query, args, err := RenderQuery(dialect, tt.query, params)
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectQuery, query)
+			assert.Equal(t, tt.expectArgs, args)
+		})
+	}
+}
+
+func TestParamNames(t *testing.T) {
+	dialect, err := LookupDialect("postgres")
+	require.NoError(t, err)
+
+	names := ParamNames(dialect, "SELECT created::date FROM t WHERE id > :watermark AND s = ':status' -- :note\nAND x = :x")
+	assert.Equal(t, []string{"watermark", "x"}, names)
+}
+
+func TestUndefinedParams(t *testing.T) {
+	dialect, err := LookupDialect("postgres")
+	require.NoError(t, err)
+
+	undefined, err := UndefinedParams(dialect, "SELECT arr[1:n] FROM t WHERE id = :id AND s = :status -- :note", config.Params{"status": "open"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"n", "id"}, undefined)
+
+	_, err = UndefinedParams(dialect, "SELECT * FROM {{schema}}.t", nil)
+	assert.ErrorContains(t, err, "undefined parameter {{schema}}")
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nathanthorell/dataspy/config"
+)
+
+// ProfileColumns are the columns of a profile's result: one row per metric
+// of a column, and one per common value. Values are text; frequency is the
+// number of rows a metric was computed over, or a common value's count.
+var ProfileColumns = []string{"column_name", "metric", "value", "frequency"}
+
+// Profile result metrics besides those in expectations
+const (
+	ProfileTopValue = "top_value"
+)
+
+// compileProfile builds a query computing each column's null count,
+// distinct count, minimum and maximum in one pass over the table, followed
+// by its most common non-NULL values
+func compileProfile(dialect *Dialect, rule config.Rule, table string, cols []string) string {
+	filter := ""
+	if strings.TrimSpace(rule.Where) != "" {
+		filter = " WHERE (" + rule.Where + ")"
+	}
+	topN := rule.TopN
+	if topN == 0 {
+		topN = config.DefaultTopN
+	}
+	text := func(expr string) string {
+		return fmt.Sprintf("CAST(%s AS %s)", expr, dialect.textType)
+	}
+
+	aggs := []string{"COUNT(*) AS n"}
+	var parts []string
+	for i, col := range cols {
+		name := rule.Columns[i]
+		aggs = append(aggs,
+			fmt.Sprintf("COUNT(%s) AS nn%d", col, i),
+			fmt.Sprintf("COUNT(DISTINCT %s) AS d%d", col, i),
+			fmt.Sprintf("MIN(%s) AS min%d", col, i),
+			fmt.Sprintf("MAX(%s) AS max%d", col, i),
+		)
+		for _, m := range []struct{ metric, expr string }{
+			{config.MetricNullCount, fmt.Sprintf("n - nn%d", i)},
+			{config.MetricDistinctCount, fmt.Sprintf("d%d", i)},
+			{config.MetricMin, fmt.Sprintf("min%d", i)},
+			{config.MetricMax, fmt.Sprintf("max%d", i)},
+		} {
+			parts = append(parts, fmt.Sprintf("SELECT '%s' AS column_name, '%s' AS metric, %s AS value, n AS frequency FROM s",
+				name, m.metric, text(m.expr)))
+		}
+
+		top := fmt.Sprintf("SELECT %s AS v, COUNT(*) AS c FROM %s WHERE %s IS NOT NULL", col, table, col)
+		if filter != "" {
+			top += " AND (" + rule.Where + ")"
+		}
+		top += fmt.Sprintf(" GROUP BY %s ORDER BY COUNT(*) DESC, %s", col, col)
+		if dialect.selectTop {
+			top = fmt.Sprintf("SELECT TOP %d", topN) + strings.TrimPrefix(top, "SELECT")
+		} else {
+			top += fmt.Sprintf(" LIMIT %d", topN)
+		}
+		parts = append(parts, fmt.Sprintf("SELECT '%s', '%s', %s, c FROM (%s) top%d",
+			name, ProfileTopValue, text("v"), top, i))
+	}
+
+	return fmt.Sprintf("WITH s AS (SELECT %s FROM %s%s)\n%s",
+		strings.Join(aggs, ", "), table, filter, strings.Join(parts, "\nUNION ALL\n"))
+}
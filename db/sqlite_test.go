@@ -0,0 +1,343 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+// newSQLiteDB creates a file database with a small customers table
+func newSQLiteDB(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE customers (id INTEGER PRIMARY KEY, email TEXT, address TEXT);
+		INSERT INTO customers (email, address) VALUES
+			('alice@example.com', '1 Main St'),
+			('bob@example.com', NULL),
+			(NULL, NULL);
+	`)
+	require.NoError(t, err)
+	return path
+}
+
+func countCustomers(t *testing.T, path string) int {
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var n int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM customers").Scan(&n))
+	return n
+}
+
+func TestExecuteRuleSQLite(t *testing.T) {
+	path := newSQLiteDB(t)
+	t.Setenv("SQLITE_DBCONN", path)
+
+	tests := []struct {
+		name       string
+		server     config.DbServer
+		rule       config.Rule
+		expectErr  bool
+		expectRows [][]string
+	}{
+		{
+			name:   "path connection",
+			server: config.DbServer{Name: "local", Type: "sqlite", Path: path},
+			rule: config.Rule{
+				Name:  "missing-address",
+				Query: "SELECT id, email FROM customers WHERE address IS NULL ORDER BY id",
+			},
+			expectRows: [][]string{{"2", "bob@example.com"}, {"3", "NULL"}},
+		},
+		{
+			name:   "connection string variable with type alias",
+			server: config.DbServer{Name: "local", Type: "sqlite3", ConnStringVar: "SQLITE_DBCONN"},
+			rule: config.Rule{
+				Name:  "count",
+				Query: "SELECT COUNT(*) AS n FROM customers",
+			},
+			expectRows: [][]string{{"3"}},
+		},
+		{
+			name:   "parameters",
+			server: config.DbServer{Name: "local", Type: "sqlite", Path: path},
+			rule: config.Rule{
+				Name:   "by-domain",
+				Query:  "SELECT id FROM {{table}} WHERE email LIKE :pattern",
+				Params: config.Params{"table": "customers", "pattern": "%@example.com"},
+			},
+			expectRows: [][]string{{"1"}, {"2"}},
+		},
+		{
+			name:   "masking",
+			server: config.DbServer{Name: "local", Type: "sqlite", Path: path},
+			rule: config.Rule{
+				Name:    "emails",
+				Query:   "SELECT email FROM customers WHERE id = 1",
+				Masking: []config.MaskRule{{Column: "email", Mode: config.MaskPartial}},
+			},
+			expectRows: [][]string{{"a****@example.com"}},
+		},
+		{
+			name:   "read-only path connection rejects writes",
+			server: config.DbServer{Name: "local", Type: "sqlite", Path: path, ReadOnly: true},
+			rule: config.Rule{
+				Name:  "delete",
+				Query: "DELETE FROM customers",
+			},
+			expectErr: true,
+		},
+		{
+			name:   "read-only connection string rejects writes",
+			server: config.DbServer{Name: "local", Type: "sqlite", ConnStringVar: "SQLITE_DBCONN", ReadOnly: true},
+			rule: config.Rule{
+				Name:  "delete",
+				Query: "DELETE FROM customers",
+			},
+			expectErr: true,
+		},
+		{
+			name:   "read-only allows reads",
+			server: config.DbServer{Name: "local", Type: "sqlite", Path: path, ReadOnly: true},
+			rule: config.Rule{
+				Name:  "count",
+				Query: "SELECT COUNT(*) FROM customers",
+			},
+			expectRows: [][]string{{"3"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.DbType = "sqlite"
+			result, err := ExecuteRule("exec-1", tt.server, tt.rule)
+
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectRows, result.Rows)
+				assert.Equal(t, int64(len(tt.expectRows)), result.RowCount)
+			}
+			assert.Equal(t, 3, countCustomers(t, path))
+		})
+	}
+}
+
+func TestExecuteRuleSQLiteReadOnlyMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.db")
+	server := config.DbServer{Name: "local", Type: "sqlite", Path: path, ReadOnly: true}
+	rule := config.Rule{Name: "version", DbType: "sqlite", Query: "SELECT sqlite_version()"}
+
+	_, err := ExecuteRule("exec-1", server, rule)
+	assert.Error(t, err)
+	assert.NoFileExists(t, path)
+}
+
+func TestExecuteChecksSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checks.db")
+	conn, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	recent := time.Now().UTC().Add(-time.Hour).Format("2006-01-02 15:04:05")
+	_, err = conn.Exec(`
+		CREATE TABLE customers (id INTEGER PRIMARY KEY, email TEXT);
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, customer_id INTEGER, total REAL, created_at TEXT);
+		INSERT INTO customers (id, email) VALUES (1, 'a@example.com'), (2, 'b@example.com'), (3, 'a@example.com');
+		INSERT INTO orders (id, customer_id, total, created_at) VALUES
+			(10, 1, 25.0, '2026-01-01 00:00:00'),
+			(11, 9, -5.0, ?),
+			(12, NULL, 40.0, '2026-01-02 00:00:00');
+	`, recent)
+	require.NoError(t, err)
+	conn.Close()
+
+	server := config.DbServer{Name: "local", Type: "sqlite", Path: path, ReadOnly: true}
+	tests := []struct {
+		name       string
+		rule       config.Rule
+		expectRows [][]string
+	}{
+		{
+			name:       "not null",
+			rule:       config.Rule{Kind: config.CheckNotNull, Table: "orders", Columns: []string{"customer_id"}},
+			expectRows: [][]string{{"12", "NULL", "40", "2026-01-02 00:00:00"}},
+		},
+		{
+			name:       "unique",
+			rule:       config.Rule{Kind: config.CheckUnique, Table: "customers", Columns: []string{"email"}},
+			expectRows: [][]string{{"a@example.com", "2"}},
+		},
+		{
+			name: "references",
+			rule: config.Rule{
+				Kind: config.CheckReferences, Table: "orders", Columns: []string{"customer_id"},
+				RefTable: "customers", RefColumns: []string{"id"},
+			},
+			expectRows: [][]string{{"11", "9", "-5", recent}},
+		},
+		{
+			name:       "fresh",
+			rule:       config.Rule{Kind: config.CheckFreshness, Table: "orders", Columns: []string{"created_at"}, MaxAge: "2h"},
+			expectRows: nil,
+		},
+		{
+			name: "stale with filter",
+			rule: config.Rule{
+				Kind: config.CheckFreshness, Table: "orders", Columns: []string{"created_at"}, MaxAge: "2h",
+				Where: "customer_id = :customer", Params: config.Params{"customer": int64(1)},
+			},
+			expectRows: [][]string{{"2026-01-01 00:00:00"}},
+		},
+		{
+			name:       "range",
+			rule:       config.Rule{Kind: config.CheckRange, Table: "orders", Columns: []string{"total"}, Min: int64(0)},
+			expectRows: [][]string{{"11", "9", "-5", recent}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name = tt.name
+			tt.rule.DbType = "sqlite"
+			result, err := ExecuteRule("exec-1", server, tt.rule)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectRows, result.Rows)
+			assert.Equal(t, int64(len(tt.expectRows)), result.RowCount)
+		})
+	}
+}
+
+func TestExecutePhasesSQLite(t *testing.T) {
+	path := newSQLiteDB(t)
+	t.Setenv("SQLITE_DBCONN", path)
+	conn, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Exec("CREATE TABLE teardowns (rule TEXT)")
+	require.NoError(t, err)
+
+	tornDown := func(rule string) bool {
+		var n int
+		require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM teardowns WHERE rule = ?", rule).Scan(&n))
+		return n > 0
+	}
+
+	server := config.DbServer{Name: "local", Type: "sqlite", ConnStringVar: "SQLITE_DBCONN"}
+	readOnly := server
+	readOnly.ReadOnly = true
+
+	tests := []struct {
+		name       string
+		server     config.DbServer
+		rule       config.Rule
+		expectErr  string
+		expectRows [][]string
+		phases     []string
+	}{
+		{
+			name:   "setup state is visible to the query",
+			server: readOnly,
+			rule: config.Rule{
+				Name:     "temp-table",
+				Setup:    []string{"CREATE TEMP TABLE missing AS SELECT id FROM customers WHERE address IS NULL"},
+				Query:    "SELECT COUNT(*) FROM missing",
+				Teardown: []string{"DROP TABLE missing"},
+			},
+			expectRows: [][]string{{"2"}},
+			phases:     []string{PhaseSetup, PhaseQuery, PhaseTeardown},
+		},
+		{
+			name:   "read-only server refuses writes outside the transaction",
+			server: readOnly,
+			rule: config.Rule{
+				Name:     "writes",
+				Query:    "SELECT 1",
+				Teardown: []string{"INSERT INTO teardowns VALUES ('writes')"},
+			},
+			expectErr: "may only change the session",
+		},
+		{
+			name:   "setup with parameters",
+			server: server,
+			rule: config.Rule{
+				Name:   "params",
+				Setup:  []string{"CREATE TEMP TABLE picked AS SELECT id FROM customers WHERE id <= :max_id"},
+				Query:  "SELECT id FROM picked ORDER BY id",
+				Params: config.Params{"max_id": int64(2)},
+			},
+			expectRows: [][]string{{"1"}, {"2"}},
+			phases:     []string{PhaseSetup, PhaseQuery},
+		},
+		{
+			name:   "teardown runs when the query fails",
+			server: server,
+			rule: config.Rule{
+				Name:     "bad-query",
+				Query:    "SELECT nope FROM customers",
+				Teardown: []string{"INSERT INTO teardowns VALUES ('bad-query')"},
+			},
+			expectErr: "failed to execute query",
+			phases:    []string{PhaseQuery, PhaseTeardown},
+		},
+		{
+			name:   "teardown runs when setup fails",
+			server: server,
+			rule: config.Rule{
+				Name:     "bad-setup",
+				Setup:    []string{"CREATE TEMP TABLE t (id INTEGER)", "CREATE TEMP TABLE t (id INTEGER)"},
+				Query:    "SELECT 1",
+				Teardown: []string{"INSERT INTO teardowns VALUES ('bad-setup')"},
+			},
+			expectErr: "setup statement 2 failed",
+			phases:    []string{PhaseSetup, PhaseTeardown},
+		},
+		{
+			name:   "failed teardown fails the rule",
+			server: server,
+			rule: config.Rule{
+				Name:     "bad-teardown",
+				Query:    "SELECT 1",
+				Teardown: []string{"DROP TABLE never_created"},
+			},
+			expectErr: "teardown statement 1 failed",
+			phases:    []string{PhaseQuery, PhaseTeardown},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.DbType = "sqlite"
+			result, err := ExecuteRule("exec-1", tt.server, tt.rule)
+
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectRows, result.Rows)
+			}
+			var phases []string
+			for _, p := range result.Phases {
+				phases = append(phases, p.Phase)
+			}
+			assert.Equal(t, tt.phases, phases)
+			if len(tt.rule.Teardown) > 0 && !tt.server.ReadOnly && tt.rule.Name != "bad-teardown" {
+				assert.True(t, tornDown(tt.rule.Name), "teardown didn't run")
+			}
+			if tt.server.ReadOnly {
+				assert.False(t, tornDown(tt.rule.Name), "read-only rule wrote")
+			}
+			assert.Equal(t, 3, countCustomers(t, path))
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package drift
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nathanthorell/dataspy/storage"
+)
+
+// Kinds of change between two snapshots
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Columns are the columns of the result set a schema check reports, one row
+// per change
+var Columns = []string{"table_name", "object_type", "object_name", "change", "detail"}
+
+// Change is one difference between the baseline schema and the current one
+type Change struct {
+	storage.SchemaObject
+	Kind   string
+	Detail string
+}
+
+// Row returns the change as a row under Columns
+func (c Change) Row() []string {
+	return []string{c.Table, c.Type, c.Name, c.Kind, c.Detail}
+}
+
+// ParseObjects reads the schema objects from a catalog query's result,
+// matching columns by name, and sorts them by table, type and name
+func ParseObjects(columns []string, rows [][]string) ([]storage.SchemaObject, error) {
+	idx := make(map[string]int, len(columns))
+	for i, col := range columns {
+		idx[strings.ToLower(col)] = i
+	}
+	var pos [4]int
+	for i, name := range []string{"table_name", "object_type", "object_name", "definition"} {
+		p, ok := idx[name]
+		if !ok {
+			return nil, fmt.Errorf("catalog result has no %s column", name)
+		}
+		pos[i] = p
+	}
+
+	objects := make([]storage.SchemaObject, len(rows))
+	for i, row := range rows {
+		objects[i] = storage.SchemaObject{
+			Table:      row[pos[0]],
+			Type:       row[pos[1]],
+			Name:       row[pos[2]],
+			Definition: row[pos[3]],
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objectKey(objects[i]) < objectKey(objects[j])
+	})
+	return objects, nil
+}
+
+func objectKey(o storage.SchemaObject) string {
+	return o.Table + "\x00" + o.Type + "\x00" + o.Name
+}
+
+// Diff returns the objects added, removed or redefined between base and
+// current, ordered by table, type and name
+func Diff(base, current []storage.SchemaObject) []Change {
+	before := make(map[string]storage.SchemaObject, len(base))
+	for _, o := range base {
+		before[objectKey(o)] = o
+	}
+	after := make(map[string]storage.SchemaObject, len(current))
+	for _, o := range current {
+		after[objectKey(o)] = o
+	}
+
+	var changes []Change
+	for key, o := range before {
+		now, ok := after[key]
+		switch {
+		case !ok:
+			changes = append(changes, Change{SchemaObject: o, Kind: Removed, Detail: "was " + o.Definition})
+		case now.Definition != o.Definition:
+			changes = append(changes, Change{
+				SchemaObject: now,
+				Kind:         Changed,
+				Detail:       fmt.Sprintf("%s -> %s", o.Definition, now.Definition),
+			})
+		}
+	}
+	for key, o := range after {
+		if _, ok := before[key]; !ok {
+			changes = append(changes, Change{SchemaObject: o, Kind: Added, Detail: o.Definition})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return objectKey(changes[i].SchemaObject) < objectKey(changes[j].SchemaObject)
+	})
+	return changes
+}
+
+// MissingTables returns the tables with nothing in objects, which don't
+// exist or can't be seen by the connection
+func MissingTables(tables []string, objects []storage.SchemaObject) []string {
+	found := make(map[string]bool)
+	for _, o := range objects {
+		found[o.Table] = true
+	}
+	var missing []string
+	for _, t := range tables {
+		if !found[t] {
+			missing = append(missing, t)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,64 @@
+package drift
+
+import (
+	"testing"
+
+	"github.com/nathanthorell/dataspy/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func column(table, name, def string) storage.SchemaObject {
+	return storage.SchemaObject{Table: table, Type: "column", Name: name, Definition: def}
+}
+
+func TestParseObjects(t *testing.T) {
+	objects, err := ParseObjects(
+		[]string{"TABLE_NAME", "object_type", "object_name", "definition"},
+		[][]string{
+			{"orders", "index", "ix_total", "(total)"},
+			{"orders", "column", "total", "numeric(10,2) NULL"},
+			{"customers", "column", "id", "integer NOT NULL"},
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.SchemaObject{
+		column("customers", "id", "integer NOT NULL"),
+		column("orders", "total", "numeric(10,2) NULL"),
+		{Table: "orders", Type: "index", Name: "ix_total", Definition: "(total)"},
+	}, objects)
+
+	_, err = ParseObjects([]string{"table_name", "object_name"}, nil)
+	assert.ErrorContains(t, err, "no object_type column")
+}
+
+func TestDiff(t *testing.T) {
+	base := []storage.SchemaObject{
+		column("orders", "id", "integer NOT NULL"),
+		column("orders", "total", "numeric(10,2) NULL"),
+		column("orders", "note", "text NULL"),
+	}
+	current := []storage.SchemaObject{
+		column("orders", "id", "bigint NOT NULL"),
+		column("orders", "total", "numeric(10,2) NULL"),
+		column("orders", "status", "varchar(20) NOT NULL"),
+	}
+
+	changes := Diff(base, current)
+	rows := make([][]string, len(changes))
+	for i, c := range changes {
+		rows[i] = c.Row()
+	}
+	assert.Equal(t, [][]string{
+		{"orders", "column", "id", Changed, "integer NOT NULL -> bigint NOT NULL"},
+		{"orders", "column", "note", Removed, "was text NULL"},
+		{"orders", "column", "status", Added, "varchar(20) NOT NULL"},
+	}, rows)
+
+	assert.Empty(t, Diff(current, current))
+}
+
+func TestMissingTables(t *testing.T) {
+	objects := []storage.SchemaObject{column("sales.orders", "id", "integer NOT NULL")}
+	assert.Equal(t, []string{"customers"}, MissingTables([]string{"sales.orders", "customers"}, objects))
+}
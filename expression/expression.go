@@ -0,0 +1,174 @@
+package expression
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+)
+
+// Variables available to expressions
+const (
+	VarRows     = "rows"      // the result rows, as maps of column name to value
+	VarResult   = "result"    // an alias of rows
+	VarRowCount = "row_count" // the number of rows
+	VarColumns  = "columns"   // the column names, in order
+)
+
+// Program is a compiled expression, ready to evaluate against result sets
+type Program struct {
+	source  string
+	program cel.Program
+}
+
+var env *cel.Env
+
+func init() {
+	var err error
+	env, err = cel.NewEnv(
+		cel.Variable(VarRows, cel.ListType(cel.MapType(cel.StringType, cel.DynType))),
+		cel.Variable(VarResult, cel.ListType(cel.MapType(cel.StringType, cel.DynType))),
+		cel.Variable(VarRowCount, cel.IntType),
+		cel.Variable(VarColumns, cel.ListType(cel.StringType)),
+		// Let integer columns compare with fractional literals and vice versa
+		cel.CrossTypeNumericComparisons(true),
+	)
+	if err != nil {
+		panic(fmt.Sprintf("expression: failed to create environment: %v", err))
+	}
+}
+
+// Compile parses and type-checks a CEL expression, which must evaluate to a
+// bool. Errors quote the expression and point at the offending position.
+func Compile(source string) (*Program, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, fmt.Errorf("expression is empty")
+	}
+	ast, issues := env.Compile(source)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression:\n%s", issues.Err())
+	}
+	if t := ast.OutputType(); !t.IsExactType(types.BoolType) && !t.IsExactType(types.DynType) {
+		return nil, fmt.Errorf("expression %q must evaluate to a bool, not %s", source, t)
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", source, err)
+	}
+	return &Program{source: source, program: program}, nil
+}
+
+// String returns the expression's source
+func (p *Program) String() string {
+	return p.source
+}
+
+// Eval evaluates the expression against a result set, typed by Typed
+func (p *Program) Eval(columns []string, rows [][]string) (bool, error) {
+	typed := Typed(columns, rows)
+	out, _, err := p.program.Eval(map[string]interface{}{
+		VarRows:     typed,
+		VarResult:   typed,
+		VarRowCount: int64(len(rows)),
+		VarColumns:  columns,
+	})
+	if err != nil {
+		return false, fmt.Errorf("expression %q: %w", p.source, err)
+	}
+	b, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q evaluated to %v, not a bool", p.source, out.Value())
+	}
+	return b, nil
+}
+
+// Typed converts a result set's text values to maps of typed values. Each
+// column gets the narrowest type all its values parse as: int, then double,
+// then bool, and otherwise string. Typing whole columns keeps a text column
+// that happens to hold a number in one row a string throughout, and numbers
+// with leading zeros, like codes, stay strings. NULL becomes null.
+func Typed(columns []string, rows [][]string) []map[string]interface{} {
+	kinds := make([]columnKind, len(columns))
+	for i := range columns {
+		kinds[i] = inferKind(rows, i)
+	}
+
+	out := make([]map[string]interface{}, len(rows))
+	for r, row := range rows {
+		m := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			m[col] = kinds[i].convert(row[i])
+		}
+		out[r] = m
+	}
+	return out
+}
+
+type columnKind int
+
+const (
+	kindInt columnKind = iota
+	kindDouble
+	kindBool
+	kindString
+)
+
+// null is how the executor renders NULL
+const null = "NULL"
+
+func inferKind(rows [][]string, col int) columnKind {
+	for kind := kindInt; kind < kindString; kind++ {
+		all := true
+		for _, row := range rows {
+			if v := row[col]; v != null && !kind.accepts(v) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return kind
+		}
+	}
+	return kindString
+}
+
+func (k columnKind) accepts(v string) bool {
+	switch k {
+	case kindInt:
+		if len(v) > 1 && (v[0] == '0' || strings.HasPrefix(v, "-0")) {
+			return false
+		}
+		_, err := strconv.ParseInt(v, 10, 64)
+		return err == nil
+	case kindDouble:
+		if len(v) > 1 && v[0] == '0' && v[1] != '.' {
+			return false
+		}
+		_, err := strconv.ParseFloat(v, 64)
+		return err == nil
+	case kindBool:
+		return v == "true" || v == "false"
+	default:
+		return true
+	}
+}
+
+func (k columnKind) convert(v string) interface{} {
+	if v == null {
+		return types.NullValue
+	}
+	switch k {
+	case kindInt:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	case kindDouble:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	case kindBool:
+		return v == "true"
+	default:
+		return v
+	}
+}
@@ -0,0 +1,89 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTyped(t *testing.T) {
+	rows := Typed(
+		[]string{"id", "balance", "zip", "active", "note"},
+		[][]string{
+			{"1", "10", "01234", "true", "x"},
+			{"2", "-2.5", "98765", "false", "NULL"},
+		},
+	)
+	assert.Equal(t, map[string]interface{}{
+		"id": int64(1), "balance": 10.0, "zip": "01234", "active": true, "note": "x",
+	}, rows[0])
+	assert.Equal(t, types.NullValue, rows[1]["note"])
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		expectErr string
+	}{
+		{name: "all rows", source: "rows.all(r, r.balance >= 0) && row_count < 10"},
+		{name: "first row", source: "result[0].lag_seconds < 300"},
+		{name: "columns", source: `"balance" in columns`},
+		{name: "syntax error", source: "rows.all(r, r.balance >= ) ", expectErr: "Syntax error"},
+		{name: "unknown variable", source: "rowcount > 0", expectErr: "undeclared reference to 'rowcount'"},
+		{name: "not a bool", source: "row_count + 1", expectErr: "must evaluate to a bool, not int"},
+		{name: "empty", source: " ", expectErr: "expression is empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.source)
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	// Errors point at the offending position
+	_, err := Compile("row_count > 0 && rowz.size() > 0")
+	assert.ErrorContains(t, err, "1:18")
+}
+
+func TestEval(t *testing.T) {
+	columns := []string{"account", "balance", "lag_seconds"}
+	rows := [][]string{
+		{"a", "100.50", "12"},
+		{"b", "0", "400"},
+	}
+
+	tests := []struct {
+		source    string
+		expect    bool
+		expectErr string
+	}{
+		{source: "rows.all(r, r.balance >= 0) && row_count < 10", expect: true},
+		{source: "result[0].lag_seconds < 300", expect: true},
+		{source: "rows.all(r, r.lag_seconds < 300)", expect: false},
+		{source: "rows.exists(r, r.account == 'b' && r.balance == 0)", expect: true},
+		{source: "result[0].missing > 0", expectErr: "no such key: missing"},
+		{source: "result[5].balance > 0", expectErr: "index out of bounds: 5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			p, err := Compile(tt.source)
+			require.NoError(t, err)
+			got, err := p.Eval(columns, rows)
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, got)
+		})
+	}
+}
@@ -36,7 +36,8 @@ var (
 
 // Logger is a wrapper around charm log
 type Logger struct {
-	logger *log.Logger
+	logger    *log.Logger
+	resultOut io.Writer
 }
 
 func New() *Logger {
@@ -47,7 +48,8 @@ func New() *Logger {
 	})
 
 	return &Logger{
-		logger: l,
+		logger:    l,
+		resultOut: os.Stdout,
 	}
 }
 
@@ -55,6 +57,11 @@ func (l *Logger) SetOutput(w io.Writer) {
 	l.logger.SetOutput(w)
 }
 
+// SetResultOutput changes where query results are printed (stdout by default)
+func (l *Logger) SetResultOutput(w io.Writer) {
+	l.resultOut = w
+}
+
 func (l *Logger) Info(msg string, args ...interface{}) {
 	l.logger.Info(msg, args...)
 }
@@ -77,21 +84,21 @@ func (l *Logger) Warn(msg string, args ...interface{}) {
 	l.logger.SetPrefix("")
 }
 
-func (l *Logger) Task(name string, msg string) {
+func (l *Logger) Task(name string, msg string, args ...interface{}) {
 	l.logger.SetPrefix(taskPrefix)
-	l.logger.Info(fmt.Sprintf("%s: %s", highlightStyle.Render(name), msg))
+	l.logger.Info(fmt.Sprintf("%s: %s", highlightStyle.Render(name), msg), args...)
 	l.logger.SetPrefix("")
 }
 
-func (l *Logger) Rule(name string, msg string) {
+func (l *Logger) Rule(name string, msg string, args ...interface{}) {
 	l.logger.SetPrefix(rulePrefix)
-	l.logger.Info(fmt.Sprintf("%s: %s", highlightStyle.Render(name), msg))
+	l.logger.Info(fmt.Sprintf("%s: %s", highlightStyle.Render(name), msg), args...)
 	l.logger.SetPrefix("")
 }
 
-func (l *Logger) DB(name string, msg string) {
+func (l *Logger) DB(name string, msg string, args ...interface{}) {
 	l.logger.SetPrefix(dbPrefix)
-	l.logger.Info(fmt.Sprintf("%s: %s", highlightStyle.Render(name), msg))
+	l.logger.Info(fmt.Sprintf("%s: %s", highlightStyle.Render(name), msg), args...)
 	l.logger.SetPrefix("")
 }
 
@@ -99,18 +106,18 @@ func (l *Logger) DB(name string, msg string) {
 func (l *Logger) Result(ruleName string, result string) {
 	l.logger.SetPrefix(successPrefix)
 	l.logger.Info(fmt.Sprintf("Results for %s:", highlightStyle.Render(ruleName)))
-	fmt.Println(result)
+	fmt.Fprintln(l.resultOut, result)
 	l.logger.SetPrefix("")
 }
 
 var DefaultLogger = New()
 
 // Expose global logger functions
-func Info(msg string, args ...interface{})             { DefaultLogger.Info(msg, args...) }
-func Success(msg string, args ...interface{})          { DefaultLogger.Success(msg, args...) }
-func Error(err error, msg string, args ...interface{}) { DefaultLogger.Error(err, msg, args...) }
-func Warn(msg string, args ...interface{})             { DefaultLogger.Warn(msg, args...) }
-func Task(name string, msg string)                     { DefaultLogger.Task(name, msg) }
-func Rule(name string, msg string)                     { DefaultLogger.Rule(name, msg) }
-func DB(name string, msg string)                       { DefaultLogger.DB(name, msg) }
-func Result(ruleName string, result string)            { DefaultLogger.Result(ruleName, result) }
+func Info(msg string, args ...interface{})              { DefaultLogger.Info(msg, args...) }
+func Success(msg string, args ...interface{})           { DefaultLogger.Success(msg, args...) }
+func Error(err error, msg string, args ...interface{})  { DefaultLogger.Error(err, msg, args...) }
+func Warn(msg string, args ...interface{})              { DefaultLogger.Warn(msg, args...) }
+func Task(name string, msg string, args ...interface{}) { DefaultLogger.Task(name, msg, args...) }
+func Rule(name string, msg string, args ...interface{}) { DefaultLogger.Rule(name, msg, args...) }
+func DB(name string, msg string, args ...interface{})   { DefaultLogger.DB(name, msg, args...) }
+func Result(ruleName string, result string)             { DefaultLogger.Result(ruleName, result) }
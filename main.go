@@ -2,10 +2,13 @@ package main
 
 import (
 	_ "embed"
+	// Schedules name IANA timezones, which the Alpine image doesn't ship
+	_ "time/tzdata"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 	_ "github.com/microsoft/go-mssqldb"
+	_ "modernc.org/sqlite"
 
 	"github.com/nathanthorell/dataspy/cmd"
 )
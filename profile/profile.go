@@ -0,0 +1,162 @@
+package profile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/db"
+	"github.com/nathanthorell/dataspy/storage"
+)
+
+// Columns are the columns of the result set a profile reports, one row per
+// profiled column
+var Columns = []string{"column_name", "null_ratio", "distinct_count", "min", "max", "top_values"}
+
+// Parse builds a profile from the result of a profile rule's query, under
+// db.ProfileColumns, keeping the columns in the order given
+func Parse(names []string, columns []string, rows [][]string) (storage.Profile, error) {
+	idx := make(map[string]int, len(columns))
+	for i, col := range columns {
+		idx[strings.ToLower(col)] = i
+	}
+	var pos [4]int
+	for i, name := range db.ProfileColumns {
+		p, ok := idx[name]
+		if !ok {
+			return storage.Profile{}, fmt.Errorf("profile result has no %s column", name)
+		}
+		pos[i] = p
+	}
+
+	profiles := make(map[string]*storage.ColumnProfile, len(names))
+	p := storage.Profile{Columns: make([]storage.ColumnProfile, len(names))}
+	for i, name := range names {
+		p.Columns[i].Name = name
+		profiles[name] = &p.Columns[i]
+	}
+
+	for _, row := range rows {
+		name, metric, value, freq := row[pos[0]], row[pos[1]], row[pos[2]], row[pos[3]]
+		col, ok := profiles[name]
+		if !ok {
+			return storage.Profile{}, fmt.Errorf("profile result has unexpected column %q", name)
+		}
+		n, err := strconv.ParseInt(freq, 10, 64)
+		if err != nil {
+			return storage.Profile{}, fmt.Errorf("profile of %s: invalid frequency %q", name, freq)
+		}
+		switch metric {
+		case config.MetricNullCount:
+			p.RowCount = n
+			col.NullCount, err = strconv.ParseInt(value, 10, 64)
+		case config.MetricDistinctCount:
+			col.DistinctCount, err = strconv.ParseInt(value, 10, 64)
+		case config.MetricMin:
+			col.Min = value
+		case config.MetricMax:
+			col.Max = value
+		case db.ProfileTopValue:
+			col.TopValues = append(col.TopValues, storage.ValueCount{Value: value, Count: n})
+		default:
+			return storage.Profile{}, fmt.Errorf("profile of %s: unknown metric %q", name, metric)
+		}
+		if err != nil {
+			return storage.Profile{}, fmt.Errorf("profile of %s: invalid %s %q", name, metric, value)
+		}
+	}
+
+	for i := range p.Columns {
+		col := &p.Columns[i]
+		col.NullRatio = ratio(col.NullCount, p.RowCount)
+		col.DistinctRatio = ratio(col.DistinctCount, p.RowCount-col.NullCount)
+	}
+	return p, nil
+}
+
+func ratio(n, of int64) float64 {
+	if of == 0 {
+		return 0
+	}
+	return float64(n) / float64(of)
+}
+
+// Rows returns the profile as rows under Columns
+func Rows(p storage.Profile) [][]string {
+	rows := make([][]string, len(p.Columns))
+	for i, col := range p.Columns {
+		top := make([]string, len(col.TopValues))
+		for j, v := range col.TopValues {
+			top[j] = fmt.Sprintf("%s (%d)", v.Value, v.Count)
+		}
+		rows[i] = []string{
+			col.Name,
+			strconv.FormatFloat(col.NullRatio, 'f', 4, 64),
+			strconv.FormatInt(col.DistinctCount, 10),
+			col.Min,
+			col.Max,
+			strings.Join(top, ", "),
+		}
+	}
+	return rows
+}
+
+// Metric returns the value of a metric, for a column unless it is row_count
+func Metric(p storage.Profile, metric, column string) (float64, error) {
+	if metric == config.MetricRowCount {
+		return float64(p.RowCount), nil
+	}
+	var col *storage.ColumnProfile
+	for i := range p.Columns {
+		if p.Columns[i].Name == column {
+			col = &p.Columns[i]
+		}
+	}
+	if col == nil {
+		return 0, fmt.Errorf("column %q wasn't profiled", column)
+	}
+
+	switch metric {
+	case config.MetricNullCount:
+		return float64(col.NullCount), nil
+	case config.MetricNullRatio:
+		return col.NullRatio, nil
+	case config.MetricDistinctCount:
+		return float64(col.DistinctCount), nil
+	case config.MetricDistinctRatio:
+		return col.DistinctRatio, nil
+	case config.MetricMin, config.MetricMax:
+		s := col.Min
+		if metric == config.MetricMax {
+			s = col.Max
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s(%s) is %q, not a number", metric, column, s)
+		}
+		return v, nil
+	default:
+		return 0, fmt.Errorf("unknown metric %q", metric)
+	}
+}
+
+// Check evaluates expectations against a profile and describes each that
+// fails, e.g. "null_ratio(email) < 0.01 (actual 0.05)"
+func Check(p storage.Profile, expectations []string) ([]string, error) {
+	var failures []string
+	for _, s := range expectations {
+		e, err := config.ParseExpectation(s)
+		if err != nil {
+			return nil, err
+		}
+		actual, err := Metric(p, e.Metric, e.Column)
+		if err != nil {
+			return nil, fmt.Errorf("expectation %q: %w", s, err)
+		}
+		if !e.Holds(actual) {
+			failures = append(failures, fmt.Sprintf("%s (actual %g)", e, actual))
+		}
+	}
+	return failures, nil
+}
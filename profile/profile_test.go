@@ -0,0 +1,69 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/nathanthorell/dataspy/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var columns = []string{"column_name", "metric", "value", "frequency"}
+
+func TestParse(t *testing.T) {
+	p, err := Parse([]string{"email", "total"}, columns, [][]string{
+		{"total", "null_count", "0", "4"},
+		{"total", "distinct_count", "4", "4"},
+		{"total", "min", "1.5", "4"},
+		{"total", "max", "90", "4"},
+		{"email", "null_count", "1", "4"},
+		{"email", "distinct_count", "2", "4"},
+		{"email", "min", "a@example.com", "4"},
+		{"email", "max", "b@example.com", "4"},
+		{"email", "top_value", "a@example.com", "2"},
+		{"email", "top_value", "b@example.com", "1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), p.RowCount)
+	assert.Equal(t, storage.ColumnProfile{
+		Name:          "email",
+		NullCount:     1,
+		NullRatio:     0.25,
+		DistinctCount: 2,
+		DistinctRatio: 2.0 / 3,
+		Min:           "a@example.com",
+		Max:           "b@example.com",
+		TopValues:     []storage.ValueCount{{Value: "a@example.com", Count: 2}, {Value: "b@example.com", Count: 1}},
+	}, p.Columns[0], "columns keep the configured order")
+
+	assert.Equal(t, []string{"email", "0.2500", "2", "a@example.com", "b@example.com", "a@example.com (2), b@example.com (1)"},
+		Rows(p)[0])
+
+	_, err = Parse([]string{"email"}, columns, [][]string{{"phone", "min", "1", "4"}})
+	assert.ErrorContains(t, err, `unexpected column "phone"`)
+}
+
+func TestCheck(t *testing.T) {
+	p := storage.Profile{
+		RowCount: 200,
+		Columns: []storage.ColumnProfile{
+			{Name: "email", NullCount: 10, NullRatio: 0.05, DistinctCount: 190, Min: "a", Max: "z"},
+			{Name: "total", Min: "-5", Max: "900"},
+		},
+	}
+
+	failures, err := Check(p, []string{
+		"row_count > 0",
+		"null_ratio(email) < 0.01",
+		"distinct_count(email) >= 150",
+		"min(total) >= 0",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"null_ratio(email) < 0.01 (actual 0.05)",
+		"min(total) >= 0 (actual -5)",
+	}, failures)
+
+	_, err = Check(p, []string{"max(email) < 5"})
+	assert.ErrorContains(t, err, `max(email) is "z", not a number`)
+}
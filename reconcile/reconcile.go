@@ -0,0 +1,208 @@
+package reconcile
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/nathanthorell/dataspy/config"
+)
+
+// Kinds of difference between the two sides
+const (
+	Missing  = "missing"  // in the source only
+	Extra    = "extra"    // in the target only
+	Mismatch = "mismatch" // in both, with different values
+)
+
+// Columns are the columns of the result set a reconciliation reports, one
+// row per difference
+var Columns = []string{"key", "difference", "detail"}
+
+// ResultSet is the output of one side's query
+type ResultSet struct {
+	Columns []string
+	Rows    [][]string
+}
+
+// Difference is one way the target doesn't match the source
+type Difference struct {
+	Key    string
+	Kind   string
+	Detail string
+}
+
+// Row returns the difference as a row under Columns
+func (d Difference) Row() []string {
+	return []string{d.Key, d.Kind, d.Detail}
+}
+
+// Mask hides a value of the named column in a difference. The sides are
+// compared as they are, so masking can't hide or invent differences.
+type Mask func(column, value string) string
+
+// Compare reconciles the target result set against the source. With a mask,
+// the values quoted in the differences are masked.
+func Compare(spec config.Reconcile, source, target ResultSet, mask Mask) ([]Difference, error) {
+	if mask == nil {
+		mask = func(_, value string) string { return value }
+	}
+	switch spec.Mode {
+	case config.ReconcileScalar:
+		return compareScalar(spec, source, target, mask)
+	case config.ReconcileRows:
+		return compareRows(spec, source, target, mask)
+	default:
+		return nil, fmt.Errorf("unknown reconciliation mode %q", spec.Mode)
+	}
+}
+
+func compareScalar(spec config.Reconcile, source, target ResultSet, mask Mask) ([]Difference, error) {
+	src, err := scalar("source", source)
+	if err != nil {
+		return nil, err
+	}
+	tgt, err := scalar("target", target)
+	if err != nil {
+		return nil, err
+	}
+	if valuesMatch(spec, src, tgt) {
+		return nil, nil
+	}
+	return []Difference{{
+		Key:    source.Columns[0],
+		Kind:   Mismatch,
+		Detail: fmt.Sprintf("source %s != target %s", mask(source.Columns[0], src), mask(target.Columns[0], tgt)),
+	}}, nil
+}
+
+func scalar(side string, rs ResultSet) (string, error) {
+	if len(rs.Rows) != 1 || len(rs.Rows[0]) != 1 {
+		return "", fmt.Errorf("%s must return exactly one value, got %d rows", side, len(rs.Rows))
+	}
+	return rs.Rows[0][0], nil
+}
+
+// keyedRows indexes a result set by its key columns
+type keyedRows struct {
+	order  []string
+	rows   map[string][]string
+	labels map[string]string
+}
+
+func compareRows(spec config.Reconcile, source, target ResultSet, mask Mask) ([]Difference, error) {
+	srcIdx := columnIndex(source.Columns)
+	tgtIdx := columnIndex(target.Columns)
+
+	// Every column must be on both sides, so a typo or a renamed column
+	// can't silently drop out of the comparison
+	for _, col := range source.Columns {
+		if _, ok := tgtIdx[strings.ToLower(col)]; !ok {
+			return nil, fmt.Errorf("column %q is in the source but not the target", col)
+		}
+	}
+	for _, col := range target.Columns {
+		if _, ok := srcIdx[strings.ToLower(col)]; !ok {
+			return nil, fmt.Errorf("column %q is in the target but not the source", col)
+		}
+	}
+
+	keys := make([]string, len(spec.KeyColumns))
+	isKey := make(map[string]bool)
+	for i, k := range spec.KeyColumns {
+		keys[i] = strings.ToLower(k)
+		if _, ok := srcIdx[keys[i]]; !ok {
+			return nil, fmt.Errorf("key column %q is not in the result sets", k)
+		}
+		isKey[keys[i]] = true
+	}
+
+	src, err := indexRows("source", source.Rows, keys, srcIdx, mask)
+	if err != nil {
+		return nil, err
+	}
+	tgt, err := indexRows("target", target.Rows, keys, tgtIdx, mask)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []Difference
+	for _, key := range src.order {
+		srcRow := src.rows[key]
+		tgtRow, ok := tgt.rows[key]
+		if !ok {
+			diffs = append(diffs, Difference{Key: src.labels[key], Kind: Missing, Detail: "not in target"})
+			continue
+		}
+		var mismatches []string
+		for i, col := range source.Columns {
+			name := strings.ToLower(col)
+			if isKey[name] {
+				continue
+			}
+			a, b := srcRow[i], tgtRow[tgtIdx[name]]
+			if !valuesMatch(spec, a, b) {
+				mismatches = append(mismatches, fmt.Sprintf("%s: %s != %s", col, mask(col, a), mask(col, b)))
+			}
+		}
+		if len(mismatches) > 0 {
+			diffs = append(diffs, Difference{Key: src.labels[key], Kind: Mismatch, Detail: strings.Join(mismatches, "; ")})
+		}
+	}
+	for _, key := range tgt.order {
+		if _, ok := src.rows[key]; !ok {
+			diffs = append(diffs, Difference{Key: tgt.labels[key], Kind: Extra, Detail: "not in source"})
+		}
+	}
+	return diffs, nil
+}
+
+// columnIndex maps lower-cased column names to their positions
+func columnIndex(columns []string) map[string]int {
+	idx := make(map[string]int, len(columns))
+	for i, col := range columns {
+		idx[strings.ToLower(col)] = i
+	}
+	return idx
+}
+
+// indexRows keys rows by their unmasked key values. The masked key each is
+// reported under is kept beside it.
+func indexRows(side string, rows [][]string, keys []string, idx map[string]int, mask Mask) (keyedRows, error) {
+	out := keyedRows{rows: make(map[string][]string, len(rows)), labels: make(map[string]string, len(rows))}
+	for _, row := range rows {
+		parts := make([]string, len(keys))
+		labels := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = k + "=" + row[idx[k]]
+			labels[i] = k + "=" + mask(k, row[idx[k]])
+		}
+		key := strings.Join(parts, ", ")
+		if _, dup := out.rows[key]; dup {
+			return keyedRows{}, fmt.Errorf("duplicate key %s in %s", strings.Join(labels, ", "), side)
+		}
+		out.rows[key] = row
+		out.labels[key] = strings.Join(labels, ", ")
+		out.order = append(out.order, key)
+	}
+	return out, nil
+}
+
+// valuesMatch compares two values, allowing numeric values to differ by the
+// configured tolerances
+func valuesMatch(spec config.Reconcile, a, b string) bool {
+	if a == b {
+		return true
+	}
+	x, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	y, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	if errA != nil || errB != nil {
+		return false
+	}
+	diff := math.Abs(x - y)
+	if diff == 0 || diff <= spec.Tolerance {
+		return true
+	}
+	return spec.TolerancePercent > 0 && x != 0 && diff/math.Abs(x)*100 <= spec.TolerancePercent
+}
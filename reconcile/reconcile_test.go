@@ -0,0 +1,195 @@
+package reconcile
+
+import (
+	"testing"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scalarSet(v string) ResultSet {
+	return ResultSet{Columns: []string{"count"}, Rows: [][]string{{v}}}
+}
+
+func TestCompareScalar(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      config.Reconcile
+		source    ResultSet
+		target    ResultSet
+		expectMsg string
+		expectErr string
+	}{
+		{
+			name:   "equal",
+			spec:   config.Reconcile{Mode: config.ReconcileScalar},
+			source: scalarSet("100"),
+			target: scalarSet("100"),
+		},
+		{
+			name:   "numerically equal",
+			spec:   config.Reconcile{Mode: config.ReconcileScalar},
+			source: scalarSet("100"),
+			target: scalarSet("100.00"),
+		},
+		{
+			name:      "different",
+			spec:      config.Reconcile{Mode: config.ReconcileScalar},
+			source:    scalarSet("100"),
+			target:    scalarSet("98"),
+			expectMsg: "source 100 != target 98",
+		},
+		{
+			name:   "within absolute tolerance",
+			spec:   config.Reconcile{Mode: config.ReconcileScalar, Tolerance: 2},
+			source: scalarSet("100"),
+			target: scalarSet("98"),
+		},
+		{
+			name:   "within percent tolerance",
+			spec:   config.Reconcile{Mode: config.ReconcileScalar, TolerancePercent: 1},
+			source: scalarSet("1000"),
+			target: scalarSet("990.5"),
+		},
+		{
+			name:      "outside percent tolerance",
+			spec:      config.Reconcile{Mode: config.ReconcileScalar, TolerancePercent: 1},
+			source:    scalarSet("1000"),
+			target:    scalarSet("980"),
+			expectMsg: "source 1000 != target 980",
+		},
+		{
+			name:      "text values compared exactly",
+			spec:      config.Reconcile{Mode: config.ReconcileScalar, Tolerance: 5},
+			source:    scalarSet("open"),
+			target:    scalarSet("Open"),
+			expectMsg: "source open != target Open",
+		},
+		{
+			name:      "more than one value",
+			spec:      config.Reconcile{Mode: config.ReconcileScalar},
+			source:    ResultSet{Columns: []string{"a"}, Rows: [][]string{{"1"}, {"2"}}},
+			target:    scalarSet("1"),
+			expectErr: "source must return exactly one value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs, err := Compare(tt.spec, tt.source, tt.target, nil)
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			if tt.expectMsg == "" {
+				assert.Empty(t, diffs)
+				return
+			}
+			require.Len(t, diffs, 1)
+			assert.Equal(t, Mismatch, diffs[0].Kind)
+			assert.Equal(t, tt.expectMsg, diffs[0].Detail)
+		})
+	}
+}
+
+func TestCompareRows(t *testing.T) {
+	spec := config.Reconcile{Mode: config.ReconcileRows, KeyColumns: []string{"id"}, Tolerance: 0.01}
+	source := ResultSet{
+		Columns: []string{"id", "total", "status"},
+		Rows: [][]string{
+			{"1", "10.00", "paid"},
+			{"2", "20.00", "open"},
+			{"3", "30.00", "paid"},
+			{"4", "40.00", "paid"},
+		},
+	}
+	// Column order and case may differ between engines
+	target := ResultSet{
+		Columns: []string{"STATUS", "ID", "TOTAL"},
+		Rows: [][]string{
+			{"paid", "1", "10.001"},
+			{"paid", "2", "25.00"},
+			{"paid", "4", "40"},
+			{"open", "5", "50.00"},
+		},
+	}
+
+	diffs, err := Compare(spec, source, target, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []Difference{
+		{Key: "id=2", Kind: Mismatch, Detail: "total: 20.00 != 25.00; status: open != paid"},
+		{Key: "id=3", Kind: Missing, Detail: "not in target"},
+		{Key: "id=5", Kind: Extra, Detail: "not in source"},
+	}, diffs)
+}
+
+func TestCompareMasked(t *testing.T) {
+	spec := config.Reconcile{Mode: config.ReconcileRows, KeyColumns: []string{"email"}}
+	mask := func(column, value string) string {
+		if column == "email" || column == "total" {
+			return "[REDACTED]"
+		}
+		return value
+	}
+	source := ResultSet{
+		Columns: []string{"email", "total"},
+		Rows:    [][]string{{"ann@example.com", "10"}, {"bob@example.com", "20"}},
+	}
+	target := ResultSet{
+		Columns: []string{"email", "total"},
+		Rows:    [][]string{{"ann@example.com", "12"}, {"bob@example.com", "20"}},
+	}
+
+	// Values that mask the same are still compared as they are
+	diffs, err := Compare(spec, source, target, mask)
+	require.NoError(t, err)
+	assert.Equal(t, []Difference{
+		{Key: "email=[REDACTED]", Kind: Mismatch, Detail: "total: [REDACTED] != [REDACTED]"},
+	}, diffs)
+
+	target.Rows = append(target.Rows, []string{"ann@example.com", "10"})
+	_, err = Compare(spec, source, target, mask)
+	assert.EqualError(t, err, "duplicate key email=[REDACTED] in target")
+}
+
+func TestCompareRowsErrors(t *testing.T) {
+	spec := config.Reconcile{Mode: config.ReconcileRows, KeyColumns: []string{"id", "region"}}
+	base := ResultSet{Columns: []string{"id", "region", "n"}, Rows: [][]string{{"1", "eu", "5"}}}
+
+	tests := []struct {
+		name      string
+		target    ResultSet
+		expectErr string
+	}{
+		{
+			name:      "column only in source",
+			target:    ResultSet{Columns: []string{"id", "region"}},
+			expectErr: `column "n" is in the source but not the target`,
+		},
+		{
+			name:      "column only in target",
+			target:    ResultSet{Columns: []string{"id", "region", "n", "extra"}},
+			expectErr: `column "extra" is in the target but not the source`,
+		},
+		{
+			name: "duplicate key",
+			target: ResultSet{
+				Columns: []string{"id", "region", "n"},
+				Rows:    [][]string{{"1", "eu", "5"}, {"1", "eu", "6"}},
+			},
+			expectErr: "duplicate key id=1, region=eu in target",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compare(spec, base, tt.target, nil)
+			assert.ErrorContains(t, err, tt.expectErr)
+		})
+	}
+
+	_, err := Compare(config.Reconcile{Mode: config.ReconcileRows, KeyColumns: []string{"sku"}}, base, base, nil)
+	assert.ErrorContains(t, err, `key column "sku" is not in the result sets`)
+}
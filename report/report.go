@@ -0,0 +1,379 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nathanthorell/dataspy/runner"
+	"github.com/nathanthorell/dataspy/storage"
+)
+
+type Format string
+
+const (
+	FormatText   Format = "text"
+	FormatJSON   Format = "json"
+	FormatNDJSON Format = "ndjson"
+	FormatCSV    Format = "csv"
+	FormatJUnit  Format = "junit"
+	FormatTAP    Format = "tap"
+)
+
+// Formats lists every supported output format, in the order shown in help text
+var Formats = []Format{FormatText, FormatJSON, FormatNDJSON, FormatCSV, FormatJUnit, FormatTAP}
+
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if strings.EqualFold(s, string(f)) {
+			return f, nil
+		}
+	}
+	names := make([]string, len(Formats))
+	for i, f := range Formats {
+		names[i] = string(f)
+	}
+	return "", fmt.Errorf("unknown output format %q (expected one of: %s)", s, strings.Join(names, ", "))
+}
+
+// Write renders run results in the given format. Text output is produced by
+// the logger while rules run, so FormatText writes nothing here.
+func Write(w io.Writer, format Format, results []runner.RunResult) error {
+	switch format {
+	case FormatText:
+		return nil
+	case FormatJSON:
+		return writeJSON(w, results)
+	case FormatNDJSON:
+		return writeNDJSON(w, results)
+	case FormatCSV:
+		return writeCSV(w, results)
+	case FormatJUnit:
+		return writeJUnit(w, results)
+	case FormatTAP:
+		return writeTAP(w, results)
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+type ruleJSON struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	DbType      string `json:"db_type"`
+	Severity    string `json:"severity,omitempty"`
+}
+
+type resultJSON struct {
+	ExecutionID string                `json:"execution_id"`
+	BatchID     string                `json:"batch_id,omitempty"`
+	Rule        ruleJSON              `json:"rule"`
+	Server      string                `json:"server"`
+	Status      string                `json:"status"`
+	StartTime   time.Time             `json:"start_time"`
+	EndTime     time.Time             `json:"end_time"`
+	Duration    float64               `json:"duration_ms"`
+	RowCount    int64                 `json:"row_count"`
+	Columns     []string              `json:"columns"`
+	Rows        [][]string            `json:"rows"`
+	Metric      *float64              `json:"metric,omitempty"`
+	Expected    *float64              `json:"expected,omitempty"`
+	Anomaly     string                `json:"anomaly,omitempty"`
+	Profile     *storage.Profile      `json:"profile,omitempty"`
+	Phases      []storage.PhaseTiming `json:"phases,omitempty"`
+	Attempts    []storage.Attempt     `json:"attempts,omitempty"`
+	Error       string                `json:"error,omitempty"`
+	SkipReason  string                `json:"skip_reason,omitempty"`
+	MutedBy     string                `json:"muted_by,omitempty"`
+	MuteReason  string                `json:"mute_reason,omitempty"`
+}
+
+func toJSON(r runner.RunResult) resultJSON {
+	out := resultJSON{
+		ExecutionID: r.ExecutionID,
+		BatchID:     r.BatchID,
+		Rule: ruleJSON{
+			Name:        r.Rule.Name,
+			Description: r.Rule.Description,
+			DbType:      r.Rule.DbType,
+			Severity:    r.Rule.Severity,
+		},
+		Server:     r.ServerName,
+		Status:     r.Status,
+		StartTime:  r.StartTime,
+		EndTime:    r.EndTime,
+		Duration:   r.Duration,
+		RowCount:   r.RowsAffected,
+		Columns:    r.Columns,
+		Rows:       r.Rows,
+		Metric:     r.Metric,
+		Expected:   r.Expected,
+		Anomaly:    r.Anomaly,
+		Profile:    r.Profile,
+		Phases:     r.Phases,
+		Attempts:   r.Attempts,
+		Error:      r.Error,
+		SkipReason: r.SkipReason,
+		MutedBy:    r.MutedBy,
+		MuteReason: r.MuteReason,
+	}
+	// Always emit arrays so consumers don't have to special-case null
+	if out.Columns == nil {
+		out.Columns = []string{}
+	}
+	if out.Rows == nil {
+		out.Rows = [][]string{}
+	}
+	return out
+}
+
+func writeJSON(w io.Writer, results []runner.RunResult) error {
+	doc := struct {
+		BatchID string       `json:"batch_id,omitempty"`
+		Results []resultJSON `json:"results"`
+	}{
+		Results: make([]resultJSON, 0, len(results)),
+	}
+	for _, r := range results {
+		if doc.BatchID == "" {
+			doc.BatchID = r.BatchID
+		}
+		doc.Results = append(doc.Results, toJSON(r))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func writeNDJSON(w io.Writer, results []runner.RunResult) error {
+	enc := json.NewEncoder(w)
+	for _, r := range results {
+		if err := enc.Encode(toJSON(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCSV writes one summary line per execution. Result sets differ in shape
+// between rules, so rows are left to the JSON formats.
+func writeCSV(w io.Writer, results []runner.RunResult) error {
+	cw := csv.NewWriter(w)
+	header := []string{"execution_id", "batch_id", "rule", "server", "status", "start_time", "duration_ms", "row_count", "error"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range results {
+		record := []string{
+			r.ExecutionID,
+			r.BatchID,
+			r.Rule.Name,
+			r.ServerName,
+			r.Status,
+			r.StartTime.Format(time.RFC3339),
+			strconv.FormatFloat(r.Duration, 'f', -1, 64),
+			strconv.FormatInt(r.RowsAffected, 10),
+			r.Error,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr,omitempty"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr,omitempty"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+func junitSeconds(ms float64) string {
+	return strconv.FormatFloat(ms/1000, 'f', 3, 64)
+}
+
+// mutedMessage says which maintenance window muted a result, and why
+func mutedMessage(r runner.RunResult) string {
+	msg := "muted by maintenance window " + r.MutedBy
+	if r.MuteReason != "" {
+		msg += ": " + r.MuteReason
+	}
+	return msg
+}
+
+// writeJUnit maps each rule/server pair to a testcase, grouped into one
+// testsuite per server
+func writeJUnit(w io.Writer, results []runner.RunResult) error {
+	doc := junitTestSuites{Name: "dataspy"}
+	suiteIndex := make(map[string]int)
+	var suiteMs []float64
+	var totalMs float64
+
+	for _, r := range results {
+		server := r.ServerName
+		if server == "" {
+			server = "unresolved"
+		}
+
+		idx, ok := suiteIndex[server]
+		if !ok {
+			idx = len(doc.Suites)
+			suiteIndex[server] = idx
+			doc.Suites = append(doc.Suites, junitTestSuite{
+				Name:      server,
+				Timestamp: r.StartTime.Format(time.RFC3339),
+			})
+			suiteMs = append(suiteMs, 0)
+		}
+		suite := &doc.Suites[idx]
+
+		tc := junitTestCase{
+			Name:      r.Rule.Name,
+			Classname: server,
+			Time:      junitSeconds(r.Duration),
+		}
+		muted := r.MutedBy != "" && (r.Status == storage.StatusError || r.Status == storage.StatusViolation)
+		switch {
+		case muted:
+			// Failures inside a mute window are expected, so they don't fail the build
+			tc.Skipped = &junitMessage{Message: mutedMessage(r)}
+			tc.SystemOut = r.Error + r.Result
+			suite.Skipped++
+			doc.Skipped++
+		case r.Status == storage.StatusError:
+			tc.Error = &junitMessage{Message: r.Error, Type: "ExecutionError", Body: r.Error}
+			suite.Errors++
+			doc.Errors++
+		case r.Status == storage.StatusViolation:
+			msg := fmt.Sprintf("%d violating rows (%s)", r.RowsAffected, r.Severity)
+			if r.Profile != nil {
+				msg = fmt.Sprintf("failed expectations: %s (%s)", r.Anomaly, r.Severity)
+			} else if r.Metric != nil {
+				msg = fmt.Sprintf("anomalous metric: %s (%s)", r.Anomaly, r.Severity)
+			} else if r.Anomaly != "" {
+				msg = fmt.Sprintf("%s (%s)", r.Anomaly, r.Severity)
+			}
+			tc.Failure = &junitMessage{Message: msg, Type: r.Severity, Body: r.Result}
+			suite.Failures++
+			doc.Failures++
+		case r.Status == storage.StatusSkipped:
+			tc.Skipped = &junitMessage{Message: r.SkipReason}
+			suite.Skipped++
+			doc.Skipped++
+		default:
+			tc.SystemOut = r.Result
+		}
+
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+		doc.Tests++
+		suiteMs[idx] += r.Duration
+		totalMs += r.Duration
+	}
+
+	for i := range doc.Suites {
+		doc.Suites[i].Time = junitSeconds(suiteMs[i])
+	}
+	doc.Time = junitSeconds(totalMs)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeTAP writes Test Anything Protocol version 13 output with a YAML
+// diagnostic block for each error or violation
+func writeTAP(w io.Writer, results []runner.RunResult) error {
+	var b strings.Builder
+	b.WriteString("TAP version 13\n")
+	fmt.Fprintf(&b, "1..%d\n", len(results))
+
+	for i, r := range results {
+		desc := r.Rule.Name
+		if r.ServerName != "" {
+			desc = fmt.Sprintf("%s on %s", r.Rule.Name, r.ServerName)
+		}
+
+		// A TODO directive keeps failures inside a mute window from counting
+		notOK := fmt.Sprintf("not ok %d - %s\n", i+1, desc)
+		if r.MutedBy != "" {
+			notOK = fmt.Sprintf("not ok %d - %s # TODO %s\n", i+1, desc, mutedMessage(r))
+		}
+
+		switch r.Status {
+		case storage.StatusError:
+			b.WriteString(notOK)
+			b.WriteString("  ---\n")
+			fmt.Fprintf(&b, "  execution_id: %s\n", r.ExecutionID)
+			fmt.Fprintf(&b, "  status: %s\n", r.Status)
+			fmt.Fprintf(&b, "  message: %s\n", strconv.Quote(r.Error))
+			b.WriteString("  ...\n")
+			continue
+		case storage.StatusViolation:
+			b.WriteString(notOK)
+			b.WriteString("  ---\n")
+			fmt.Fprintf(&b, "  execution_id: %s\n", r.ExecutionID)
+			fmt.Fprintf(&b, "  status: %s\n", r.Status)
+			fmt.Fprintf(&b, "  severity: %s\n", r.Severity)
+			fmt.Fprintf(&b, "  rows: %d\n", r.RowsAffected)
+			if r.Anomaly != "" {
+				fmt.Fprintf(&b, "  anomaly: %s\n", strconv.Quote(r.Anomaly))
+			}
+			b.WriteString("  ...\n")
+			continue
+		case storage.StatusSkipped:
+			fmt.Fprintf(&b, "ok %d - %s # SKIP %s\n", i+1, desc, r.SkipReason)
+			continue
+		}
+		fmt.Fprintf(&b, "ok %d - %s\n", i+1, desc)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
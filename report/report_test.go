@@ -0,0 +1,263 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/runner"
+	"github.com/nathanthorell/dataspy/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func testResults() []runner.RunResult {
+	start := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	return []runner.RunResult{
+		{
+			ExecutionRecord: storage.ExecutionRecord{
+				ExecutionID:  "01HS0000000000000000000001",
+				BatchID:      "01HS0000000000000000000000",
+				RuleName:     "version-check",
+				ServerName:   "pg",
+				StartTime:    start,
+				EndTime:      start.Add(250 * time.Millisecond),
+				Status:       "success",
+				Result:       "Found 1 rows:\nRow 1: PostgreSQL 16\n",
+				Duration:     250,
+				RowsAffected: 1,
+			},
+			Rule:    config.Rule{Name: "version-check", DbType: "postgres", Description: "Check version"},
+			Columns: []string{"version"},
+			Rows:    [][]string{{"PostgreSQL 16"}},
+		},
+		{
+			ExecutionRecord: storage.ExecutionRecord{
+				ExecutionID: "01HS0000000000000000000002",
+				BatchID:     "01HS0000000000000000000000",
+				RuleName:    "orders-check",
+				ServerName:  "mysql",
+				StartTime:   start,
+				EndTime:     start.Add(time.Second),
+				Status:      "error",
+				Error:       "failed to ping database",
+				Duration:    1000,
+			},
+			Rule: config.Rule{Name: "orders-check", DbType: "mysql"},
+		},
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("JUnit")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatJUnit, f)
+
+	_, err = ParseFormat("yaml")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown output format")
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, FormatJSON, testResults()))
+
+	var doc struct {
+		BatchID string       `json:"batch_id"`
+		Results []resultJSON `json:"results"`
+	}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, "01HS0000000000000000000000", doc.BatchID)
+	assert.Len(t, doc.Results, 2)
+	assert.Equal(t, "version-check", doc.Results[0].Rule.Name)
+	assert.Equal(t, "postgres", doc.Results[0].Rule.DbType)
+	assert.Equal(t, []string{"version"}, doc.Results[0].Columns)
+	assert.Equal(t, [][]string{{"PostgreSQL 16"}}, doc.Results[0].Rows)
+	assert.Equal(t, "error", doc.Results[1].Status)
+	assert.NotNil(t, doc.Results[1].Rows)
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, FormatNDJSON, testResults()))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	for _, line := range lines {
+		var r resultJSON
+		assert.NoError(t, json.Unmarshal([]byte(line), &r))
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, FormatCSV, testResults()))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "execution_id,batch_id,rule"))
+	assert.Contains(t, lines[2], "failed to ping database")
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, FormatJUnit, testResults()))
+
+	var doc junitTestSuites
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, 2, doc.Tests)
+	assert.Equal(t, 1, doc.Errors)
+	assert.Len(t, doc.Suites, 2)
+	assert.Equal(t, "pg", doc.Suites[0].Name)
+	assert.Equal(t, "version-check", doc.Suites[0].Cases[0].Name)
+	assert.Nil(t, doc.Suites[0].Cases[0].Error)
+	assert.NotNil(t, doc.Suites[1].Cases[0].Error)
+	assert.Equal(t, "1.000", doc.Suites[1].Cases[0].Time)
+}
+
+func TestWriteTAP(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, FormatTAP, testResults()))
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "TAP version 13\n1..2\n"))
+	assert.Contains(t, out, "ok 1 - version-check on pg\n")
+	assert.Contains(t, out, "not ok 2 - orders-check on mysql\n")
+	assert.Contains(t, out, `message: "failed to ping database"`)
+}
+
+func TestWriteAnomaly(t *testing.T) {
+	metric, expected := 55.0, 100.0
+	results := []runner.RunResult{{
+		ExecutionRecord: storage.ExecutionRecord{
+			ExecutionID:  "01HS0000000000000000000003",
+			RuleName:     "daily-orders",
+			ServerName:   "pg",
+			Status:       storage.StatusViolation,
+			Severity:     config.SeverityCritical,
+			RowsAffected: 1,
+			Metric:       &metric,
+			Expected:     &expected,
+			Anomaly:      "55 is 45.0% below 100 (recent runs)",
+		},
+		Rule: config.Rule{Name: "daily-orders", DbType: "postgres", Severity: config.SeverityCritical},
+	}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, FormatJSON, results))
+	assert.Contains(t, buf.String(), `"metric": 55`)
+	assert.Contains(t, buf.String(), `"expected": 100`)
+
+	buf.Reset()
+	assert.NoError(t, Write(&buf, FormatJUnit, results))
+	var doc junitTestSuites
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	if assert.NotNil(t, doc.Suites[0].Cases[0].Failure) {
+		assert.Equal(t, "anomalous metric: 55 is 45.0% below 100 (recent runs) (critical)", doc.Suites[0].Cases[0].Failure.Message)
+	}
+
+	buf.Reset()
+	assert.NoError(t, Write(&buf, FormatTAP, results))
+	assert.Contains(t, buf.String(), `anomaly: "55 is 45.0% below 100 (recent runs)"`)
+}
+
+func TestWriteProfile(t *testing.T) {
+	results := []runner.RunResult{{
+		ExecutionRecord: storage.ExecutionRecord{
+			ExecutionID:  "01HS0000000000000000000004",
+			RuleName:     "customers-profile",
+			ServerName:   "pg",
+			Status:       storage.StatusViolation,
+			Severity:     config.SeverityWarning,
+			RowsAffected: 200,
+			Anomaly:      "null_ratio(email) < 0.01 (actual 0.05)",
+			Profile: &storage.Profile{
+				RowCount: 200,
+				Columns:  []storage.ColumnProfile{{Name: "email", NullCount: 10, NullRatio: 0.05, DistinctCount: 190}},
+			},
+		},
+		Rule: config.Rule{Name: "customers-profile", DbType: "postgres", Severity: config.SeverityWarning},
+	}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, FormatJSON, results))
+	assert.Contains(t, buf.String(), `"null_ratio": 0.05`)
+
+	buf.Reset()
+	assert.NoError(t, Write(&buf, FormatJUnit, results))
+	var doc junitTestSuites
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	if assert.NotNil(t, doc.Suites[0].Cases[0].Failure) {
+		assert.Equal(t, "failed expectations: null_ratio(email) < 0.01 (actual 0.05) (warning)", doc.Suites[0].Cases[0].Failure.Message)
+	}
+}
+
+func TestWriteSkipped(t *testing.T) {
+	results := []runner.RunResult{{
+		ExecutionRecord: storage.ExecutionRecord{
+			ExecutionID: "01HS0000000000000000000005",
+			RuleName:    "daily-revenue",
+			ServerName:  "pg",
+			Status:      storage.StatusSkipped,
+			Severity:    config.SeverityWarning,
+			SkipReason:  "prerequisite etl-finished did not pass (violation)",
+		},
+		Rule: config.Rule{Name: "daily-revenue", DbType: "postgres", DependsOn: []string{"etl-finished"}},
+	}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, FormatJSON, results))
+	assert.Contains(t, buf.String(), `"skip_reason": "prerequisite etl-finished did not pass (violation)"`)
+
+	buf.Reset()
+	assert.NoError(t, Write(&buf, FormatJUnit, results))
+	var doc junitTestSuites
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, 1, doc.Skipped)
+	assert.Equal(t, 0, doc.Failures)
+	if assert.NotNil(t, doc.Suites[0].Cases[0].Skipped) {
+		assert.Equal(t, "prerequisite etl-finished did not pass (violation)", doc.Suites[0].Cases[0].Skipped.Message)
+	}
+
+	buf.Reset()
+	assert.NoError(t, Write(&buf, FormatTAP, results))
+	assert.Contains(t, buf.String(), "ok 1 - daily-revenue on pg # SKIP prerequisite etl-finished did not pass (violation)\n")
+}
+
+func TestWriteMuted(t *testing.T) {
+	results := []runner.RunResult{{
+		ExecutionRecord: storage.ExecutionRecord{
+			ExecutionID:  "01HS0000000000000000000006",
+			RuleName:     "negative-totals",
+			ServerName:   "pg",
+			Status:       storage.StatusViolation,
+			Severity:     config.SeverityCritical,
+			RowsAffected: 2,
+			MutedBy:      "nightly-reload",
+			MuteReason:   "orders reloaded from the warehouse",
+		},
+		Rule: config.Rule{Name: "negative-totals", DbType: "postgres", Severity: config.SeverityCritical},
+	}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, FormatJSON, results))
+	assert.Contains(t, buf.String(), `"muted_by": "nightly-reload"`)
+	assert.Contains(t, buf.String(), `"mute_reason": "orders reloaded from the warehouse"`)
+
+	buf.Reset()
+	assert.NoError(t, Write(&buf, FormatJUnit, results))
+	var doc junitTestSuites
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, 1, doc.Skipped)
+	assert.Equal(t, 0, doc.Failures)
+	if assert.NotNil(t, doc.Suites[0].Cases[0].Skipped) {
+		assert.Equal(t, "muted by maintenance window nightly-reload: orders reloaded from the warehouse", doc.Suites[0].Cases[0].Skipped.Message)
+	}
+
+	buf.Reset()
+	assert.NoError(t, Write(&buf, FormatTAP, results))
+	assert.Contains(t, buf.String(), "not ok 1 - negative-totals on pg # TODO muted by maintenance window nightly-reload: orders reloaded from the warehouse\n")
+}
@@ -0,0 +1,120 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/robfig/cron/v3"
+)
+
+// maxCalendarSearch bounds how many of a cron expression's days are tried
+// before a calendar schedule is taken never to fire again
+const maxCalendarSearch = 5 * 366
+
+// businessCalendar answers which days are business days
+type businessCalendar struct {
+	weekdays map[time.Weekday]bool
+	holidays map[string]bool // dates as YYYY-MM-DD
+}
+
+func newBusinessCalendar(cal config.Calendar) (*businessCalendar, error) {
+	weekdays, err := cal.BusinessWeekdays()
+	if err != nil {
+		return nil, fmt.Errorf("calendar %q: %w", cal.Name, err)
+	}
+	b := &businessCalendar{
+		weekdays: make(map[time.Weekday]bool),
+		holidays: make(map[string]bool),
+	}
+	for _, day := range weekdays {
+		b.weekdays[day] = true
+	}
+	for _, date := range cal.Holidays {
+		b.holidays[date] = true
+	}
+	return b, nil
+}
+
+// isBusinessDay reports whether day's date, in its own location, is a
+// business day
+func (b *businessCalendar) isBusinessDay(day time.Time) bool {
+	return b.weekdays[day.Weekday()] && !b.holidays[day.Format(time.DateOnly)]
+}
+
+// businessDayOfMonth returns the position of day among its month's business
+// days, counting from the start (1 is the first) and from the end (-1 is the
+// last). Both are 0 when day isn't a business day.
+func (b *businessCalendar) businessDayOfMonth(day time.Time) (fromStart, fromEnd int) {
+	if !b.isBusinessDay(day) {
+		return 0, 0
+	}
+	y, m, d := day.Date()
+	days := time.Date(y, m+1, 0, 0, 0, 0, 0, day.Location()).Day()
+	for i := 1; i <= days; i++ {
+		if !b.isBusinessDay(time.Date(y, m, i, 0, 0, 0, 0, day.Location())) {
+			continue
+		}
+		if i <= d {
+			fromStart++
+		}
+		if i >= d {
+			fromEnd--
+		}
+	}
+	return fromStart, fromEnd
+}
+
+// calendarSchedule fires when its cron expression does on one of its
+// calendar's business days, or only the BusinessDay'th of each month
+type calendarSchedule struct {
+	spec        cron.Schedule
+	calendar    *businessCalendar
+	businessDay int
+	loc         *time.Location
+}
+
+func (s calendarSchedule) matches(t time.Time) bool {
+	day := t.In(s.loc)
+	if s.businessDay == 0 {
+		return s.calendar.isBusinessDay(day)
+	}
+	fromStart, fromEnd := s.calendar.businessDayOfMonth(day)
+	return s.businessDay == fromStart || s.businessDay == fromEnd
+}
+
+// Next returns the first time after t the schedule fires, or the zero time
+// when it never does
+func (s calendarSchedule) Next(t time.Time) time.Time {
+	next := s.spec.Next(t)
+	for i := 0; i < maxCalendarSearch && !next.IsZero(); i++ {
+		if s.matches(next) {
+			return next
+		}
+		// Nothing else that day can match, so carry on from midnight
+		y, m, d := next.In(s.loc).Date()
+		next = s.spec.Next(time.Date(y, m, d+1, 0, 0, 0, 0, s.loc).Add(-time.Nanosecond))
+	}
+	return time.Time{}
+}
+
+// ParseSchedule builds the cron schedule a configured schedule runs on,
+// limited to its calendar's business days, and returns the timezone it's
+// read in
+func ParseSchedule(cfg config.Config, schedule config.Schedule) (cron.Schedule, *time.Location, error) {
+	spec, loc, err := cfg.ParseSchedule(schedule)
+	if err != nil || schedule.Calendar == "" {
+		return spec, loc, err
+	}
+	for _, cal := range cfg.Calendars {
+		if cal.Name != schedule.Calendar {
+			continue
+		}
+		calendar, err := newBusinessCalendar(cal)
+		if err != nil {
+			return nil, nil, err
+		}
+		return calendarSchedule{spec: spec, calendar: calendar, businessDay: schedule.BusinessDay, loc: loc}, loc, nil
+	}
+	return nil, nil, fmt.Errorf("calendar not found: %s", schedule.Calendar)
+}
@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/db"
+	"github.com/nathanthorell/dataspy/logger"
+)
+
+// ProbeInterval is how often an unavailable server is pinged to see whether
+// it has recovered
+const ProbeInterval = 30 * time.Second
+
+// reasonServerUnavailable is the skip reason for rules on a server that's down
+const reasonServerUnavailable = "server unavailable"
+
+// serverHealth tracks the servers that couldn't be reached. Their rules are
+// skipped until a background probe reaches them again.
+type serverHealth struct {
+	mu   sync.Mutex
+	down map[string]time.Time // server name -> when it became unavailable
+}
+
+func newServerHealth() *serverHealth {
+	return &serverHealth{down: make(map[string]time.Time)}
+}
+
+func (h *serverHealth) isDown(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.down[name]
+	return ok
+}
+
+// markDown records the server as unavailable, reporting false if it already was
+func (h *serverHealth) markDown(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.down[name]; ok {
+		return false
+	}
+	h.down[name] = time.Now()
+	return true
+}
+
+// markUp records the server as available, returning how long it was down
+func (h *serverHealth) markUp(name string) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	since := h.down[name]
+	delete(h.down, name)
+	return time.Since(since)
+}
+
+// unavailableServer returns the first of the servers that's down
+func (s *Scheduler) unavailableServer(servers ...config.DbServer) (config.DbServer, bool) {
+	for _, server := range servers {
+		if s.health.isDown(server.Name) {
+			return server, true
+		}
+	}
+	return config.DbServer{}, false
+}
+
+// checkAvailable marks the server as down when err means it couldn't be
+// reached. Only the first failure is logged, and it starts a probe that
+// marks the server up again once it answers.
+func (s *Scheduler) checkAvailable(server config.DbServer, err error) {
+	if !errors.Is(err, db.ErrUnavailable) || !s.health.markDown(server.Name) {
+		return
+	}
+	logger.Error(err, fmt.Sprintf("Server %s is unavailable; skipping its rules until it recovers", server.Name),
+		"server", server.Name)
+	select {
+	case <-s.done:
+		// Stopped, so nothing will run on it again
+		return
+	default:
+	}
+	s.probes.Add(1)
+	go func() {
+		defer s.probes.Done()
+		s.probeUntilAvailable(server)
+	}()
+}
+
+// probeUntilAvailable pings the server every probeInterval until it answers
+// or the scheduler is stopped
+func (s *Scheduler) probeUntilAvailable(server config.DbServer) {
+	ticker := time.NewTicker(s.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		// Any other failure means the server answered, and is left to the
+		// rules to report
+		version, err := s.probe(server)
+		if errors.Is(err, db.ErrUnavailable) {
+			continue
+		}
+		downFor := s.health.markUp(server.Name)
+		logger.Success(fmt.Sprintf("Server %s is available again after %s", server.Name, downFor.Round(time.Second)),
+			"server", server.Name, "version", version)
+		return
+	}
+}
@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/logger"
+)
+
+// maintenanceWindow returns the open window, configured or opened ad hoc,
+// that covers a rule on any of servers at now. Skip windows take precedence
+// over mute windows, so a rule is never run while a window says not to.
+func (s *Scheduler) maintenanceWindow(rule config.Rule, servers []config.DbServer, now time.Time) (config.MaintenanceWindow, bool) {
+	names := make([]string, len(servers))
+	for i, server := range servers {
+		names[i] = server.Name
+	}
+
+	windows := append([]config.MaintenanceWindow(nil), s.config.Maintenance...)
+	stored, err := s.store.Maintenance().List()
+	if err != nil {
+		logger.Error(err, "failed to load maintenance windows")
+	}
+	for _, w := range stored {
+		windows = append(windows, config.MaintenanceWindow{
+			Name:    w.Name,
+			Start:   w.Start.Format(time.RFC3339),
+			End:     w.End.Format(time.RFC3339),
+			Mode:    w.Mode,
+			Servers: w.Servers,
+			Rules:   w.Rules,
+			Reason:  w.Reason,
+		})
+	}
+
+	var muted *config.MaintenanceWindow
+	for i, w := range windows {
+		loc, err := s.config.MaintenanceLocation(w)
+		if err != nil {
+			logger.Error(err, "failed to load maintenance window timezone", "window", w.Name)
+			continue
+		}
+		if !w.Active(now, loc) || !w.Applies(rule.Name, names...) {
+			continue
+		}
+		if w.Mode != config.MaintenanceMute {
+			return w, true
+		}
+		if muted == nil {
+			muted = &windows[i]
+		}
+	}
+	if muted != nil {
+		return *muted, true
+	}
+	return config.MaintenanceWindow{}, false
+}
+
+// inMaintenance checks the rule's maintenance windows. A skip window records
+// the rule as skipped and returns its result with true; a mute window marks
+// exec so the rule runs but its failures aren't reported.
+func (s *Scheduler) inMaintenance(exec *execution, rule config.Rule, label string, servers []config.DbServer) (RunResult, bool) {
+	w, ok := s.maintenanceWindow(rule, servers, exec.StartTime)
+	if !ok {
+		return RunResult{}, false
+	}
+	if w.Mode == config.MaintenanceMute {
+		exec.MutedBy = w.Name
+		exec.MuteReason = w.Reason
+		return RunResult{}, false
+	}
+	reason := fmt.Sprintf("maintenance window %s", w.Name)
+	if w.Reason != "" {
+		reason += ": " + w.Reason
+	}
+	return s.skipRule(*exec, rule, label, reason), true
+}
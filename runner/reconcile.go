@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/db"
+	"github.com/nathanthorell/dataspy/reconcile"
+)
+
+// executeReconciliation runs both sides of a reconciliation rule and reports
+// each difference between them as a violating row
+func (s *Scheduler) executeReconciliation(exec execution, rule config.Rule, schedule config.Schedule) (RunResult, error) {
+	spec := rule.Reconcile
+	label := fmt.Sprintf("%s vs %s", spec.Source.Server, spec.Target.Server)
+
+	sides := []config.ReconcileSide{spec.Source, spec.Target}
+	var servers []config.DbServer
+	for _, side := range sides {
+		server, err := s.findServerByName(side.Server)
+		if err != nil {
+			return s.finishExecution(exec, rule, label, nil, db.ExecutionResult{}, err)
+		}
+		servers = append(servers, server)
+	}
+	if result, skipped := s.inMaintenance(&exec, rule, label, servers); skipped {
+		return result, nil
+	}
+	if down, ok := s.unavailableServer(servers...); ok {
+		return s.skipRule(exec, rule, label, fmt.Sprintf("%s: %s", reasonServerUnavailable, down.Name)), nil
+	}
+
+	mask, err := db.ValueMasker(s.config.EffectiveMasking(rule))
+	if err != nil {
+		return s.finishExecution(exec, rule, label, nil, db.ExecutionResult{},
+			fmt.Errorf("invalid masking configuration for rule %s: %w", rule.Name, err))
+	}
+
+	var sets []reconcile.ResultSet
+	for i, side := range sides {
+		server := servers[i]
+		result, err := s.runQuery(exec, rule, side.Query, server, schedule)
+		if err != nil {
+			return s.finishExecution(exec, rule, label, nil, db.ExecutionResult{},
+				fmt.Errorf("%s: %w", server.Name, err))
+		}
+		sets = append(sets, reconcile.ResultSet{Columns: result.Columns, Rows: result.Rows})
+	}
+
+	diffs, err := reconcile.Compare(*spec, sets[0], sets[1], mask)
+	if err != nil {
+		return s.finishExecution(exec, rule, label, nil, db.ExecutionResult{}, err)
+	}
+
+	result := db.ExecutionResult{
+		ExecutionID: exec.ID,
+		RowCount:    int64(len(diffs)),
+		Columns:     reconcile.Columns,
+		Rows:        make([][]string, 0, len(diffs)),
+	}
+	for _, d := range diffs {
+		result.Rows = append(result.Rows, d.Row())
+	}
+	if len(diffs) > 0 {
+		var b strings.Builder
+		fmt.Fprintf(&b, "Found %d differences:\n", len(diffs))
+		for i, d := range diffs {
+			fmt.Fprintf(&b, "Row %d: %s %s: %s\n", i+1, d.Key, d.Kind, d.Detail)
+		}
+		result.Results = b.String()
+	} else {
+		result.Results = fmt.Sprintf("%s and %s match", spec.Source.Server, spec.Target.Server)
+	}
+
+	return s.finishExecution(exec, rule, label, servers, result, nil)
+}
+
+func (s *Scheduler) findServerByName(name string) (config.DbServer, error) {
+	for _, srv := range s.config.DBServers {
+		if srv.Name == name {
+			return srv, nil
+		}
+	}
+	return config.DbServer{}, fmt.Errorf("server not found: %s", name)
+}
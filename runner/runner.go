@@ -1,12 +1,20 @@
 package runner
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/nathanthorell/dataspy/baseline"
 	"github.com/nathanthorell/dataspy/config"
 	"github.com/nathanthorell/dataspy/db"
+	"github.com/nathanthorell/dataspy/expression"
 	"github.com/nathanthorell/dataspy/logger"
+	"github.com/nathanthorell/dataspy/profile"
 	"github.com/nathanthorell/dataspy/storage"
 	"github.com/robfig/cron/v3"
 )
@@ -15,23 +23,67 @@ type Scheduler struct {
 	config    config.Config
 	scheduler *cron.Cron
 	store     *storage.Store
+	health    *serverHealth
+	// probe checks whether an unavailable server is back, every probeInterval
+	probe         func(config.DbServer) (string, error)
+	probeInterval time.Duration
+	// expressions are the rules' compiled Expressions, by source
+	expressions map[string]*expression.Program
+	// queueMu keeps concurrent runs from applying the same queued change
+	queueMu sync.Mutex
+
+	// done is closed by Stop to end the probes, which are tracked by probes
+	done     chan struct{}
+	stopOnce sync.Once
+	probes   sync.WaitGroup
 }
 
 func NewScheduler(config config.Config, store *storage.Store) *Scheduler {
 	return &Scheduler{
-		config:    config,
-		scheduler: cron.New(cron.WithSeconds()),
-		store:     store,
+		config:        config,
+		scheduler:     cron.New(cron.WithSeconds()),
+		store:         store,
+		health:        newServerHealth(),
+		probe:         db.PingServer,
+		probeInterval: ProbeInterval,
+		expressions:   compileExpressions(config.Rules),
+		done:          make(chan struct{}),
 	}
 }
 
+// Stop stops scheduling rules and ends any server probes, waiting for the
+// rules and probes that are running to finish
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+	<-s.scheduler.Stop().Done()
+	s.probes.Wait()
+}
+
+// compileExpressions compiles each rule's Expression once, so runs only
+// evaluate it. Invalid ones are left out; validation has reported them.
+func compileExpressions(rules []config.Rule) map[string]*expression.Program {
+	programs := make(map[string]*expression.Program)
+	for _, rule := range rules {
+		if rule.Expression == "" {
+			continue
+		}
+		if program, err := expression.Compile(rule.Expression); err == nil {
+			programs[rule.Expression] = program
+		}
+	}
+	return programs
+}
+
 func (s *Scheduler) Start() error {
+	// Next run times are shown in each schedule's own timezone
+	locations := make(map[cron.EntryID]*time.Location)
 	for _, schedule := range s.config.Schedules {
 		logger.Info(fmt.Sprintf("Adding task [%s] on schedule [%s] for DB Server [%s]", schedule.Rule, schedule.CronStr, schedule.Server))
-		err := s.addTask(schedule)
+		entryID, loc, err := s.addTask(schedule)
 		if err != nil {
 			return fmt.Errorf("error adding task: %v", err)
 		}
+		locations[entryID] = loc
 	}
 
 	logger.Info("Starting Scheduler...")
@@ -41,76 +93,519 @@ func (s *Scheduler) Start() error {
 	entries := s.scheduler.Entries()
 	logger.Info(fmt.Sprintf("Number of scheduled tasks: %d", len(entries)))
 	for _, entry := range entries {
-		logger.Info(fmt.Sprintf("Next run for task: %s", entry.Next))
+		loc := locations[entry.ID]
+		logger.Info(fmt.Sprintf("Next run for task: %s", entry.Next.In(loc).Format(time.RFC3339)), "timezone", loc.String())
 	}
 	return nil
 }
 
-func (s *Scheduler) addTask(schedule config.Schedule) error {
+// addTask schedules a rule, returning the entry and the timezone its
+// schedule is read in
+func (s *Scheduler) addTask(schedule config.Schedule) (cron.EntryID, *time.Location, error) {
 	logger.Task(schedule.Rule, "Adding scheduled task")
-	entryID, err := s.scheduler.AddFunc(schedule.CronStr, func() {
-		logger.Info(fmt.Sprintf("Triggering scheduled task at %s\n", time.Now().Format(time.RFC3339)))
-		s.runTask(schedule.Rule)
-	})
+	spec, loc, err := ParseSchedule(s.config, schedule)
 	if err != nil {
-		return fmt.Errorf("error scheduling task: %w", err)
+		return 0, nil, fmt.Errorf("error scheduling task: %w", err)
 	}
+	entryID := s.scheduler.Schedule(spec, cron.FuncJob(func() {
+		logger.Info(fmt.Sprintf("Triggering scheduled task at %s\n", time.Now().In(loc).Format(time.RFC3339)))
+		s.runTask(schedule)
+	}))
 	logger.Success(fmt.Sprintf("Successfully scheduled task with ID: %d\n", entryID))
-	return nil
+	return entryID, loc, nil
 }
 
-func (s *Scheduler) runTask(scheduleName string) {
-	if err := s.ExecuteRuleByName(scheduleName); err != nil {
-		logger.Error(err, fmt.Sprintf("error executing scheduled task %s", scheduleName))
+func (s *Scheduler) runTask(schedule config.Schedule) {
+	chain, err := s.config.DependencyChain(schedule.Rule)
+	if err != nil || len(chain) == 1 {
+		if _, err := s.executeRule(schedule.Rule, "", schedule); err != nil {
+			logger.Error(err, fmt.Sprintf("error executing scheduled task %s", schedule.Rule))
+		}
+		return
 	}
+
+	// The rule's prerequisites run first, as one batch with it
+	batchID := storage.NewID()
+	logger.Info(fmt.Sprintf("Running %s after %d prerequisites", schedule.Rule, len(chain)-1), "batch_id", batchID)
+	statuses := make(map[string]string)
+	for _, rule := range chain {
+		result := s.executeAfter(rule, batchID, s.prerequisiteSchedule(rule, schedule), statuses)
+		if result.Status == storage.StatusError && result.MutedBy == "" {
+			logger.Error(errors.New(result.Error), fmt.Sprintf("error executing scheduled task %s", rule.Name))
+		}
+	}
+}
+
+// prerequisiteSchedule returns the schedule a rule in a scheduled chain runs
+// with: the chain's own for the scheduled rule, and for its prerequisites the
+// same server when they can run there, or else the first of their type
+func (s *Scheduler) prerequisiteSchedule(rule config.Rule, schedule config.Schedule) config.Schedule {
+	if rule.Name == schedule.Rule {
+		return schedule
+	}
+	prereq := config.Schedule{Rule: rule.Name}
+	if srv, err := s.findServerByName(schedule.Server); err == nil && rule.Reconcile == nil && srv.Type == rule.DbType {
+		prereq.Server = srv.Name
+	}
+	return prereq
+}
+
+// executeAfter runs a rule unless a rule it depends on didn't pass earlier in
+// the batch, in which case it's recorded as skipped. Either way its status
+// is added to statuses for the rules after it.
+func (s *Scheduler) executeAfter(
+	rule config.Rule,
+	batchID string,
+	schedule config.Schedule,
+	statuses map[string]string,
+) RunResult {
+	var result RunResult
+	for _, dep := range rule.DependsOn {
+		if status := statuses[dep]; status != storage.StatusSuccess {
+			result = s.skipRule(newExecution(batchID), rule, s.serverLabel(rule, schedule),
+				fmt.Sprintf("prerequisite %s did not pass (%s)", dep, status))
+			break
+		}
+	}
+	if result.Status == "" {
+		// Failures are captured in the result
+		result, _ = s.executeRule(rule.Name, batchID, schedule)
+	}
+	statuses[rule.Name] = result.Status
+	return result
+}
+
+// serverLabel names where a rule would run: its server, or both servers of
+// a reconciliation. It's empty when no server matches.
+func (s *Scheduler) serverLabel(rule config.Rule, schedule config.Schedule) string {
+	if rule.Reconcile != nil {
+		return fmt.Sprintf("%s vs %s", rule.Reconcile.Source.Server, rule.Reconcile.Target.Server)
+	}
+	server, err := s.selectServer(rule, schedule)
+	if err != nil {
+		return ""
+	}
+	return server.Name
+}
+
+// skipRule records that a rule didn't run, and why
+func (s *Scheduler) skipRule(exec execution, rule config.Rule, serverName string, reason string) RunResult {
+	record := storage.ExecutionRecord{
+		ExecutionID: exec.ID,
+		BatchID:     exec.BatchID,
+		RuleName:    rule.Name,
+		ServerName:  serverName,
+		StartTime:   exec.StartTime,
+		EndTime:     exec.StartTime,
+		Status:      storage.StatusSkipped,
+		Severity:    rule.Severity,
+		SkipReason:  reason,
+		Description: rule.Description,
+	}
+	if err := s.store.SaveExecutionRecord(&record); err != nil {
+		logger.Error(err, "failed to save execution record", "execution_id", exec.ID)
+	}
+	logger.Warn(fmt.Sprintf("Rule %s skipped: %s", rule.Name, reason), "execution_id", exec.ID)
+	return RunResult{ExecutionRecord: record, Rule: rule}
+}
+
+// execution identifies a single run of a rule and, optionally, the batch
+// of runs it was triggered with
+type execution struct {
+	ID        string
+	BatchID   string
+	StartTime time.Time
+	// MutedBy is the maintenance window the rule runs in, if it's muted,
+	// and MuteReason that window's Reason
+	MutedBy    string
+	MuteReason string
+}
+
+func newExecution(batchID string) execution {
+	return execution{
+		ID:        storage.NewID(),
+		BatchID:   batchID,
+		StartTime: time.Now(),
+	}
+}
+
+// RunResult is the outcome of a single rule execution: the stored record
+// plus the rule metadata and result set for callers that report on it
+type RunResult struct {
+	storage.ExecutionRecord
+	Rule    config.Rule
+	Columns []string
+	Rows    [][]string
+}
+
+// ServerUnavailable reports whether the rule was skipped because a server it
+// runs on was down, which unlike other skips means it couldn't be checked
+func (r RunResult) ServerUnavailable() bool {
+	return r.Status == storage.StatusSkipped && strings.HasPrefix(r.SkipReason, reasonServerUnavailable)
 }
 
 // ExecuteRuleByName executes a rule by name and records the result
-func (s *Scheduler) ExecuteRuleByName(ruleName string) error {
-	startTime := time.Now()
+func (s *Scheduler) ExecuteRuleByName(ruleName string) (RunResult, error) {
+	return s.executeRule(ruleName, "", config.Schedule{})
+}
+
+// executeRule runs a rule on the schedule's server, or the first server of
+// the rule's type for ad-hoc runs (a zero schedule)
+func (s *Scheduler) executeRule(ruleName string, batchID string, schedule config.Schedule) (RunResult, error) {
+	s.applyQueued()
+	exec := newExecution(batchID)
 
 	rule, err := s.findRule(ruleName)
 	if err != nil {
-		s.recordExecution(config.Rule{Name: ruleName}, config.DbServer{}, startTime, db.ExecutionResult{}, err)
-		logger.Error(err, "error finding rule")
-		return err
+		record := s.recordExecution(exec, config.Rule{Name: ruleName}, config.DbServer{}, db.ExecutionResult{}, err)
+		logger.Error(err, "error finding rule", "execution_id", exec.ID)
+		return RunResult{ExecutionRecord: record, Rule: config.Rule{Name: ruleName}}, err
 	}
 
-	server, err := s.findServer(rule.DbType)
+	if rule.Reconcile != nil {
+		return s.executeReconciliation(exec, rule, schedule)
+	}
+
+	server, err := s.selectServer(rule, schedule)
 	if err != nil {
-		s.recordExecution(rule, config.DbServer{}, startTime, db.ExecutionResult{}, err)
-		logger.Error(err, "error finding server")
-		return err
+		record := s.recordExecution(exec, rule, config.DbServer{}, db.ExecutionResult{}, err)
+		logger.Error(err, "error finding server", "execution_id", exec.ID)
+		return RunResult{ExecutionRecord: record, Rule: rule}, err
+	}
+	if result, skipped := s.inMaintenance(&exec, rule, server.Name, []config.DbServer{server}); skipped {
+		return result, nil
+	}
+	if _, down := s.unavailableServer(server); down {
+		return s.skipRule(exec, rule, server.Name, reasonServerUnavailable), nil
+	}
+
+	result, err := s.runQuery(exec, rule, rule.Query, server, schedule)
+	if err == nil && rule.Kind == config.CheckSchema {
+		result, err = s.evaluateSchema(exec, rule, server, result)
+	}
+	return s.finishExecution(exec, rule, server.Name, []config.DbServer{server}, result, err)
+}
+
+// applyQueued applies the changes other processes queued while this one held
+// the store, such as watermarks set while the daemon runs
+func (s *Scheduler) applyQueued() {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	applied, err := s.store.ApplyQueued()
+	for _, change := range applied {
+		logger.Info(fmt.Sprintf("Applied queued change: %s", change))
+	}
+	if err != nil {
+		logger.Error(err, "failed to apply queued changes")
+	}
+}
+
+// runQuery runs one of a rule's queries on a server with the rule's masking
+// and the parameters that apply there
+func (s *Scheduler) runQuery(
+	exec execution,
+	rule config.Rule,
+	query string,
+	server config.DbServer,
+	schedule config.Schedule,
+) (db.ExecutionResult, error) {
+	execRule := rule
+	execRule.DbType = server.Type
+	execRule.Query = query
+	execRule.Masking = s.config.EffectiveMasking(rule)
+	if rule.Kind == config.CheckSchema || rule.Reconcile != nil {
+		// Schema snapshots hold only names and types, which masking would
+		// turn into spurious drift. Reconciliations compare the values as
+		// they are and mask only the differences they report.
+		execRule.Masking = nil
+	}
+	execRule.Params = s.config.EffectiveParams(rule, server, schedule)
+	retry := s.config.EffectiveRetry(rule, server)
+	execRule.Retry = &retry
+	if err := s.applyWatermark(execRule.Params, rule, server); err != nil {
+		return db.ExecutionResult{}, fmt.Errorf("failed to load watermark: %w", err)
 	}
 
-	result, err := db.ExecuteRule(server, rule)
+	result, err := db.ExecuteRule(exec.ID, server, execRule)
 	s.processLogEvents(result.LogEvents)
-	s.recordExecution(rule, server, startTime, result, err)
+	s.checkAvailable(server, err)
+	return result, err
+}
+
+// finishExecution records an execution's outcome, advances the rule's
+// watermarks on the servers it ran on and logs the result
+func (s *Scheduler) finishExecution(
+	exec execution,
+	rule config.Rule,
+	serverName string,
+	servers []config.DbServer,
+	result db.ExecutionResult,
+	err error,
+) (RunResult, error) {
+	record := s.recordExecution(exec, rule, config.DbServer{Name: serverName}, result, err)
+	if err == nil && record.Status == storage.StatusError {
+		// The query ran but its result couldn't be evaluated
+		err = errors.New(record.Error)
+	}
+	runResult := RunResult{
+		ExecutionRecord: record,
+		Rule:            rule,
+		Columns:         result.Columns,
+		Rows:            result.Rows,
+	}
+	if record.Profile != nil {
+		runResult.Columns = profile.Columns
+		runResult.Rows = profile.Rows(*record.Profile)
+	}
 
+	if err != nil && exec.MutedBy != "" {
+		logger.Info(fmt.Sprintf("Rule %s failed during maintenance window %s: %v", rule.Name, exec.MutedBy, err),
+			"reason", exec.MuteReason, "execution_id", exec.ID)
+		return runResult, nil
+	}
 	if err != nil {
-		logger.Error(err, fmt.Sprintf("error executing rule %s", rule.Name))
-		return err
+		logger.Error(err, fmt.Sprintf("error executing rule %s", rule.Name), "execution_id", exec.ID)
+		return runResult, err
+	}
+
+	// Rows changed while the query ran may not have been seen, so the next
+	// run picks up from when this one started. A violation leaves it alone,
+	// so the violating rows are checked again.
+	for _, server := range servers {
+		if runResult.Status != storage.StatusSuccess || !usesWatermark(rule, server) {
+			continue
+		}
+		if err := s.store.AdvanceWatermark(rule.Name, server.Name, exec.StartTime, exec.ID); err != nil {
+			logger.Error(err, "failed to advance watermark", "execution_id", exec.ID)
+		}
+	}
+
+	if runResult.Status == storage.StatusViolation {
+		msg := fmt.Sprintf("Rule %s found %d violating rows", rule.Name, result.RowCount)
+		if record.Profile != nil {
+			msg = fmt.Sprintf("Rule %s profile failed expectations: %s", rule.Name, record.Anomaly)
+		} else if rule.Expression != "" {
+			msg = fmt.Sprintf("Rule %s %s", rule.Name, record.Anomaly)
+		} else if record.Anomaly != "" {
+			msg = fmt.Sprintf("Rule %s metric is anomalous: %s", rule.Name, record.Anomaly)
+		}
+		if exec.MutedBy != "" {
+			logger.Info(fmt.Sprintf("%s (muted by maintenance window %s)", msg, exec.MutedBy),
+				"reason", exec.MuteReason, "execution_id", exec.ID)
+		} else {
+			logger.Warn(msg, "severity", rule.Severity, "execution_id", exec.ID)
+		}
+	}
+
+	logger.Result(rule.Name, record.Result)
+	return runResult, nil
+}
+
+// usesWatermark reports whether any of a rule's SQL on the server refers to
+// its watermark, so only rules that read one keep one
+func usesWatermark(rule config.Rule, server config.DbServer) bool {
+	dialect, err := db.LookupDialect(server.Type)
+	if err != nil {
+		return false
+	}
+	statements := append([]string{rule.Query, rule.Where}, rule.Setup...)
+	statements = append(statements, rule.Teardown...)
+	if rule.Reconcile != nil {
+		statements = append(statements, rule.Reconcile.Source.Query, rule.Reconcile.Target.Query)
 	}
+	for _, stmt := range statements {
+		for _, name := range db.ParamNames(dialect, stmt) {
+			if name == config.ParamWatermark || name == config.ParamLastSuccessAt {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	logger.Result(rule.Name, result.Results)
+// applyWatermark binds the rule's stored watermark on the server, leaving the
+// configured defaults in place before its first successful run
+func (s *Scheduler) applyWatermark(params config.Params, rule config.Rule, server config.DbServer) error {
+	wm, found, err := s.store.GetWatermark(rule.Name, server.Name)
+	if err != nil {
+		return err
+	}
+	if found {
+		params[config.ParamWatermark] = wm.Value
+		params[config.ParamLastSuccessAt] = wm.Value
+	}
 	return nil
 }
 
-// ExecuteAllRules executes all configured rules
-func (s *Scheduler) ExecuteAllRules() (successCount int, errorCount int) {
-	logger.Info(fmt.Sprintf("Running all %d rules", len(s.config.Rules)))
+// isViolation reports whether a successful execution breaks the rule. Only
+// rules with a severity are checks; any row they return is a violation.
+// Baseline rules return a metric instead and are judged by evaluateBaseline,
+// profiles return statistics judged by evaluateProfile, and rules with an
+// Expression are judged by evaluateExpression.
+func isViolation(rule config.Rule, result db.ExecutionResult) bool {
+	return rule.IsCheck() && rule.Baseline == nil && rule.Kind != config.CheckProfile &&
+		rule.Expression == "" && result.RowCount > 0
+}
+
+// evaluateExpression marks the record as a violation when the rule's
+// expression doesn't hold for the result
+func (s *Scheduler) evaluateExpression(record *storage.ExecutionRecord, rule config.Rule, result db.ExecutionResult) {
+	ok, err := s.evalExpression(rule.Expression, result)
+	if err != nil {
+		record.Status = storage.StatusError
+		record.Error = err.Error()
+		record.Result = ""
+		return
+	}
+	if !ok {
+		record.Status = storage.StatusViolation
+		record.Anomaly = "expression is false: " + rule.Expression
+	}
+}
+
+// evalExpression evaluates an expression compiled by NewScheduler, compiling
+// it only if it wasn't
+func (s *Scheduler) evalExpression(source string, result db.ExecutionResult) (bool, error) {
+	program, ok := s.expressions[source]
+	if !ok {
+		var err error
+		if program, err = expression.Compile(source); err != nil {
+			return false, err
+		}
+	}
+	return program.Eval(result.Columns, result.Rows)
+}
+
+// evaluateBaseline compares a baseline rule's metric with its history on the
+// server, marking the record as a violation when the metric is anomalous
+func (s *Scheduler) evaluateBaseline(record *storage.ExecutionRecord, rule config.Rule, result db.ExecutionResult) {
+	fail := func(err error) {
+		record.Status = storage.StatusError
+		record.Error = err.Error()
+		record.Result = ""
+	}
+
+	metric, err := metricValue(result)
+	if err != nil {
+		fail(err)
+		return
+	}
+	history, err := s.metricHistory(rule, record.ServerName, record.StartTime)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	res := baseline.Evaluate(*rule.Baseline, metric, record.StartTime, history)
+	record.Metric = &metric
+	if res.Ready {
+		record.Expected = &res.Expected
+	}
+	if res.Anomaly {
+		record.Status = storage.StatusViolation
+		record.Anomaly = res.String()
+	}
+}
+
+// evaluateProfile stores a profile rule's column statistics on the record
+// and checks them against the rule's expectations
+func evaluateProfile(record *storage.ExecutionRecord, rule config.Rule, result db.ExecutionResult) {
+	fail := func(err error) {
+		record.Status = storage.StatusError
+		record.Error = err.Error()
+		record.Result = ""
+	}
+
+	p, err := profile.Parse(rule.Columns, result.Columns, result.Rows)
+	if err != nil {
+		fail(err)
+		return
+	}
+	record.Profile = &p
+	record.RowsAffected = p.RowCount
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Profiled %d columns over %d rows:\n", len(p.Columns), p.RowCount)
+	for _, row := range profile.Rows(p) {
+		fmt.Fprintf(&b, "%s: null_ratio=%s distinct=%s min=%s max=%s top=[%s]\n",
+			row[0], row[1], row[2], row[3], row[4], row[5])
+	}
+	record.Result = b.String()
+
+	failures, err := profile.Check(p, rule.Expect)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if len(failures) > 0 {
+		record.Status = storage.StatusViolation
+		record.Anomaly = strings.Join(failures, "; ")
+	}
+}
+
+// metricValue reads a baseline rule's metric: the single value it returned
+func metricValue(result db.ExecutionResult) (float64, error) {
+	if len(result.Rows) != 1 || len(result.Rows[0]) != 1 {
+		return 0, fmt.Errorf("baseline rule must return exactly one value, got %d rows", len(result.Rows))
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(result.Rows[0][0]), 64)
+	if err != nil {
+		return 0, fmt.Errorf("baseline rule must return a number, got %q", result.Rows[0][0])
+	}
+	return v, nil
+}
+
+// metricHistory returns the metrics of a rule's earlier successful runs on a
+// server that its baseline looks at, oldest first. Anomalous runs are left
+// out so they don't shift the baseline toward themselves.
+func (s *Scheduler) metricHistory(rule config.Rule, serverName string, now time.Time) ([]baseline.Sample, error) {
+	since, n := baseline.History(*rule.Baseline, now)
+	records, err := s.store.GetMetricHistory(rule.Name, serverName, since, n)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]baseline.Sample, 0, len(records))
+	for _, r := range records {
+		samples = append(samples, baseline.Sample{Time: r.StartTime, Value: *r.Metric})
+	}
+	return samples, nil
+}
 
-	for _, rule := range s.config.Rules {
-		if err := s.ExecuteRuleByName(rule.Name); err != nil {
+// ExecuteAllRules executes all configured rules as a single batch, each after
+// the rules it depends on
+func (s *Scheduler) ExecuteAllRules() []RunResult {
+	batchID := storage.NewID()
+	logger.Info(fmt.Sprintf("Running all %d rules", len(s.config.Rules)), "batch_id", batchID)
+
+	rules, err := s.config.RuleOrder()
+	if err != nil {
+		// Caught when the configuration was validated
+		logger.Error(err, "invalid rule dependencies", "batch_id", batchID)
+		rules = s.config.Rules
+	}
+
+	results := make([]RunResult, 0, len(rules))
+	statuses := make(map[string]string)
+	var successCount, violationCount, errorCount, skippedCount int
+	for _, rule := range rules {
+		result := s.executeAfter(rule, batchID, config.Schedule{}, statuses)
+		switch result.Status {
+		case storage.StatusError:
 			errorCount++
-		} else {
+		case storage.StatusViolation:
+			violationCount++
+		case storage.StatusSkipped:
+			skippedCount++
+		default:
 			successCount++
 		}
-		fmt.Println() // Add spacing between rule executions
+		results = append(results, result)
+		fmt.Fprintln(os.Stderr) // Add spacing between rule executions
 	}
 
-	logger.Info(fmt.Sprintf("Completed: %d successful, %d errors", successCount, errorCount))
-	return successCount, errorCount
+	logger.Info(fmt.Sprintf("Completed: %d successful, %d violations, %d errors, %d skipped",
+		successCount, violationCount, errorCount, skippedCount), "batch_id", batchID)
+	return results
 }
 
 func (s *Scheduler) processLogEvents(events []db.LogEvent) {
@@ -128,22 +623,25 @@ func (s *Scheduler) processLogEvents(events []db.LogEvent) {
 			args := convertFieldsToArgs(event.Fields)
 			logger.Warn(event.Message, args...)
 		case "task":
+			args := convertFieldsToArgs(fieldsWithout(event.Fields, "rule"))
 			if name, ok := event.Fields["rule"].(string); ok {
-				logger.Task(name, event.Message)
+				logger.Task(name, event.Message, args...)
 			} else {
-				logger.Task("Task", event.Message)
+				logger.Task("Task", event.Message, args...)
 			}
 		case "rule":
+			args := convertFieldsToArgs(fieldsWithout(event.Fields, "rule"))
 			if name, ok := event.Fields["rule"].(string); ok {
-				logger.Rule(name, event.Message)
+				logger.Rule(name, event.Message, args...)
 			} else {
-				logger.Rule("Rule", event.Message)
+				logger.Rule("Rule", event.Message, args...)
 			}
 		case "db":
+			args := convertFieldsToArgs(fieldsWithout(event.Fields, "server"))
 			if name, ok := event.Fields["server"].(string); ok {
-				logger.DB(name, event.Message)
+				logger.DB(name, event.Message, args...)
 			} else {
-				logger.DB("DB", event.Message)
+				logger.DB("DB", event.Message, args...)
 			}
 		}
 	}
@@ -158,6 +656,18 @@ func convertFieldsToArgs(fields map[string]interface{}) []interface{} {
 	return args
 }
 
+// fieldsWithout returns a copy of fields minus the given key, used when that
+// field is already rendered as the log line's name
+func fieldsWithout(fields map[string]interface{}, key string) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if k != key {
+			out[k] = v
+		}
+	}
+	return out
+}
+
 func (s *Scheduler) findRule(name string) (config.Rule, error) {
 	for _, r := range s.config.Rules {
 		if r.Name == name {
@@ -167,6 +677,22 @@ func (s *Scheduler) findRule(name string) (config.Rule, error) {
 	return config.Rule{}, fmt.Errorf("rule not found: %s", name)
 }
 
+// selectServer picks the server a rule runs on: the one the schedule names,
+// otherwise the first server of the rule's type
+func (s *Scheduler) selectServer(rule config.Rule, schedule config.Schedule) (config.DbServer, error) {
+	if schedule.Server == "" {
+		return s.findServer(rule.DbType)
+	}
+	srv, err := s.findServerByName(schedule.Server)
+	if err != nil {
+		return config.DbServer{}, err
+	}
+	if srv.Type != rule.DbType {
+		return config.DbServer{}, fmt.Errorf("server %s is %s but rule %s needs %s", srv.Name, srv.Type, rule.Name, rule.DbType)
+	}
+	return srv, nil
+}
+
 func (s *Scheduler) findServer(dbType string) (config.DbServer, error) {
 	for _, srv := range s.config.DBServers {
 		if srv.Type == dbType {
@@ -177,34 +703,61 @@ func (s *Scheduler) findServer(dbType string) (config.DbServer, error) {
 }
 
 func (s *Scheduler) recordExecution(
+	exec execution,
 	rule config.Rule,
 	server config.DbServer,
-	startTime time.Time,
 	result db.ExecutionResult,
 	err error,
-) {
+) storage.ExecutionRecord {
 	endTime := time.Now()
-	duration := float64(endTime.Sub(startTime).Milliseconds())
+	duration := float64(endTime.Sub(exec.StartTime).Milliseconds())
 
 	record := &storage.ExecutionRecord{
+		ExecutionID:  exec.ID,
+		BatchID:      exec.BatchID,
 		RuleName:     rule.Name,
 		ServerName:   server.Name,
-		StartTime:    startTime,
+		StartTime:    exec.StartTime,
 		EndTime:      endTime,
-		Status:       "success",
+		Status:       storage.StatusSuccess,
+		Severity:     rule.Severity,
 		Result:       result.Results,
+		MutedBy:      exec.MutedBy,
+		MuteReason:   exec.MuteReason,
 		Description:  rule.Description,
 		Duration:     duration,
 		RowsAffected: result.RowCount,
 	}
+	for _, p := range result.Phases {
+		record.Phases = append(record.Phases, storage.PhaseTiming{
+			Phase:    p.Phase,
+			Duration: float64(p.Duration.Milliseconds()),
+		})
+	}
+	for _, a := range result.Attempts {
+		attempt := storage.Attempt{Duration: float64(a.Duration.Milliseconds())}
+		if a.Err != nil {
+			attempt.Error = db.RedactString(a.Err.Error())
+		}
+		record.Attempts = append(record.Attempts, attempt)
+	}
 
 	if err != nil {
-		record.Status = "error"
-		record.Error = err.Error()
+		record.Status = storage.StatusError
+		record.Error = db.RedactString(err.Error())
 		record.Result = ""
+	} else if isViolation(rule, result) {
+		record.Status = storage.StatusViolation
+	} else if rule.Baseline != nil {
+		s.evaluateBaseline(record, rule, result)
+	} else if rule.Kind == config.CheckProfile {
+		evaluateProfile(record, rule, result)
+	} else if rule.Expression != "" {
+		s.evaluateExpression(record, rule, result)
 	}
 
 	if err := s.store.SaveExecutionRecord(record); err != nil {
-		logger.Error(err, "failed to save execution record")
+		logger.Error(err, "failed to save execution record", "execution_id", exec.ID)
 	}
+	return *record
 }
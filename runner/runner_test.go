@@ -1,9 +1,12 @@
 package runner
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,6 +14,7 @@ import (
 	"github.com/nathanthorell/dataspy/db"
 	"github.com/nathanthorell/dataspy/storage"
 	"github.com/stretchr/testify/assert"
+	_ "modernc.org/sqlite"
 )
 
 type testFixtures struct {
@@ -120,11 +124,30 @@ func TestAddTask(t *testing.T) {
 			},
 			shouldError: true,
 		},
+		{
+			name: "schedule in its own timezone",
+			schedule: config.Schedule{
+				Server:   "test-server",
+				Rule:     "test-rule",
+				CronStr:  "0 0 6 * * MON-FRI",
+				Timezone: "America/New_York",
+			},
+			shouldError: false,
+		},
+		{
+			name: "unknown timezone",
+			schedule: config.Schedule{
+				Server:  "test-server",
+				Rule:    "test-rule",
+				CronStr: "CRON_TZ=Nowhere/Special 0 0 6 * * *",
+			},
+			shouldError: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := f.scheduler.addTask(tt.schedule)
+			entryID, loc, err := f.scheduler.addTask(tt.schedule)
 
 			if tt.shouldError {
 				assert.Error(t, err)
@@ -132,6 +155,11 @@ func TestAddTask(t *testing.T) {
 			} else {
 				assert.NoError(t, err)
 				assert.NotEmpty(t, f.scheduler.scheduler.Entries())
+				if tt.schedule.Timezone != "" {
+					assert.Equal(t, tt.schedule.Timezone, loc.String())
+					next := f.scheduler.scheduler.Entry(entryID).Schedule.Next(time.Now())
+					assert.Equal(t, 6, next.In(loc).Hour())
+				}
 			}
 		})
 	}
@@ -232,6 +260,36 @@ func TestFindServer(t *testing.T) {
 	}
 }
 
+func TestSelectServer(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	rule := config.Rule{Name: "pg-rule", DbType: "postgres"}
+	tests := []struct {
+		name       string
+		schedule   config.Schedule
+		wantServer string
+		wantErr    string
+	}{
+		{name: "ad hoc uses first server of type", wantServer: "test-postgres"},
+		{name: "schedule names server", schedule: config.Schedule{Server: "test-postgres"}, wantServer: "test-postgres"},
+		{name: "schedule server of another type", schedule: config.Schedule{Server: "test-mysql"}, wantErr: "needs postgres"},
+		{name: "schedule server missing", schedule: config.Schedule{Server: "gone"}, wantErr: "server not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := f.scheduler.selectServer(rule, tt.schedule)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantServer, server.Name)
+		})
+	}
+}
+
 func TestRecordExecution(t *testing.T) {
 	f := setupTest(t)
 	defer f.cleanup()
@@ -273,18 +331,47 @@ func TestRecordExecution(t *testing.T) {
 			executeErr: fmt.Errorf("test error"),
 			wantStatus: "error",
 		},
+		{
+			name: "check with violating rows",
+			rule: config.Rule{
+				Name:     "test-execution-rule",
+				DbType:   "postgres",
+				Query:    "SELECT id FROM orders WHERE total < 0",
+				Severity: config.SeverityCritical,
+			},
+			server:     testServer,
+			result:     db.ExecutionResult{RowCount: 2, Results: "Found 2 rows"},
+			executeErr: nil,
+			wantStatus: "violation",
+		},
+		{
+			name: "check without violating rows",
+			rule: config.Rule{
+				Name:     "test-execution-rule",
+				DbType:   "postgres",
+				Query:    "SELECT id FROM orders WHERE total < 0",
+				Severity: config.SeverityWarning,
+			},
+			server:     testServer,
+			result:     db.ExecutionResult{RowCount: 0, Results: "Query completed successfully (0 rows)"},
+			executeErr: nil,
+			wantStatus: "success",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			startTime := time.Now().Add(-time.Second) // Execution started 1 second ago
-			f.scheduler.recordExecution(tt.rule, tt.server, startTime, tt.result, tt.executeErr)
+			exec := newExecution("test-batch")
+			exec.StartTime = time.Now().Add(-time.Second) // Execution started 1 second ago
+			f.scheduler.recordExecution(exec, tt.rule, tt.server, tt.result, tt.executeErr)
 
 			records, err := f.store.GetExecutionsByRule(tt.rule.Name)
 			assert.NoError(t, err)
 			assert.NotEmpty(t, records)
 
 			lastRecord := records[len(records)-1]
+			assert.Equal(t, exec.ID, lastRecord.ExecutionID)
+			assert.Equal(t, "test-batch", lastRecord.BatchID)
 			assert.Equal(t, tt.rule.Name, lastRecord.RuleName)
 			assert.Equal(t, tt.server.Name, lastRecord.ServerName)
 			assert.Equal(t, tt.wantStatus, lastRecord.Status)
@@ -301,3 +388,833 @@ func TestRecordExecution(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteAllRulesBatch(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	// No connection string is set, so every rule fails, but each run should
+	// still be recorded under the same batch
+	f.scheduler.config.Rules = append(f.scheduler.config.Rules, config.Rule{
+		Name:   "second-rule",
+		DbType: "mysql",
+		Query:  "SELECT 1",
+	})
+
+	results := f.scheduler.ExecuteAllRules()
+	assert.Len(t, results, 2)
+	for _, r := range results {
+		assert.Equal(t, "error", r.Status)
+	}
+
+	records, err := f.store.GetLatestExecutions(2)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.NotEmpty(t, records[0].BatchID)
+	assert.Equal(t, records[0].BatchID, records[1].BatchID)
+	assert.NotEqual(t, records[0].ExecutionID, records[1].ExecutionID)
+
+	batch, err := f.store.GetExecutionsByBatch(records[0].BatchID)
+	assert.NoError(t, err)
+	assert.Len(t, batch, 2)
+	assert.Equal(t, "test-rule", batch[0].RuleName)
+	assert.Equal(t, "second-rule", batch[1].RuleName)
+}
+
+func TestWatermark(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	f.scheduler.config = config.Config{
+		DBServers: []config.DbServer{
+			{Name: "local", Type: "sqlite", Path: filepath.Join(t.TempDir(), "local.db")},
+		},
+		Rules: []config.Rule{
+			{Name: "incremental", DbType: "sqlite", Query: "SELECT :last_success_at = :watermark"},
+			{Name: "broken", DbType: "sqlite", Query: "SELECT * FROM missing WHERE t > :watermark"},
+			{Name: "violating", DbType: "sqlite", Severity: config.SeverityWarning, Query: "SELECT 1 WHERE :watermark IS NOT NULL"},
+			{Name: "plain", DbType: "sqlite", Query: "SELECT 1"},
+		},
+	}
+
+	first, err := f.scheduler.ExecuteRuleByName("incremental")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"1"}}, first.Rows)
+
+	wm, found, err := f.store.GetWatermark("incremental", "local")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, wm.Value.Equal(first.StartTime), "watermark should be the start of the successful run")
+	assert.Equal(t, first.ExecutionID, wm.ExecutionID)
+
+	// Failed runs leave the watermark alone
+	_, err = f.scheduler.ExecuteRuleByName("broken")
+	assert.Error(t, err)
+	_, found, err = f.store.GetWatermark("broken", "local")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// So do violations, and rules that never read a watermark don't get one
+	result, err := f.scheduler.ExecuteRuleByName("violating")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusViolation, result.Status)
+	_, err = f.scheduler.ExecuteRuleByName("plain")
+	assert.NoError(t, err)
+	for _, name := range []string{"violating", "plain"} {
+		_, found, err = f.store.GetWatermark(name, "local")
+		assert.NoError(t, err)
+		assert.False(t, found, "watermark for %s", name)
+	}
+
+	// Watermarks set while the daemon holds the store are queued, and
+	// applied before its next run
+	backfill := first.StartTime.Add(-24 * time.Hour)
+	assert.NoError(t, storage.NewChangeQueue(f.dbPath).Add(storage.QueuedChange{
+		Kind: storage.ChangeSetWatermark, RuleName: "incremental", ServerName: "local", Value: backfill,
+	}))
+	_, err = f.scheduler.ExecuteRuleByName("plain")
+	assert.NoError(t, err)
+	wm, _, err = f.store.GetWatermark("incremental", "local")
+	assert.NoError(t, err)
+	assert.True(t, wm.Value.Equal(backfill), "queued watermark should be applied")
+	queued, err := f.store.Queue().List()
+	assert.NoError(t, err)
+	assert.Empty(t, queued)
+}
+
+func TestBaselineEvaluation(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	rule := config.Rule{
+		Name:     "daily-orders",
+		DbType:   "postgres",
+		Severity: config.SeverityCritical,
+		Baseline: &config.Baseline{Method: config.BaselinePercentChange, Threshold: 40, Direction: config.DirectionDrop, Window: 3},
+	}
+	server := config.DbServer{Name: "test-postgres"}
+	metricResult := func(v string) db.ExecutionResult {
+		return db.ExecutionResult{RowCount: 1, Rows: [][]string{{v}}, Columns: []string{"orders"}}
+	}
+
+	// History on another server must not count towards this one's baseline
+	f.scheduler.recordExecution(newExecution(""), rule, config.DbServer{Name: "test-mysql"}, metricResult("1"), nil)
+
+	// Too little history: recorded but never flagged
+	for _, v := range []string{"100", "104", "96"} {
+		record := f.scheduler.recordExecution(newExecution(""), rule, server, metricResult(v), nil)
+		assert.Equal(t, storage.StatusSuccess, record.Status)
+		assert.NotNil(t, record.Metric)
+		assert.Nil(t, record.Expected)
+	}
+	record := f.scheduler.recordExecution(newExecution(""), rule, server, metricResult("100"), nil)
+	assert.Equal(t, storage.StatusSuccess, record.Status)
+	if assert.NotNil(t, record.Expected) {
+		assert.Equal(t, 100.0, *record.Expected)
+	}
+
+	// 55 against a mean of 100 is a 45% drop
+	record = f.scheduler.recordExecution(newExecution(""), rule, server, metricResult("55"), nil)
+	assert.Equal(t, storage.StatusViolation, record.Status)
+	assert.Contains(t, record.Anomaly, "45.0% below 100")
+
+	// The anomalous run isn't part of later baselines
+	record = f.scheduler.recordExecution(newExecution(""), rule, server, metricResult("100"), nil)
+	assert.Equal(t, storage.StatusSuccess, record.Status)
+	if assert.NotNil(t, record.Expected) {
+		assert.Equal(t, 100.0, *record.Expected)
+	}
+
+	// Rows don't make a baseline rule a violation, but a non-numeric metric
+	// is an error
+	record = f.scheduler.recordExecution(newExecution(""), rule, server, metricResult("lots"), nil)
+	assert.Equal(t, storage.StatusError, record.Status)
+	assert.Contains(t, record.Error, "must return a number")
+}
+
+// newSQLiteServer creates a SQLite database file with the given statements
+func newSQLiteServer(t *testing.T, name string, statements string) config.DbServer {
+	path := filepath.Join(t.TempDir(), name+".db")
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(statements); err != nil {
+		t.Fatalf("Failed to seed sqlite: %v", err)
+	}
+	return config.DbServer{Name: name, Type: "sqlite", Path: path}
+}
+
+func TestReconciliation(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	oltp := newSQLiteServer(t, "oltp", `
+		CREATE TABLE orders (id INTEGER, total REAL);
+		INSERT INTO orders VALUES (1, 10), (2, 20), (3, 30);`)
+	warehouse := newSQLiteServer(t, "warehouse", `
+		CREATE TABLE fact_orders (order_id INTEGER, amount REAL);
+		INSERT INTO fact_orders VALUES (1, 10), (2, 25), (4, 40);`)
+
+	rowsRule := config.Rule{
+		Name:     "orders-match",
+		Severity: config.SeverityCritical,
+		Reconcile: &config.Reconcile{
+			Mode:       config.ReconcileRows,
+			KeyColumns: []string{"id"},
+			Source:     config.ReconcileSide{Server: "oltp", Query: "SELECT id, total FROM orders"},
+			Target:     config.ReconcileSide{Server: "warehouse", Query: "SELECT order_id AS id, amount AS total FROM fact_orders"},
+		},
+	}
+	countRule := config.Rule{
+		Name:     "order-count",
+		Severity: config.SeverityWarning,
+		Reconcile: &config.Reconcile{
+			Mode:   config.ReconcileScalar,
+			Source: config.ReconcileSide{Server: "oltp", Query: "SELECT COUNT(*) FROM orders WHERE :watermark IS NOT NULL"},
+			Target: config.ReconcileSide{Server: "warehouse", Query: "SELECT COUNT(*) FROM fact_orders WHERE :watermark IS NOT NULL"},
+		},
+	}
+	brokenRule := config.Rule{
+		Name:     "broken",
+		Severity: config.SeverityWarning,
+		Reconcile: &config.Reconcile{
+			Mode:   config.ReconcileScalar,
+			Source: config.ReconcileSide{Server: "oltp", Query: "SELECT COUNT(*) FROM orders"},
+			Target: config.ReconcileSide{Server: "warehouse", Query: "SELECT COUNT(*) FROM missing"},
+		},
+	}
+	// Masked values are compared as they are and masked in the differences,
+	// so a redacted total still mismatches and a hashed one still meets the
+	// tolerance
+	maskedRule := config.Rule{
+		Name:     "orders-masked",
+		Severity: config.SeverityCritical,
+		Reconcile: &config.Reconcile{
+			Mode:       config.ReconcileRows,
+			KeyColumns: []string{"id"},
+			Source:     config.ReconcileSide{Server: "oltp", Query: "SELECT id, total, total AS hashed FROM orders WHERE id < 3"},
+			Target:     config.ReconcileSide{Server: "warehouse", Query: "SELECT order_id AS id, amount AS total, order_id * 10 + 0.5 AS hashed FROM fact_orders WHERE order_id < 3"},
+			Tolerance:  1,
+		},
+		Masking: []config.MaskRule{
+			{Column: "total", Mode: config.MaskRedact},
+			{Column: "hashed", Mode: config.MaskHash},
+		},
+	}
+	t.Setenv("DATASPY_TEST_MASKING_KEY", "secret")
+	f.scheduler.config = config.Config{
+		DBServers:  []config.DbServer{oltp, warehouse},
+		Rules:      []config.Rule{rowsRule, countRule, brokenRule, maskedRule},
+		MaskingKey: "env:DATASPY_TEST_MASKING_KEY",
+	}
+
+	result, err := f.scheduler.ExecuteRuleByName("orders-match")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusViolation, result.Status)
+	assert.Equal(t, "oltp vs warehouse", result.ServerName)
+	assert.Equal(t, [][]string{
+		{"id=2", "mismatch", "total: 20 != 25"},
+		{"id=3", "missing", "not in target"},
+		{"id=4", "extra", "not in source"},
+	}, result.Rows)
+
+	result, err = f.scheduler.ExecuteRuleByName("orders-masked")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusViolation, result.Status)
+	assert.Equal(t, [][]string{{"id=2", "mismatch", "total: [REDACTED] != [REDACTED]"}}, result.Rows)
+	assert.NotContains(t, result.Result, "25")
+
+	result, err = f.scheduler.ExecuteRuleByName("order-count")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusSuccess, result.Status)
+
+	// Both sides advance their own watermark
+	for _, server := range []string{"oltp", "warehouse"} {
+		_, found, err := f.store.GetWatermark("order-count", server)
+		assert.NoError(t, err)
+		assert.True(t, found, "watermark for %s", server)
+	}
+
+	result, err = f.scheduler.ExecuteRuleByName("broken")
+	assert.ErrorContains(t, err, "warehouse")
+	assert.Equal(t, storage.StatusError, result.Status)
+}
+
+func TestSchemaDrift(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	server := newSQLiteServer(t, "local", `
+		CREATE TABLE orders (id INTEGER NOT NULL, total REAL);
+		CREATE INDEX ix_orders_id ON orders (id);`)
+	alter := func(statements string) {
+		conn, err := sql.Open("sqlite", server.Path)
+		if err != nil {
+			t.Fatalf("Failed to open sqlite: %v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Exec(statements); err != nil {
+			t.Fatalf("Failed to alter sqlite: %v", err)
+		}
+	}
+
+	rule := config.Rule{
+		Name:     "orders-schema",
+		DbType:   "sqlite",
+		Severity: config.SeverityWarning,
+		Kind:     config.CheckSchema,
+		Tables:   []string{"orders"},
+		// Masking would otherwise hash every column name
+		Masking: []config.MaskRule{{Column: "*", Mode: config.MaskHash}},
+	}
+	typo := rule
+	typo.Name = "typo-schema"
+	typo.Tables = []string{"order"}
+	f.scheduler.config = config.Config{
+		DBServers: []config.DbServer{server},
+		Rules:     []config.Rule{rule, typo},
+	}
+
+	result, err := f.scheduler.ExecuteRuleByName("orders-schema")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusSuccess, result.Status)
+	assert.Contains(t, result.Result, "Recorded first schema snapshot: 3")
+
+	alter(`ALTER TABLE orders ADD COLUMN status TEXT; DROP INDEX ix_orders_id;`)
+	result, err = f.scheduler.ExecuteRuleByName("orders-schema")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusViolation, result.Status)
+	assert.Equal(t, [][]string{
+		{"orders", "column", "status", "added", "TEXT NULL"},
+		{"orders", "index", "ix_orders_id", "removed", "was (id)"},
+	}, result.Rows)
+
+	// Against the previous snapshot, drift is only reported once
+	result, err = f.scheduler.ExecuteRuleByName("orders-schema")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusSuccess, result.Status)
+
+	// Against a pinned snapshot, it's reported until the pin moves
+	_, err = f.store.PinSchemaSnapshot("orders-schema", "local")
+	assert.NoError(t, err)
+	alter(`ALTER TABLE orders DROP COLUMN total;`)
+	for i := 0; i < 2; i++ {
+		result, err = f.scheduler.ExecuteRuleByName("orders-schema")
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{{"orders", "column", "total", "removed", "was REAL NULL"}}, result.Rows)
+	}
+
+	result, err = f.scheduler.ExecuteRuleByName("typo-schema")
+	assert.ErrorContains(t, err, "tables not found on local: order")
+	assert.Equal(t, storage.StatusError, result.Status)
+}
+
+func TestProfile(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	server := newSQLiteServer(t, "local", `
+		CREATE TABLE customers (id INTEGER, email TEXT, country TEXT);
+		INSERT INTO customers VALUES
+			(1, 'a@example.com', 'NZ'), (2, NULL, 'NZ'), (3, 'c@example.com', 'AU'), (4, 'd@example.com', 'NZ');`)
+
+	profileRule := config.Rule{
+		Name:    "customers-profile",
+		DbType:  "sqlite",
+		Kind:    config.CheckProfile,
+		Table:   "customers",
+		Columns: []string{"email", "country"},
+		TopN:    1,
+	}
+	expectRule := profileRule
+	expectRule.Name = "customers-expect"
+	expectRule.Severity = config.SeverityWarning
+	expectRule.Expect = []string{"row_count > 0", "null_ratio(email) < 0.1", "distinct_count(country) >= 2"}
+	maskedRule := profileRule
+	maskedRule.Name = "customers-masked"
+	maskedRule.Masking = []config.MaskRule{{Column: "email", Mode: config.MaskRedact}}
+	f.scheduler.config = config.Config{
+		DBServers: []config.DbServer{server},
+		Rules:     []config.Rule{profileRule, expectRule, maskedRule},
+	}
+
+	result, err := f.scheduler.ExecuteRuleByName("customers-profile")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusSuccess, result.Status)
+	assert.Equal(t, [][]string{
+		{"email", "0.2500", "3", "a@example.com", "d@example.com", "a@example.com (1)"},
+		{"country", "0.0000", "2", "AU", "NZ", "NZ (3)"},
+	}, result.Rows)
+	assert.Contains(t, result.Result, "Profiled 2 columns over 4 rows")
+
+	result, err = f.scheduler.ExecuteRuleByName("customers-expect")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusViolation, result.Status)
+	assert.Equal(t, "null_ratio(email) < 0.1 (actual 0.25)", result.Anomaly)
+
+	// The profile is kept in the execution history
+	records, err := f.store.GetExecutionsByRule("customers-profile")
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) && assert.NotNil(t, records[0].Profile) {
+		assert.Equal(t, int64(4), records[0].Profile.RowCount)
+		assert.Equal(t, 0.25, records[0].Profile.Columns[0].NullRatio)
+	}
+
+	// Masked columns keep their counts but not their values
+	result, err = f.scheduler.ExecuteRuleByName("customers-masked")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"email", "0.2500", "3", "[REDACTED]", "[REDACTED]", "[REDACTED] (1)"},
+		{"country", "0.0000", "2", "AU", "NZ", "NZ (3)"},
+	}, result.Rows)
+	records, err = f.store.GetExecutionsByRule("customers-masked")
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) && assert.NotNil(t, records[0].Profile) {
+		assert.Equal(t, "[REDACTED]", records[0].Profile.Columns[0].Min)
+		assert.NotContains(t, records[0].Result, "example.com")
+	}
+}
+
+func TestExpression(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	server := newSQLiteServer(t, "local", `
+		CREATE TABLE orders (status TEXT, total REAL);
+		INSERT INTO orders VALUES ('paid', 10), ('paid', 20), ('refunded', 5);`)
+
+	rule := func(name, expr string) config.Rule {
+		return config.Rule{
+			Name:       name,
+			DbType:     "sqlite",
+			Severity:   config.SeverityWarning,
+			Query:      "SELECT status, SUM(total) AS total FROM orders GROUP BY status ORDER BY status",
+			Expression: expr,
+		}
+	}
+	f.scheduler = NewScheduler(config.Config{
+		DBServers: []config.DbServer{server},
+		Rules: []config.Rule{
+			rule("totals-hold", `row_count == 2 && rows.all(r, r.total > 0)`),
+			rule("totals-fail", `rows.exists(r, r.status == "refunded" && r.total == 0)`),
+			rule("totals-error", `rows[0].missing > 0`),
+		},
+	}, f.store)
+	// Compiled once up front rather than on every run
+	assert.Len(t, f.scheduler.expressions, 3)
+
+	tests := []struct {
+		rule    string
+		status  string
+		anomaly string
+		err     string
+	}{
+		{"totals-hold", storage.StatusSuccess, "", ""},
+		{"totals-fail", storage.StatusViolation, `expression is false: rows.exists(r, r.status == "refunded" && r.total == 0)`, ""},
+		{"totals-error", storage.StatusError, "", "no such key: missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			result, err := f.scheduler.ExecuteRuleByName(tt.rule)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.status, result.Status)
+			assert.Equal(t, tt.anomaly, result.Anomaly)
+		})
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	server := newSQLiteServer(t, "local", `
+		CREATE TABLE etl_runs (finished_on TEXT);
+		CREATE TABLE orders (id INTEGER, total REAL);
+		INSERT INTO orders VALUES (1, 10), (2, -5);`)
+
+	rule := func(name, query string, deps ...string) config.Rule {
+		return config.Rule{Name: name, DbType: "sqlite", Severity: config.SeverityCritical, Query: query, DependsOn: deps}
+	}
+	// Listed before their prerequisites, which still run first
+	f.scheduler.config = config.Config{
+		DBServers: []config.DbServer{server},
+		Rules: []config.Rule{
+			rule("revenue", "SELECT id FROM orders WHERE total < 0", "etl-finished"),
+			rule("revenue-report", "SELECT 1 WHERE 0", "revenue"),
+			rule("etl-finished", "SELECT 1 WHERE NOT EXISTS (SELECT 1 FROM etl_runs)"),
+			rule("orders-exist", "SELECT 1 WHERE NOT EXISTS (SELECT 1 FROM orders)"),
+		},
+	}
+
+	results := f.scheduler.ExecuteAllRules()
+	var got [][]string
+	for _, r := range results {
+		got = append(got, []string{r.Rule.Name, r.Status, r.SkipReason})
+	}
+	assert.Equal(t, [][]string{
+		{"etl-finished", storage.StatusViolation, ""},
+		{"revenue", storage.StatusSkipped, "prerequisite etl-finished did not pass (violation)"},
+		{"revenue-report", storage.StatusSkipped, "prerequisite revenue did not pass (skipped)"},
+		{"orders-exist", storage.StatusSuccess, ""},
+	}, got)
+	assert.Equal(t, "local", results[1].ServerName)
+
+	// Skips are kept in the execution history
+	records, err := f.store.GetExecutionsByRule("revenue")
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, storage.StatusSkipped, records[0].Status)
+	}
+
+	// A scheduled rule runs after its prerequisites, in one batch
+	conn, err := sql.Open("sqlite", server.Path)
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec("INSERT INTO etl_runs VALUES ('2024-03-15')"); err != nil {
+		t.Fatalf("Failed to seed sqlite: %v", err)
+	}
+	f.scheduler.runTask(config.Schedule{Rule: "revenue-report", Server: "local", CronStr: "0 0 * * * *"})
+
+	records, err = f.store.GetExecutionsByRule("revenue-report")
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		latest := records[0]
+		if records[1].StartTime.After(latest.StartTime) {
+			latest = records[1]
+		}
+		assert.Equal(t, storage.StatusSkipped, latest.Status)
+		assert.Equal(t, "prerequisite revenue did not pass (violation)", latest.SkipReason)
+
+		batch, err := f.store.GetExecutionsByBatch(latest.BatchID)
+		assert.NoError(t, err)
+		var statuses []string
+		for _, r := range batch {
+			statuses = append(statuses, r.RuleName+"="+r.Status)
+		}
+		assert.ElementsMatch(t, []string{"etl-finished=success", "revenue=violation", "revenue-report=skipped"}, statuses)
+	}
+}
+
+func TestServerHealth(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	// A read-only SQLite server whose file doesn't exist yet can't be reached
+	path := filepath.Join(t.TempDir(), "late.db")
+	server := config.DbServer{Name: "late", Type: "sqlite", Path: path, ReadOnly: true}
+	rule := func(name string) config.Rule {
+		return config.Rule{Name: name, DbType: "sqlite", Severity: config.SeverityWarning, Query: "SELECT 1 WHERE 0"}
+	}
+	f.scheduler.config = config.Config{
+		DBServers: []config.DbServer{server},
+		Rules:     []config.Rule{rule("first"), rule("second")},
+	}
+	var recovered atomic.Bool
+	f.scheduler.probeInterval = 10 * time.Millisecond
+	f.scheduler.probe = func(config.DbServer) (string, error) {
+		if recovered.Load() {
+			return "3.46.0", nil
+		}
+		return "", fmt.Errorf("probe: %w", db.ErrUnavailable)
+	}
+
+	result, err := f.scheduler.ExecuteRuleByName("first")
+	assert.ErrorIs(t, err, db.ErrUnavailable)
+	assert.Equal(t, storage.StatusError, result.Status)
+
+	// Later rules on the server are skipped rather than erroring
+	result, err = f.scheduler.ExecuteRuleByName("second")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusSkipped, result.Status)
+	assert.Equal(t, "server unavailable", result.SkipReason)
+	assert.Equal(t, "late", result.ServerName)
+	assert.True(t, result.ServerUnavailable())
+
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	if _, err := conn.Exec("CREATE TABLE t (id INTEGER)"); err != nil {
+		t.Fatalf("Failed to create sqlite: %v", err)
+	}
+	conn.Close()
+	recovered.Store(true)
+	assert.Eventually(t, func() bool { return !f.scheduler.health.isDown("late") }, time.Second, 10*time.Millisecond)
+
+	result, err = f.scheduler.ExecuteRuleByName("second")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusSuccess, result.Status)
+}
+
+func TestStopEndsProbes(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	server := config.DbServer{Name: "gone", Type: "sqlite", Path: filepath.Join(t.TempDir(), "gone.db"), ReadOnly: true}
+	f.scheduler.config = config.Config{
+		DBServers: []config.DbServer{server},
+		Rules:     []config.Rule{{Name: "check", DbType: "sqlite", Query: "SELECT 1"}},
+	}
+	var probes atomic.Int32
+	f.scheduler.probeInterval = 5 * time.Millisecond
+	f.scheduler.probe = func(config.DbServer) (string, error) {
+		probes.Add(1)
+		return "", fmt.Errorf("probe: %w", db.ErrUnavailable)
+	}
+
+	_, err := f.scheduler.ExecuteRuleByName("check")
+	assert.ErrorIs(t, err, db.ErrUnavailable)
+	assert.Eventually(t, func() bool { return probes.Load() > 0 }, time.Second, 5*time.Millisecond)
+
+	// Stop returns once the probe has given up, and it doesn't run again
+	f.scheduler.Stop()
+	stopped := probes.Load()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, stopped, probes.Load())
+}
+
+func TestRetry(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	server := newSQLiteServer(t, "local", `CREATE TABLE orders (id INTEGER, total REAL);`)
+	server.Retry = &config.RetryPolicy{MaxAttempts: 10, Backoff: "50ms", MaxBackoff: "50ms"}
+	f.scheduler.config = config.Config{
+		DBServers: []config.DbServer{server},
+		Rules: []config.Rule{
+			{Name: "negative", DbType: "sqlite", Severity: config.SeverityWarning, Query: "SELECT id FROM orders WHERE total < 0"},
+		},
+	}
+
+	// Another connection's exclusive lock makes reads fail as busy until
+	// it's released
+	lock, err := sql.Open("sqlite", server.Path)
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	defer lock.Close()
+	conn, err := lock.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), "BEGIN EXCLUSIVE"); err != nil {
+		t.Fatalf("Failed to lock sqlite: %v", err)
+	}
+	time.AfterFunc(120*time.Millisecond, func() { conn.ExecContext(context.Background(), "COMMIT") })
+
+	result, err := f.scheduler.ExecuteRuleByName("negative")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusSuccess, result.Status)
+	if assert.Greater(t, len(result.Attempts), 1) {
+		assert.Contains(t, result.Attempts[0].Error, "SQLITE_BUSY")
+		assert.Empty(t, result.Attempts[len(result.Attempts)-1].Error)
+	}
+	// A locked database is still available
+	assert.False(t, f.scheduler.health.isDown("local"))
+
+	// Every attempt is kept with the one execution
+	records, err := f.store.GetExecutionsByRule("negative")
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, result.Attempts, records[0].Attempts)
+	}
+}
+
+func TestSchemaKeepsPhasesAndAttempts(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	server := newSQLiteServer(t, "local", `CREATE TABLE orders (id INTEGER, total REAL);`)
+	server.Retry = &config.RetryPolicy{MaxAttempts: 10, Backoff: "50ms", MaxBackoff: "50ms"}
+	f.scheduler.config = config.Config{
+		DBServers: []config.DbServer{server},
+		Rules: []config.Rule{{
+			Name:     "orders-schema",
+			DbType:   "sqlite",
+			Severity: config.SeverityWarning,
+			Kind:     config.CheckSchema,
+			Tables:   []string{"orders"},
+			Setup:    []string{"CREATE TEMP TABLE seen (id INTEGER)"},
+			Teardown: []string{"DROP TABLE seen"},
+		}},
+	}
+
+	// Reads fail as busy until the exclusive lock is released
+	lock, err := sql.Open("sqlite", server.Path)
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	defer lock.Close()
+	conn, err := lock.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), "BEGIN EXCLUSIVE"); err != nil {
+		t.Fatalf("Failed to lock sqlite: %v", err)
+	}
+	time.AfterFunc(120*time.Millisecond, func() { conn.ExecContext(context.Background(), "COMMIT") })
+
+	result, err := f.scheduler.ExecuteRuleByName("orders-schema")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusSuccess, result.Status)
+	assert.Contains(t, result.Result, "Recorded first schema snapshot")
+	assert.Greater(t, len(result.Attempts), 1)
+
+	records, err := f.store.GetExecutionsByRule("orders-schema")
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, result.Attempts, records[0].Attempts)
+		var phases []string
+		for _, p := range records[0].Phases {
+			phases = append(phases, p.Phase)
+		}
+		assert.Equal(t, []string{"setup", "query", "teardown"}, phases)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	f := setupTest(t)
+	defer f.cleanup()
+
+	server := newSQLiteServer(t, "local", `CREATE TABLE orders (id INTEGER, total REAL);
+		INSERT INTO orders VALUES (1, -5);`)
+	now := time.Now()
+	f.scheduler.config = config.Config{
+		DBServers: []config.DbServer{server},
+		Rules: []config.Rule{
+			{Name: "negative", DbType: "sqlite", Severity: config.SeverityWarning, Query: "SELECT id FROM orders WHERE total < 0"},
+			{Name: "broken", DbType: "sqlite", Severity: config.SeverityWarning, Query: "SELECT id FROM missing"},
+		},
+		Maintenance: []config.MaintenanceWindow{
+			// Ended an hour ago, so it never applies
+			{Name: "last-night", Start: now.Add(-3 * time.Hour).Format(time.RFC3339), End: now.Add(-time.Hour).Format(time.RFC3339)},
+			{
+				Name:   "reload",
+				Start:  now.Add(-time.Hour).Format(time.RFC3339),
+				End:    now.Add(time.Hour).Format(time.RFC3339),
+				Mode:   config.MaintenanceMute,
+				Rules:  []string{"negative", "broken"},
+				Reason: "orders reloading",
+			},
+		},
+	}
+
+	// Muted rules still run, but their failures are marked rather than reported
+	result, err := f.scheduler.ExecuteRuleByName("negative")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusViolation, result.Status)
+	assert.Equal(t, "reload", result.MutedBy)
+	assert.Equal(t, "orders reloading", result.MuteReason)
+
+	result, err = f.scheduler.ExecuteRuleByName("broken")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusError, result.Status)
+	assert.Equal(t, "reload", result.MutedBy)
+
+	// An ad-hoc skip window on the server wins over the mute window. It's
+	// opened the way the CLI does, while the scheduler holds the store.
+	err = storage.NewMaintenanceWindows(f.dbPath).Save(storage.MaintenanceWindow{
+		Name:    "upgrade",
+		Start:   now.Add(-time.Minute),
+		End:     now.Add(time.Hour),
+		Servers: []string{"local"},
+		Reason:  "minor version upgrade",
+	})
+	assert.NoError(t, err)
+
+	result, err = f.scheduler.ExecuteRuleByName("negative")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusSkipped, result.Status)
+	assert.Equal(t, "maintenance window upgrade: minor version upgrade", result.SkipReason)
+	assert.Empty(t, result.MutedBy)
+
+	stored, err := f.store.GetExecution(result.ExecutionID)
+	assert.NoError(t, err)
+	assert.Equal(t, "maintenance window upgrade: minor version upgrade", stored.SkipReason)
+}
+
+func TestCalendarSchedule(t *testing.T) {
+	cfg := config.Config{
+		Timezone: "America/New_York",
+		Calendars: []config.Calendar{
+			// 2026-01-01 is a Thursday and 2026-01-30 the last Friday of January
+			{Name: "finance", Holidays: []string{"2026-01-01", "2026-01-30"}},
+			{Name: "six-day", Weekdays: []string{"MON", "TUE", "WED", "THU", "FRI", "SAT"}},
+		},
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, newYork)
+	}
+
+	tests := []struct {
+		name     string
+		schedule config.Schedule
+		from     time.Time
+		want     []time.Time
+	}{
+		{
+			name:     "every business day",
+			schedule: config.Schedule{CronStr: "0 0 6 * * *", Calendar: "finance"},
+			from:     at(time.January, 1, 0),
+			want:     []time.Time{at(time.January, 2, 6), at(time.January, 5, 6), at(time.January, 6, 6)},
+		},
+		{
+			name:     "first business day",
+			schedule: config.Schedule{CronStr: "0 0 6 * * *", Calendar: "finance", BusinessDay: 1},
+			from:     at(time.January, 1, 0),
+			want:     []time.Time{at(time.January, 2, 6), at(time.February, 2, 6), at(time.March, 2, 6)},
+		},
+		{
+			name:     "last business day",
+			schedule: config.Schedule{CronStr: "0 0 18 * * *", Calendar: "finance", BusinessDay: -1},
+			from:     at(time.January, 1, 0),
+			want:     []time.Time{at(time.January, 29, 18), at(time.February, 27, 18), at(time.March, 31, 18)},
+		},
+		{
+			name:     "third business day with Saturdays",
+			schedule: config.Schedule{CronStr: "0 30 9 * * *", Calendar: "six-day", BusinessDay: 3},
+			from:     at(time.February, 1, 0),
+			want: []time.Time{
+				at(time.February, 4, 9).Add(30 * time.Minute),
+				at(time.March, 4, 9).Add(30 * time.Minute),
+			},
+		},
+		{
+			name:     "cron and calendar that never agree",
+			schedule: config.Schedule{CronStr: "0 0 6 * * SUN", Calendar: "finance"},
+			from:     at(time.January, 1, 0),
+			want:     []time.Time{{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, loc, err := ParseSchedule(cfg, tt.schedule)
+			assert.NoError(t, err)
+			assert.Equal(t, newYork, loc)
+
+			next := tt.from
+			for _, want := range tt.want {
+				next = spec.Next(next)
+				assert.True(t, want.Equal(next), "expected %v, got %v", want, next)
+			}
+		})
+	}
+
+	_, _, err = ParseSchedule(cfg, config.Schedule{CronStr: "@daily", Calendar: "missing"})
+	assert.EqualError(t, err, "calendar not found: missing")
+}
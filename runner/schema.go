@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nathanthorell/dataspy/config"
+	"github.com/nathanthorell/dataspy/db"
+	"github.com/nathanthorell/dataspy/drift"
+	"github.com/nathanthorell/dataspy/storage"
+)
+
+// evaluateSchema turns a schema check's catalog rows into a snapshot, stores
+// it, and replaces the result with the changes since the pinned snapshot, or
+// the previous one when none is pinned. The first run only records a
+// snapshot.
+func (s *Scheduler) evaluateSchema(exec execution, rule config.Rule, server config.DbServer, result db.ExecutionResult) (db.ExecutionResult, error) {
+	objects, err := drift.ParseObjects(result.Columns, result.Rows)
+	if err != nil {
+		return result, err
+	}
+
+	base, found, err := s.store.GetPinnedSchema(rule.Name, server.Name)
+	if err != nil {
+		return result, err
+	}
+	label := "pinned snapshot"
+	if !found {
+		base, found, err = s.store.GetSchemaSnapshot(rule.Name, server.Name)
+		if err != nil {
+			return result, err
+		}
+		label = "previous snapshot"
+	}
+
+	// Without a baseline to show the table disappearing, a missing table is
+	// far more likely a typo than drift
+	if missing := drift.MissingTables(rule.Tables, objects); !found && len(missing) > 0 {
+		return result, fmt.Errorf("tables not found on %s: %s", server.Name, strings.Join(missing, ", "))
+	}
+
+	err = s.store.SaveSchemaSnapshot(storage.SchemaSnapshot{
+		RuleName:    rule.Name,
+		ServerName:  server.Name,
+		ExecutionID: exec.ID,
+		TakenAt:     exec.StartTime,
+		Objects:     objects,
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to save schema snapshot: %w", err)
+	}
+
+	changes := drift.Diff(base.Objects, objects)
+	if !found {
+		changes = nil
+	}
+	// The rest of the result, such as phase timings and retry attempts, is
+	// kept with the run
+	out := result
+	out.RowCount = int64(len(changes))
+	out.Columns = drift.Columns
+	out.Rows = make([][]string, 0, len(changes))
+	for _, c := range changes {
+		out.Rows = append(out.Rows, c.Row())
+	}
+	switch {
+	case !found:
+		out.Results = fmt.Sprintf("Recorded first schema snapshot: %d columns and indexes", len(objects))
+	case len(changes) == 0:
+		out.Results = fmt.Sprintf("Schema matches the %s", label)
+	default:
+		var b strings.Builder
+		fmt.Fprintf(&b, "Found %d schema changes since the %s:\n", len(changes), label)
+		for i, c := range changes {
+			fmt.Fprintf(&b, "Row %d: %s %s %s %s: %s\n", i+1, c.Table, c.Type, c.Name, c.Kind, c.Detail)
+		}
+		out.Results = b.String()
+	}
+	return out, nil
+}
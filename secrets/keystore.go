@@ -0,0 +1,179 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Environment variables locating and unlocking the local keystore
+const (
+	KeystorePathVar       = "DATASPY_KEYSTORE"
+	KeystorePassphraseVar = "DATASPY_KEYSTORE_PASSPHRASE"
+	DefaultKeystorePath   = "data/keystore.json"
+)
+
+// scrypt parameters recommended for interactive use
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	keyLen  = 32
+)
+
+// keystoreFile is the on-disk format: the secrets map encrypted with
+// AES-256-GCM under a key derived from the passphrase
+type keystoreFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// Keystore is an encrypted file of named secrets
+type Keystore struct {
+	path       string
+	passphrase string
+	secrets    map[string]string
+}
+
+// KeystorePath returns the keystore location from the environment, falling
+// back to DefaultKeystorePath
+func KeystorePath() string {
+	if p := os.Getenv(KeystorePathVar); p != "" {
+		return p
+	}
+	return DefaultKeystorePath
+}
+
+// OpenKeystore decrypts the keystore at path. A missing file is treated as an
+// empty keystore so the first Save creates it.
+func OpenKeystore(path, passphrase string) (*Keystore, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("keystore passphrase is empty (set %s)", KeystorePassphraseVar)
+	}
+
+	ks := &Keystore{path: path, passphrase: passphrase, secrets: make(map[string]string)}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore: %w", err)
+	}
+
+	var file keystoreFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse keystore: %w", err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("unsupported keystore version %d", file.Version)
+	}
+
+	gcm, err := newGCM(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keystore: wrong passphrase or corrupt file")
+	}
+	if err := json.Unmarshal(plain, &ks.secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse keystore contents: %w", err)
+	}
+	return ks, nil
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive keystore key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (ks *Keystore) Get(name string) (string, bool) {
+	val, ok := ks.secrets[name]
+	return val, ok
+}
+
+func (ks *Keystore) Set(name, value string) {
+	ks.secrets[name] = value
+}
+
+func (ks *Keystore) Delete(name string) {
+	delete(ks.secrets, name)
+}
+
+// Names returns the stored secret names in sorted order
+func (ks *Keystore) Names() []string {
+	names := make([]string, 0, len(ks.secrets))
+	for name := range ks.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save encrypts the keystore with a fresh salt and nonce and writes it
+// atomically with owner-only permissions
+func (ks *Keystore) Save() error {
+	plain, err := json.Marshal(ks.secrets)
+	if err != nil {
+		return fmt.Errorf("failed to marshal keystore: %w", err)
+	}
+
+	file := keystoreFile{Version: 1, Salt: make([]byte, 16)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(ks.passphrase, file.Salt)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Data = gcm.Seal(nil, file.Nonce, plain, nil)
+
+	raw, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ks.path), 0755); err != nil {
+		return fmt.Errorf("failed to create keystore directory: %w", err)
+	}
+	tmp := ks.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return fmt.Errorf("failed to write keystore: %w", err)
+	}
+	return os.Rename(tmp, ks.path)
+}
+
+// resolveKeystore looks a secret up by name in the default keystore
+func resolveKeystore(name string) (string, error) {
+	ks, err := OpenKeystore(KeystorePath(), os.Getenv(KeystorePassphraseVar))
+	if err != nil {
+		return "", err
+	}
+	val, ok := ks.Get(name)
+	if !ok {
+		return "", fmt.Errorf("secret %q not found in keystore %s", name, ks.path)
+	}
+	return val, nil
+}
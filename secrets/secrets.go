@@ -0,0 +1,192 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is how long resolved secrets are reused before the provider
+// is asked again
+const DefaultCacheTTL = 5 * time.Minute
+
+// execTimeout bounds how long an exec: command may take to print a secret
+const execTimeout = 30 * time.Second
+
+// Provider resolves the part of a secret reference after the scheme, e.g.
+// "/run/secrets/pg" for "file:/run/secrets/pg"
+type Provider func(value string) (string, error)
+
+type registration struct {
+	provider Provider
+	cache    bool
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// Resolver turns secret references ("<scheme>:<value>") into secret values,
+// caching them so commands and keystores aren't hit on every rule execution
+type Resolver struct {
+	mu        sync.Mutex
+	providers map[string]registration
+	cache     map[string]cachedSecret
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+// NewResolver returns a resolver with the built-in env, file, exec and
+// keystore providers registered
+func NewResolver() *Resolver {
+	r := &Resolver{
+		providers: make(map[string]registration),
+		cache:     make(map[string]cachedSecret),
+		ttl:       DefaultCacheTTL,
+		now:       time.Now,
+	}
+	// Environment lookups are cheap and tests rely on seeing changes
+	// immediately, so they bypass the cache
+	r.Register("env", resolveEnv, false)
+	r.Register("file", resolveFile, true)
+	r.Register("exec", resolveExec, true)
+	r.Register("keystore", resolveKeystore, true)
+	return r
+}
+
+// Register adds or replaces the provider for a scheme
+func (r *Resolver) Register(scheme string, p Provider, cache bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[scheme] = registration{provider: p, cache: cache}
+}
+
+// SetCacheTTL changes how long resolved secrets are cached
+func (r *Resolver) SetCacheTTL(ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ttl = ttl
+}
+
+// ParseRef splits a secret reference into its scheme and value
+func ParseRef(ref string) (scheme string, value string, err error) {
+	scheme, value, ok := strings.Cut(ref, ":")
+	if !ok || scheme == "" || value == "" {
+		// The reference is not echoed back since it may be a pasted credential
+		return "", "", fmt.Errorf("invalid secret reference: expected <provider>:<value>")
+	}
+	return scheme, value, nil
+}
+
+// Validate checks that a reference is well formed and names a known provider
+func (r *Resolver) Validate(ref string) error {
+	scheme, _, err := ParseRef(ref)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.providers[scheme]; !ok {
+		return fmt.Errorf("unknown secret provider %q", scheme)
+	}
+	return nil
+}
+
+// Resolve returns the secret a reference points to
+func (r *Resolver) Resolve(ref string) (string, error) {
+	scheme, value, err := ParseRef(ref)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	reg, ok := r.providers[scheme]
+	if !ok {
+		r.mu.Unlock()
+		return "", fmt.Errorf("unknown secret provider %q", scheme)
+	}
+	if cached, hit := r.cache[ref]; reg.cache && hit && r.now().Before(cached.expires) {
+		r.mu.Unlock()
+		return cached.value, nil
+	}
+	ttl := r.ttl
+	r.mu.Unlock()
+
+	secret, err := reg.provider(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s secret: %w", scheme, err)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("%s secret %q is empty", scheme, value)
+	}
+
+	if reg.cache {
+		r.mu.Lock()
+		r.cache[ref] = cachedSecret{value: secret, expires: r.now().Add(ttl)}
+		r.mu.Unlock()
+	}
+	return secret, nil
+}
+
+// Invalidate drops a cached secret so the next Resolve asks the provider
+// again, e.g. after the database rejected the credential
+func (r *Resolver) Invalidate(ref string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cache, ref)
+}
+
+func resolveEnv(name string) (string, error) {
+	val := os.Getenv(name)
+	if val == "" {
+		return "", fmt.Errorf("environment variable %s not found or empty", name)
+	}
+	return val, nil
+}
+
+// resolveFile reads a secret file such as a Docker or Kubernetes secret mount.
+// Trailing newlines are dropped since most tools add one.
+func resolveFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolveExec runs a command and uses its trimmed stdout as the secret. The
+// command is split on whitespace and run without a shell.
+func resolveExec(command string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", fmt.Errorf("empty command")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("command %q failed: %w: %s", args[0], err, msg)
+		}
+		return "", fmt.Errorf("command %q failed: %w", args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+var Default = NewResolver()
+
+// Expose default resolver functions
+func Resolve(ref string) (string, error) { return Default.Resolve(ref) }
+func Invalidate(ref string)              { Default.Invalidate(ref) }
+func Validate(ref string) error          { return Default.Validate(ref) }
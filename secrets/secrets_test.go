@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pg")
+	assert.NoError(t, os.WriteFile(path, []byte("host=db password=first\n"), 0600))
+
+	r := NewResolver()
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	val, err := r.Resolve("file:" + path)
+	assert.NoError(t, err)
+	assert.Equal(t, "host=db password=first", val)
+
+	// Rotated on disk, but still served from cache
+	assert.NoError(t, os.WriteFile(path, []byte("host=db password=second\n"), 0600))
+	val, err = r.Resolve("file:" + path)
+	assert.NoError(t, err)
+	assert.Equal(t, "host=db password=first", val)
+
+	// Invalidation picks up the rotated value
+	r.Invalidate("file:" + path)
+	val, err = r.Resolve("file:" + path)
+	assert.NoError(t, err)
+	assert.Equal(t, "host=db password=second", val)
+
+	// So does cache expiry
+	assert.NoError(t, os.WriteFile(path, []byte("host=db password=third"), 0600))
+	now = now.Add(DefaultCacheTTL + time.Second)
+	val, err = r.Resolve("file:" + path)
+	assert.NoError(t, err)
+	assert.Equal(t, "host=db password=third", val)
+}
+
+func TestResolveEnv(t *testing.T) {
+	t.Setenv("DATASPY_TEST_SECRET", "from-env")
+
+	val, err := NewResolver().Resolve("env:DATASPY_TEST_SECRET")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-env", val)
+
+	_, err = NewResolver().Resolve("env:DATASPY_TEST_MISSING")
+	assert.Error(t, err)
+}
+
+func TestResolveExec(t *testing.T) {
+	val, err := NewResolver().Resolve("exec:echo from-command")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-command", val)
+
+	_, err = NewResolver().Resolve("exec:false")
+	assert.Error(t, err)
+}
+
+func TestResolveInvalidRef(t *testing.T) {
+	r := NewResolver()
+
+	_, err := r.Resolve("host=localhost password=hunter2")
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "hunter2")
+
+	err = r.Validate("vault:secret/pg")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown secret provider "vault"`)
+}
+
+func TestKeystore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+
+	ks, err := OpenKeystore(path, "correct horse")
+	assert.NoError(t, err)
+	ks.Set("pg", "host=db password=secret")
+	ks.Set("mysql", "root:secret@tcp(db)/app")
+	assert.NoError(t, ks.Save())
+
+	raw, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(raw), "secret")
+
+	ks, err = OpenKeystore(path, "correct horse")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mysql", "pg"}, ks.Names())
+	val, ok := ks.Get("pg")
+	assert.True(t, ok)
+	assert.Equal(t, "host=db password=secret", val)
+
+	_, err = OpenKeystore(path, "wrong")
+	assert.Error(t, err)
+
+	t.Setenv(KeystorePathVar, path)
+	t.Setenv(KeystorePassphraseVar, "correct horse")
+	val, err = NewResolver().Resolve("keystore:mysql")
+	assert.NoError(t, err)
+	assert.Equal(t, "root:secret@tcp(db)/app", val)
+}
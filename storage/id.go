@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet used by ULIDs. It is in ASCII
+// order, so encoded IDs sort the same way as the values they encode.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// IDLength is the length of an encoded ID: 10 characters of millisecond
+// timestamp followed by 16 characters of randomness.
+const IDLength = 26
+
+var idGen = &idGenerator{}
+
+// idGenerator produces ULID-style IDs. IDs created within the same
+// millisecond increment the random part so they stay strictly ordered.
+type idGenerator struct {
+	mu       sync.Mutex
+	lastMs   uint64
+	lastRand [10]byte
+}
+
+// NewID returns a new time-sortable ID for an execution or batch
+func NewID() string {
+	return NewIDAt(time.Now())
+}
+
+// NewIDAt returns a new ID whose timestamp component is t
+func NewIDAt(t time.Time) string {
+	return idGen.next(t)
+}
+
+func (g *idGenerator) next(t time.Time) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(t.UnixMilli())
+	if ms == g.lastMs {
+		incrementRandom(&g.lastRand)
+	} else {
+		if _, err := rand.Read(g.lastRand[:]); err != nil {
+			panic(fmt.Sprintf("failed to read random bytes: %v", err))
+		}
+		g.lastMs = ms
+	}
+
+	return encodeID(ms, g.lastRand)
+}
+
+// incrementRandom adds one to the 80-bit random component, carrying as needed
+func incrementRandom(r *[10]byte) {
+	for i := len(r) - 1; i >= 0; i-- {
+		r[i]++
+		if r[i] != 0 {
+			return
+		}
+	}
+}
+
+func encodeID(ms uint64, random [10]byte) string {
+	var out [IDLength]byte
+
+	// 48-bit timestamp into 10 characters (the first holds only 3 bits)
+	for i := 9; i >= 0; i-- {
+		out[i] = crockford[ms&0x1F]
+		ms >>= 5
+	}
+
+	// 80-bit randomness into 16 characters, 5 bits at a time
+	var hi uint64 = uint64(random[0])<<32 | uint64(random[1])<<24 | uint64(random[2])<<16 |
+		uint64(random[3])<<8 | uint64(random[4])
+	var lo uint64 = uint64(random[5])<<32 | uint64(random[6])<<24 | uint64(random[7])<<16 |
+		uint64(random[8])<<8 | uint64(random[9])
+	for i := 17; i >= 10; i-- {
+		out[i] = crockford[hi&0x1F]
+		hi >>= 5
+	}
+	for i := 25; i >= 18; i-- {
+		out[i] = crockford[lo&0x1F]
+		lo >>= 5
+	}
+
+	return string(out[:])
+}
+
+// IDTime returns the timestamp encoded in an ID
+func IDTime(id string) (time.Time, error) {
+	if len(id) != IDLength {
+		return time.Time{}, fmt.Errorf("invalid id %q: expected %d characters", id, IDLength)
+	}
+
+	var ms uint64
+	for _, c := range strings.ToUpper(id[:10]) {
+		idx := strings.IndexRune(crockford, c)
+		if idx < 0 {
+			return time.Time{}, fmt.Errorf("invalid id %q: unexpected character %q", id, c)
+		}
+		ms = ms<<5 | uint64(idx)
+	}
+
+	return time.UnixMilli(int64(ms)), nil
+}
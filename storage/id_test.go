@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNewIDSortable(t *testing.T) {
+	ids := make([]string, 1000)
+	for i := range ids {
+		ids[i] = NewID()
+	}
+
+	if !sort.StringsAreSorted(ids) {
+		t.Fatal("Expected IDs generated in sequence to be sorted")
+	}
+
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if len(id) != IDLength {
+			t.Fatalf("Expected ID length %d, got %d (%s)", IDLength, len(id), id)
+		}
+		if seen[id] {
+			t.Fatalf("Duplicate ID generated: %s", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestIDTime(t *testing.T) {
+	ts := time.Date(2024, 3, 15, 10, 30, 45, 123000000, time.UTC)
+	id := NewIDAt(ts)
+
+	got, err := IDTime(id)
+	if err != nil {
+		t.Fatalf("Failed to decode ID time: %v", err)
+	}
+	if !got.Equal(ts) {
+		t.Errorf("Expected time %v, got %v", ts, got)
+	}
+
+	earlier := NewIDAt(ts.Add(-time.Millisecond))
+	if earlier >= id {
+		t.Errorf("Expected %s to sort before %s", earlier, id)
+	}
+
+	if _, err := IDTime("not-an-id"); err == nil {
+		t.Error("Expected error for invalid ID")
+	}
+}
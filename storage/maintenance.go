@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaintenanceWindow is a window opened from the command line rather than
+// configured. It covers Start to End; with no Servers or Rules it applies to
+// every rule.
+type MaintenanceWindow struct {
+	Name      string    `json:"name"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Mode      string    `json:"mode,omitempty"`
+	Servers   []string  `json:"servers,omitempty"`
+	Rules     []string  `json:"rules,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MaintenanceWindows holds ad-hoc maintenance windows in a JSON file beside
+// the database. They're kept out of the database because a running daemon
+// holds it locked, and windows are opened and closed while it runs; the
+// daemon checks the file each time it looks for one, and only parses it
+// again when it has changed.
+type MaintenanceWindows struct {
+	path string
+
+	mu sync.Mutex
+	// cached is the file as last parsed, which had modTime and size
+	cached  []MaintenanceWindow
+	modTime time.Time
+	size    int64
+}
+
+// NewMaintenanceWindows returns the windows kept beside the database at
+// dbPath, e.g. data/dataspy.maintenance.json for data/dataspy.db
+func NewMaintenanceWindows(dbPath string) *MaintenanceWindows {
+	return &MaintenanceWindows{path: strings.TrimSuffix(dbPath, filepath.Ext(dbPath)) + ".maintenance.json"}
+}
+
+// Save stores a window, replacing any with the same name. Windows that have
+// ended are dropped from the file.
+func (m *MaintenanceWindows) Save(w MaintenanceWindow) error {
+	windows, err := m.List()
+	if err != nil {
+		return err
+	}
+	if w.CreatedAt.IsZero() {
+		w.CreatedAt = time.Now()
+	}
+	windows = slices.DeleteFunc(windows, func(existing MaintenanceWindow) bool { return existing.Name == w.Name })
+	return m.write(append(windows, w))
+}
+
+// Delete removes a window, reporting whether there was one to remove.
+// Windows that have ended are dropped from the file.
+func (m *MaintenanceWindows) Delete(name string) (bool, error) {
+	windows, err := m.List()
+	if err != nil {
+		return false, err
+	}
+	kept := slices.DeleteFunc(windows, func(w MaintenanceWindow) bool { return w.Name == name })
+	if len(kept) == len(windows) {
+		return false, nil
+	}
+	return true, m.write(kept)
+}
+
+// List returns every stored window, ordered by name, including ones that
+// have ended since the file was last written
+func (m *MaintenanceWindows) List() ([]MaintenanceWindow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	info, err := os.Stat(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		m.cached, m.modTime, m.size = nil, time.Time{}, 0
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read maintenance windows: %w", err)
+	}
+	if m.cached != nil && info.ModTime().Equal(m.modTime) && info.Size() == m.size {
+		return slices.Clone(m.cached), nil
+	}
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read maintenance windows: %w", err)
+	}
+	var windows []MaintenanceWindow
+	if err := json.Unmarshal(data, &windows); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal maintenance windows: %w", err)
+	}
+	slices.SortFunc(windows, func(a, b MaintenanceWindow) int { return strings.Compare(a.Name, b.Name) })
+	// An empty file caches as empty rather than nil, so it isn't read again
+	m.cached, m.modTime, m.size = append([]MaintenanceWindow{}, windows...), info.ModTime(), info.Size()
+	return windows, nil
+}
+
+// write replaces the file through a rename, so a reader never sees it half
+// written. Windows that have ended are left out.
+func (m *MaintenanceWindows) write(windows []MaintenanceWindow) error {
+	now := time.Now()
+	windows = slices.DeleteFunc(windows, func(w MaintenanceWindow) bool { return !w.End.After(now) })
+
+	if err := ensureDir(m.path); err != nil {
+		return fmt.Errorf("failed to create maintenance window directory: %w", err)
+	}
+	data, err := json.MarshalIndent(windows, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal maintenance windows: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".maintenance-*")
+	if err != nil {
+		return fmt.Errorf("failed to write maintenance windows: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write maintenance windows: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write maintenance windows: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		return fmt.Errorf("failed to write maintenance windows: %w", err)
+	}
+	return nil
+}
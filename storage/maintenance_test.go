@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindows(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Windows are opened and closed by another process while a daemon
+	// holds the store, which a second store couldn't open
+	if _, err := NewStore(dbPath); err == nil {
+		t.Fatal("Expected a second store to fail while the first holds the file")
+	}
+	windows := NewMaintenanceWindows(dbPath)
+
+	start := time.Now().Truncate(time.Second)
+	for _, w := range []MaintenanceWindow{
+		{Name: "upgrade", Start: start, End: start.Add(time.Hour), Servers: []string{"pg"}},
+		{Name: "backfill", Start: start, End: start.Add(2 * time.Hour), Mode: "mute", Reason: "reloading orders"},
+	} {
+		if err := windows.Save(w); err != nil {
+			t.Fatalf("Failed to save maintenance window: %v", err)
+		}
+	}
+
+	listed, err := store.Maintenance().List()
+	if err != nil {
+		t.Fatalf("Failed to list maintenance windows: %v", err)
+	}
+	if len(listed) != 2 || listed[0].Name != "backfill" || listed[1].Name != "upgrade" {
+		t.Fatalf("Expected backfill and upgrade windows, got %+v", listed)
+	}
+	if listed[0].Reason != "reloading orders" || listed[0].CreatedAt.IsZero() {
+		t.Errorf("Expected reason and creation time to be stored, got %+v", listed[0])
+	}
+
+	// Saving under the same name replaces the window
+	if err := windows.Save(MaintenanceWindow{Name: "upgrade", Start: start, End: start.Add(3 * time.Hour)}); err != nil {
+		t.Fatalf("Failed to save maintenance window: %v", err)
+	}
+	listed, _ = store.Maintenance().List()
+	if len(listed) != 2 || !listed[1].End.Equal(start.Add(3*time.Hour)) {
+		t.Errorf("Expected upgrade window to be replaced, got %+v", listed)
+	}
+
+	deleted, err := windows.Delete("upgrade")
+	if err != nil || !deleted {
+		t.Fatalf("Expected window to be deleted, got deleted=%v err=%v", deleted, err)
+	}
+	if deleted, _ := windows.Delete("upgrade"); deleted {
+		t.Error("Expected second delete to report nothing removed")
+	}
+	listed, _ = store.Maintenance().List()
+	if len(listed) != 1 || listed[0].Name != "backfill" {
+		t.Errorf("Expected only the backfill window to remain, got %+v", listed)
+	}
+
+	// Rewriting the file drops windows that have ended
+	if err := windows.Save(MaintenanceWindow{Name: "over", Start: start.Add(-2 * time.Hour), End: start.Add(-time.Hour)}); err != nil {
+		t.Fatalf("Failed to save maintenance window: %v", err)
+	}
+	if err := windows.Save(MaintenanceWindow{Name: "upgrade", Start: start, End: start.Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to save maintenance window: %v", err)
+	}
+	listed, _ = store.Maintenance().List()
+	if len(listed) != 2 || listed[0].Name != "backfill" || listed[1].Name != "upgrade" {
+		t.Errorf("Expected the ended window to be dropped, got %+v", listed)
+	}
+}
+
+func TestMaintenanceWindowsCache(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	windows := NewMaintenanceWindows(dbPath)
+	start := time.Now()
+	if err := windows.Save(MaintenanceWindow{Name: "upgrade", Start: start, End: start.Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to save maintenance window: %v", err)
+	}
+
+	reader := NewMaintenanceWindows(dbPath)
+	first, err := reader.List()
+	if err != nil || len(first) != 1 {
+		t.Fatalf("Expected one window, got %+v err=%v", first, err)
+	}
+
+	// An unchanged file is served from the cache, even if it can no longer
+	// be parsed
+	info, err := os.Stat(windows.path)
+	if err != nil {
+		t.Fatalf("Failed to stat maintenance windows: %v", err)
+	}
+	garbage := bytes.Repeat([]byte{'x'}, int(info.Size()))
+	if err := os.WriteFile(windows.path, garbage, 0644); err != nil {
+		t.Fatalf("Failed to overwrite maintenance windows: %v", err)
+	}
+	if err := os.Chtimes(windows.path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("Failed to reset modification time: %v", err)
+	}
+	if cached, err := reader.List(); err != nil || len(cached) != 1 {
+		t.Errorf("Expected the cached window, got %+v err=%v", cached, err)
+	}
+
+	// A changed file is read again
+	if err := os.Remove(windows.path); err != nil {
+		t.Fatalf("Failed to remove maintenance windows: %v", err)
+	}
+	if listed, err := reader.List(); err != nil || len(listed) != 0 {
+		t.Errorf("Expected no windows once the file is gone, got %+v err=%v", listed, err)
+	}
+	if err := windows.Save(MaintenanceWindow{Name: "backfill", Start: start, End: start.Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to save maintenance window: %v", err)
+	}
+	if listed, err := reader.List(); err != nil || len(listed) != 1 || listed[0].Name != "backfill" {
+		t.Errorf("Expected the rewritten file to be read again, got %+v err=%v", listed, err)
+	}
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Kinds of queued change
+const (
+	ChangeSetWatermark   = "set_watermark"
+	ChangeResetWatermark = "reset_watermark"
+	ChangePinSchema      = "pin_schema"
+	ChangeUnpinSchema    = "unpin_schema"
+)
+
+// QueuedChange is a change to rule metadata made while another process, such
+// as the daemon, held the store. That process applies it before its next run,
+// so a queued pin takes the snapshot that's latest then.
+type QueuedChange struct {
+	ID         string `json:"id"`
+	Kind       string `json:"kind"`
+	RuleName   string `json:"rule_name"`
+	ServerName string `json:"server_name,omitempty"`
+	// Value is the watermark to set
+	Value time.Time `json:"value,omitempty"`
+}
+
+func (c QueuedChange) String() string {
+	server := c.ServerName
+	if server == "" {
+		server = "all servers"
+	}
+	switch c.Kind {
+	case ChangeSetWatermark:
+		return fmt.Sprintf("set watermark of %s on %s to %s", c.RuleName, server, c.Value.Format(time.RFC3339))
+	case ChangeResetWatermark:
+		return fmt.Sprintf("reset watermark of %s on %s", c.RuleName, server)
+	case ChangePinSchema:
+		return fmt.Sprintf("pin schema snapshot of %s on %s", c.RuleName, server)
+	case ChangeUnpinSchema:
+		return fmt.Sprintf("unpin schema snapshot of %s on %s", c.RuleName, server)
+	default:
+		return fmt.Sprintf("%s of %s on %s", c.Kind, c.RuleName, server)
+	}
+}
+
+// ChangeQueue holds queued changes in a directory beside the database, one
+// file each, so queuing a change never rewrites one another process is
+// applying
+type ChangeQueue struct {
+	dir string
+}
+
+// NewChangeQueue returns the queue kept beside the database at dbPath, e.g.
+// data/dataspy.queue for data/dataspy.db
+func NewChangeQueue(dbPath string) *ChangeQueue {
+	return &ChangeQueue{dir: strings.TrimSuffix(dbPath, filepath.Ext(dbPath)) + ".queue"}
+}
+
+// Add queues a change, giving it an ID when it has none
+func (q *ChangeQueue) Add(c QueuedChange) error {
+	if c.ID == "" {
+		c.ID = NewID()
+	}
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create change queue directory: %w", err)
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal queued change: %w", err)
+	}
+	// Written through a rename, so List never reads a file half written
+	tmp, err := os.CreateTemp(q.dir, ".change-*")
+	if err != nil {
+		return fmt.Errorf("failed to queue change: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to queue change: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to queue change: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(q.dir, c.ID+".json")); err != nil {
+		return fmt.Errorf("failed to queue change: %w", err)
+	}
+	return nil
+}
+
+// List returns the queued changes in the order they were queued
+func (q *ChangeQueue) List() ([]QueuedChange, error) {
+	entries, err := os.ReadDir(q.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read change queue: %w", err)
+	}
+	var changes []QueuedChange
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(q.dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read queued change: %w", err)
+		}
+		var c QueuedChange
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal queued change %s: %w", e.Name(), err)
+		}
+		changes = append(changes, c)
+	}
+	// IDs are time-sortable
+	slices.SortFunc(changes, func(a, b QueuedChange) int { return strings.Compare(a.ID, b.ID) })
+	return changes, nil
+}
+
+// Remove takes a change off the queue
+func (q *ChangeQueue) Remove(id string) error {
+	if err := os.Remove(filepath.Join(q.dir, id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove queued change: %w", err)
+	}
+	return nil
+}
+
+// ApplyQueued applies the changes queued for the store, in order, and takes
+// them off the queue. A change that fails is dropped too, so it isn't
+// retried before every run; its error is returned along with the others.
+func (s *Store) ApplyQueued() ([]QueuedChange, error) {
+	changes, err := s.queue.List()
+	if err != nil {
+		return nil, err
+	}
+	var applied []QueuedChange
+	var errs []error
+	for _, c := range changes {
+		if err := s.applyChange(c); err != nil {
+			errs = append(errs, fmt.Errorf("failed to %s: %w", c, err))
+		} else {
+			applied = append(applied, c)
+		}
+		if err := s.queue.Remove(c.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return applied, errors.Join(errs...)
+}
+
+func (s *Store) applyChange(c QueuedChange) error {
+	switch c.Kind {
+	case ChangeSetWatermark:
+		return s.SetWatermark(Watermark{RuleName: c.RuleName, ServerName: c.ServerName, Value: c.Value})
+	case ChangeResetWatermark:
+		n, err := s.ResetWatermarks(c.RuleName, c.ServerName)
+		if err == nil && n == 0 {
+			err = fmt.Errorf("no watermark found")
+		}
+		return err
+	case ChangePinSchema:
+		_, err := s.PinSchemaSnapshot(c.RuleName, c.ServerName)
+		return err
+	case ChangeUnpinSchema:
+		unpinned, err := s.UnpinSchemaSnapshot(c.RuleName, c.ServerName)
+		if err == nil && !unpinned {
+			err = fmt.Errorf("no pinned schema snapshot")
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown change %q", c.Kind)
+	}
+}
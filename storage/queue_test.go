@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChangeQueue(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// A second process can't open the store while the first holds it, so it
+	// queues its changes instead
+	if _, err := NewStore(dbPath); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrLocked while the first store holds the file, got %v", err)
+	}
+	queue := NewChangeQueue(dbPath)
+
+	t1 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, server := range []string{"pg", "mysql"} {
+		if err := store.AdvanceWatermark("orders", server, t1.Add(time.Hour), "exec-1"); err != nil {
+			t.Fatalf("Failed to advance watermark: %v", err)
+		}
+	}
+	for _, c := range []QueuedChange{
+		{Kind: ChangeSetWatermark, RuleName: "orders", ServerName: "pg", Value: t1},
+		{Kind: ChangeResetWatermark, RuleName: "orders", ServerName: "mysql"},
+		{Kind: ChangeResetWatermark, RuleName: "missing"},
+	} {
+		if err := queue.Add(c); err != nil {
+			t.Fatalf("Failed to queue change: %v", err)
+		}
+	}
+
+	queued, err := store.Queue().List()
+	if err != nil {
+		t.Fatalf("Failed to list queued changes: %v", err)
+	}
+	if len(queued) != 3 || queued[0].Kind != ChangeSetWatermark || queued[2].RuleName != "missing" {
+		t.Fatalf("Expected three changes in the order queued, got %+v", queued)
+	}
+
+	applied, err := store.ApplyQueued()
+	if len(applied) != 2 {
+		t.Errorf("Expected two changes applied, got %+v", applied)
+	}
+	if err == nil || err.Error() != "failed to reset watermark of missing on all servers: no watermark found" {
+		t.Errorf("Expected the reset of missing to fail, got %v", err)
+	}
+	wm, _, _ := store.GetWatermark("orders", "pg")
+	if !wm.Value.Equal(t1) || wm.ExecutionID != "" {
+		t.Errorf("Expected queued watermark %v, got %v from %q", t1, wm.Value, wm.ExecutionID)
+	}
+	if _, found, _ := store.GetWatermark("orders", "mysql"); found {
+		t.Error("Expected queued reset to remove the mysql watermark")
+	}
+
+	// Failed changes are dropped along with the applied ones
+	if queued, _ := store.Queue().List(); len(queued) != 0 {
+		t.Errorf("Expected an empty queue, got %+v", queued)
+	}
+	if applied, err := store.ApplyQueued(); len(applied) != 0 || err != nil {
+		t.Errorf("Expected nothing to apply, got %+v err=%v", applied, err)
+	}
+}
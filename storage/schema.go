@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Key prefixes for schema snapshots in the rule metadata bucket: the latest
+// snapshot taken by a schema check, and the one pinned as its baseline
+const (
+	schemaSnapshotPrefix = "schema\x00"
+	schemaPinPrefix      = "schema-pin\x00"
+)
+
+// SchemaObject is a column or index of a checked table
+type SchemaObject struct {
+	Table      string `json:"table"`
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+// SchemaSnapshot is the schema of a rule's tables on a server, as seen by
+// one run of a schema check
+type SchemaSnapshot struct {
+	RuleName    string         `json:"rule_name"`
+	ServerName  string         `json:"server_name"`
+	ExecutionID string         `json:"execution_id"`
+	TakenAt     time.Time      `json:"taken_at"`
+	Objects     []SchemaObject `json:"objects"`
+}
+
+func schemaKey(prefix, ruleName, serverName string) []byte {
+	return []byte(prefix + ruleName + "\x00" + serverName)
+}
+
+// GetSchemaSnapshot returns the latest snapshot of a rule on a server, and
+// false when none has been taken yet
+func (s *Store) GetSchemaSnapshot(ruleName, serverName string) (SchemaSnapshot, bool, error) {
+	return s.getSchema(schemaKey(schemaSnapshotPrefix, ruleName, serverName))
+}
+
+// GetPinnedSchema returns the snapshot pinned as a rule's baseline on a
+// server, and false when none is pinned
+func (s *Store) GetPinnedSchema(ruleName, serverName string) (SchemaSnapshot, bool, error) {
+	return s.getSchema(schemaKey(schemaPinPrefix, ruleName, serverName))
+}
+
+func (s *Store) getSchema(key []byte) (SchemaSnapshot, bool, error) {
+	var snap SchemaSnapshot
+	var found bool
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket([]byte(RuleMetadataBucket)).Get(key)
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &snap)
+	})
+
+	if err != nil {
+		return SchemaSnapshot{}, false, fmt.Errorf("failed to get schema snapshot: %w", err)
+	}
+	return snap, found, nil
+}
+
+// SaveSchemaSnapshot stores a snapshot as the latest for its rule and server
+func (s *Store) SaveSchemaSnapshot(snap SchemaSnapshot) error {
+	value, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schema snapshot: %w", err)
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(RuleMetadataBucket)).Put(schemaKey(schemaSnapshotPrefix, snap.RuleName, snap.ServerName), value)
+	})
+}
+
+// PinSchemaSnapshot pins the latest snapshot of a rule on a server as its
+// baseline, so later runs report drift from it rather than from the run
+// before. It returns the pinned snapshot.
+func (s *Store) PinSchemaSnapshot(ruleName, serverName string) (SchemaSnapshot, error) {
+	var snap SchemaSnapshot
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(RuleMetadataBucket))
+		v := b.Get(schemaKey(schemaSnapshotPrefix, ruleName, serverName))
+		if v == nil {
+			return fmt.Errorf("no schema snapshot for rule %s on %s", ruleName, serverName)
+		}
+		if err := json.Unmarshal(v, &snap); err != nil {
+			return fmt.Errorf("failed to unmarshal schema snapshot: %w", err)
+		}
+		return b.Put(schemaKey(schemaPinPrefix, ruleName, serverName), v)
+	})
+	return snap, err
+}
+
+// UnpinSchemaSnapshot removes a rule's pinned baseline on a server. It
+// reports whether one was pinned.
+func (s *Store) UnpinSchemaSnapshot(ruleName, serverName string) (bool, error) {
+	var deleted bool
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(RuleMetadataBucket))
+		key := schemaKey(schemaPinPrefix, ruleName, serverName)
+		if b.Get(key) == nil {
+			return nil
+		}
+		deleted = true
+		return b.Delete(key)
+	})
+	return deleted, err
+}
+
+// ListSchemaSnapshots returns the latest snapshot of every rule and server,
+// ordered by rule then server
+func (s *Store) ListSchemaSnapshots() ([]SchemaSnapshot, error) {
+	var snaps []SchemaSnapshot
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(RuleMetadataBucket)).Cursor()
+		prefix := []byte(schemaSnapshotPrefix)
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var snap SchemaSnapshot
+			if err := json.Unmarshal(v, &snap); err != nil {
+				return fmt.Errorf("failed to unmarshal schema snapshot: %w", err)
+			}
+			snaps = append(snaps, snap)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schema snapshots: %w", err)
+	}
+	return snaps, nil
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSchemaSnapshots(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	if _, err := store.PinSchemaSnapshot("orders-schema", "pg"); err == nil {
+		t.Fatal("Expected an error pinning a rule with no snapshot")
+	}
+
+	first := SchemaSnapshot{
+		RuleName:    "orders-schema",
+		ServerName:  "pg",
+		ExecutionID: "exec-1",
+		TakenAt:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Objects:     []SchemaObject{{Table: "orders", Type: "column", Name: "id", Definition: "integer NOT NULL"}},
+	}
+	if err := store.SaveSchemaSnapshot(first); err != nil {
+		t.Fatalf("Failed to save snapshot: %v", err)
+	}
+	if _, err := store.PinSchemaSnapshot("orders-schema", "pg"); err != nil {
+		t.Fatalf("Failed to pin snapshot: %v", err)
+	}
+
+	second := first
+	second.ExecutionID = "exec-2"
+	second.Objects = nil
+	if err := store.SaveSchemaSnapshot(second); err != nil {
+		t.Fatalf("Failed to save snapshot: %v", err)
+	}
+
+	latest, found, err := store.GetSchemaSnapshot("orders-schema", "pg")
+	if err != nil || !found || latest.ExecutionID != "exec-2" {
+		t.Errorf("Expected latest snapshot from exec-2, got %+v found=%v err=%v", latest, found, err)
+	}
+	pinned, found, err := store.GetPinnedSchema("orders-schema", "pg")
+	if err != nil || !found || pinned.ExecutionID != "exec-1" || len(pinned.Objects) != 1 {
+		t.Errorf("Expected pinned snapshot from exec-1, got %+v found=%v err=%v", pinned, found, err)
+	}
+
+	// Pinned snapshots aren't listed alongside the latest ones
+	all, err := store.ListSchemaSnapshots()
+	if err != nil || len(all) != 1 {
+		t.Errorf("Expected one snapshot, got %d (err=%v)", len(all), err)
+	}
+
+	unpinned, err := store.UnpinSchemaSnapshot("orders-schema", "pg")
+	if err != nil || !unpinned {
+		t.Fatalf("Expected to unpin, got %v err=%v", unpinned, err)
+	}
+	if _, found, _ := store.GetPinnedSchema("orders-schema", "pg"); found {
+		t.Error("Expected no pinned snapshot after unpinning")
+	}
+	if unpinned, _ := store.UnpinSchemaSnapshot("orders-schema", "pg"); unpinned {
+		t.Error("Expected unpinning twice to report nothing pinned")
+	}
+
+	// Pins queued while the daemon holds the store take the snapshot that's
+	// latest when they're applied
+	queue := store.Queue()
+	if err := queue.Add(QueuedChange{Kind: ChangePinSchema, RuleName: "orders-schema", ServerName: "pg"}); err != nil {
+		t.Fatalf("Failed to queue pin: %v", err)
+	}
+	if _, err := store.ApplyQueued(); err != nil {
+		t.Fatalf("Failed to apply queued pin: %v", err)
+	}
+	if pinned, found, _ := store.GetPinnedSchema("orders-schema", "pg"); !found || pinned.ExecutionID != "exec-2" {
+		t.Errorf("Expected queued pin of exec-2, got %+v found=%v", pinned, found)
+	}
+	if err := queue.Add(QueuedChange{Kind: ChangeUnpinSchema, RuleName: "orders-schema", ServerName: "pg"}); err != nil {
+		t.Fatalf("Failed to queue unpin: %v", err)
+	}
+	if _, err := store.ApplyQueued(); err != nil {
+		t.Fatalf("Failed to apply queued unpin: %v", err)
+	}
+	if _, found, _ := store.GetPinnedSchema("orders-schema", "pg"); found {
+		t.Error("Expected no pinned snapshot after the queued unpin")
+	}
+}
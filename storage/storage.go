@@ -3,9 +3,11 @@ package storage
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"go.etcd.io/bbolt"
@@ -16,21 +18,99 @@ const (
 	RuleMetadataBucket     = "rule_metadata"
 )
 
+// Execution statuses
+const (
+	StatusSuccess   = "success"
+	StatusViolation = "violation"
+	StatusError     = "error"
+	// StatusSkipped means the rule didn't run, because a rule it depends on
+	// didn't pass, its server was down or a maintenance window was open
+	StatusSkipped = "skipped"
+)
+
+// ErrLocked is returned by NewStore when another process, usually the
+// daemon, holds the database open
+var ErrLocked = errors.New("database is in use by another dataspy process, such as a running daemon")
+
 type Store struct {
-	db *bbolt.DB
+	db          *bbolt.DB
+	maintenance *MaintenanceWindows
+	queue       *ChangeQueue
 }
 
 type ExecutionRecord struct {
-	RuleName     string    `json:"rule_name"`
-	ServerName   string    `json:"server_name"`
-	StartTime    time.Time `json:"start_time"`
-	EndTime      time.Time `json:"end_time"`
-	Status       string    `json:"status"`
-	Result       string    `json:"result"`
-	Error        string    `json:"error,omitempty"`
-	Description  string    `json:"description"`
-	Duration     float64   `json:"duration_ms"`
-	RowsAffected int64     `json:"rows_affected"`
+	ExecutionID string    `json:"execution_id"`
+	BatchID     string    `json:"batch_id,omitempty"`
+	RuleName    string    `json:"rule_name"`
+	ServerName  string    `json:"server_name"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	Status      string    `json:"status"`
+	Severity    string    `json:"severity,omitempty"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"`
+	SkipReason  string    `json:"skip_reason,omitempty"`
+	// MutedBy names the maintenance window the rule ran in, whose failures
+	// aren't reported
+	MutedBy string `json:"muted_by,omitempty"`
+	// MuteReason is the Reason of the window in MutedBy
+	MuteReason   string  `json:"mute_reason,omitempty"`
+	Description  string  `json:"description"`
+	Duration     float64 `json:"duration_ms"`
+	RowsAffected int64   `json:"rows_affected"`
+	// Metric and Expected are set for baseline rules: the value the query
+	// returned and what history predicted
+	Metric   *float64 `json:"metric,omitempty"`
+	Expected *float64 `json:"expected,omitempty"`
+	// Anomaly says why a result that isn't judged by its row count is a
+	// violation: an anomalous baseline metric, a profile's failed
+	// expectations, or an Expression that didn't hold
+	Anomaly string `json:"anomaly,omitempty"`
+	// Profile holds the column statistics computed by profile rules
+	Profile *Profile `json:"profile,omitempty"`
+	// Phases are how long each of the rule's setup, query and teardown
+	// phases took, in the order they ran
+	Phases []PhaseTiming `json:"phases,omitempty"`
+	// Attempts are each run of a rule that was retried after a transient
+	// failure, the last being the one recorded above
+	Attempts []Attempt `json:"attempts,omitempty"`
+}
+
+// Attempt is one run of a retried rule
+type Attempt struct {
+	Duration float64 `json:"duration_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// PhaseTiming is how long one phase of a rule took
+type PhaseTiming struct {
+	Phase    string  `json:"phase"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// Profile is the statistics of a table's columns at one run
+type Profile struct {
+	RowCount int64           `json:"row_count"`
+	Columns  []ColumnProfile `json:"columns"`
+}
+
+// ColumnProfile is the statistics of one column. Min and Max are as the
+// database formats them; ratios are 0 when there is nothing to divide by.
+type ColumnProfile struct {
+	Name          string       `json:"name"`
+	NullCount     int64        `json:"null_count"`
+	NullRatio     float64      `json:"null_ratio"`
+	DistinctCount int64        `json:"distinct_count"`
+	DistinctRatio float64      `json:"distinct_ratio"`
+	Min           string       `json:"min,omitempty"`
+	Max           string       `json:"max,omitempty"`
+	TopValues     []ValueCount `json:"top_values,omitempty"`
+}
+
+// ValueCount is one of a column's most common values
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
 }
 
 // Helper function to ensure directory exists
@@ -49,6 +129,9 @@ func NewStore(dbPath string) (*Store, error) {
 	db, err := bbolt.Open(dbPath, 0600, &bbolt.Options{
 		Timeout: 1 * time.Second,
 	})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, fmt.Errorf("failed to open database %s: %w", dbPath, ErrLocked)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -68,7 +151,63 @@ func NewStore(dbPath string) (*Store, error) {
 		return nil, fmt.Errorf("failed to initialize buckets: %w", err)
 	}
 
-	return &Store{db: db}, nil
+	if err := migrateLegacyKeys(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate execution history: %w", err)
+	}
+
+	return &Store{db: db, maintenance: NewMaintenanceWindows(dbPath), queue: NewChangeQueue(dbPath)}, nil
+}
+
+// Maintenance returns the ad-hoc maintenance windows kept beside the store
+func (s *Store) Maintenance() *MaintenanceWindows {
+	return s.maintenance
+}
+
+// Queue returns the changes queued for the store by other processes
+func (s *Store) Queue() *ChangeQueue {
+	return s.queue
+}
+
+// migrateLegacyKeys re-keys records saved before execution IDs existed.
+// Those used "<unixnano>-<rule>-<server>" keys, which sort after every ID.
+// They can be as long as an ID, but IDs never contain a dash.
+func migrateLegacyKeys(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(ExecutionHistoryBucket))
+
+		var legacyKeys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if bytes.IndexByte(k, '-') >= 0 {
+				legacyKeys = append(legacyKeys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range legacyKeys {
+			var record ExecutionRecord
+			if err := json.Unmarshal(b.Get(k), &record); err != nil {
+				return fmt.Errorf("failed to unmarshal record %s: %w", k, err)
+			}
+			if record.ExecutionID == "" {
+				record.ExecutionID = NewIDAt(record.StartTime)
+			}
+
+			value, err := json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("failed to marshal record: %w", err)
+			}
+			if err := b.Put([]byte(record.ExecutionID), value); err != nil {
+				return err
+			}
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 func (s *Store) Close() error {
@@ -79,15 +218,17 @@ func (s *Store) SaveExecutionRecord(record *ExecutionRecord) error {
 	return s.db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(ExecutionHistoryBucket))
 
-		// Generate a key using timestamp and rule name for ordering
-		key := fmt.Sprintf("%d-%s-%s", record.StartTime.UnixNano(), record.RuleName, record.ServerName)
+		// Execution IDs are time-sortable, so they double as ordered keys
+		if record.ExecutionID == "" {
+			record.ExecutionID = NewIDAt(record.StartTime)
+		}
 
 		value, err := json.Marshal(record)
 		if err != nil {
 			return fmt.Errorf("failed to marshal record: %w", err)
 		}
 
-		return b.Put([]byte(key), value)
+		return b.Put([]byte(record.ExecutionID), value)
 	})
 }
 
@@ -124,16 +265,107 @@ func (s *Store) GetExecutionsByRule(ruleName string) ([]ExecutionRecord, error)
 		b := tx.Bucket([]byte(ExecutionHistoryBucket))
 		c := b.Cursor()
 
-		searchTerm := "-" + ruleName + "-"
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if !bytes.Contains(k, []byte(searchTerm)) {
+			var record ExecutionRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return fmt.Errorf("failed to unmarshal record: %w", err)
+			}
+			if record.RuleName != ruleName {
+				continue
+			}
+			records = append(records, record)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get executions by rule: %w", err)
+	}
+
+	return records, nil
+}
+
+// GetMetricHistory returns the metrics recorded by a rule's successful runs
+// on a server that started at or after since, oldest first. With a positive
+// limit only the most recent limit are returned. The scan walks back from
+// the newest record and stops at since, so it doesn't read older history.
+func (s *Store) GetMetricHistory(ruleName, serverName string, since time.Time, limit int) ([]ExecutionRecord, error) {
+	var records []ExecutionRecord
+	boundary := []byte(encodeID(uint64(since.UnixMilli()), [10]byte{}))
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(ExecutionHistoryBucket))
+		c := b.Cursor()
+
+		for k, v := c.Last(); k != nil && bytes.Compare(k, boundary) >= 0; k, v = c.Prev() {
+			var record ExecutionRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return fmt.Errorf("failed to unmarshal record: %w", err)
+			}
+			if record.RuleName != ruleName || record.ServerName != serverName ||
+				record.Metric == nil || record.Status != StatusSuccess {
 				continue
 			}
+			records = append(records, record)
+			if limit > 0 && len(records) == limit {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metric history: %w", err)
+	}
+
+	slices.Reverse(records)
+	return records, nil
+}
+
+// GetExecution returns the record with the given execution ID
+func (s *Store) GetExecution(executionID string) (ExecutionRecord, error) {
+	var record ExecutionRecord
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(ExecutionHistoryBucket))
+		v := b.Get([]byte(executionID))
+		if v == nil {
+			return fmt.Errorf("execution not found: %s", executionID)
+		}
+		if err := json.Unmarshal(v, &record); err != nil {
+			return fmt.Errorf("failed to unmarshal record: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return ExecutionRecord{}, fmt.Errorf("failed to get execution: %w", err)
+	}
+
+	return record, nil
+}
 
+// GetExecutionsByBatch returns every record that ran as part of a batch,
+// oldest first
+func (s *Store) GetExecutionsByBatch(batchID string) ([]ExecutionRecord, error) {
+	var records []ExecutionRecord
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(ExecutionHistoryBucket))
+
+		// The batch ID is generated before any of its executions, so
+		// records in the batch can't sort before it
+		c := b.Cursor()
+		for k, v := c.Seek([]byte(batchID)); k != nil; k, v = c.Next() {
 			var record ExecutionRecord
 			if err := json.Unmarshal(v, &record); err != nil {
 				return fmt.Errorf("failed to unmarshal record: %w", err)
 			}
+			if record.BatchID != batchID {
+				continue
+			}
 			records = append(records, record)
 		}
 
@@ -141,7 +373,7 @@ func (s *Store) GetExecutionsByRule(ruleName string) ([]ExecutionRecord, error)
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get executions by rule: %w", err)
+		return nil, fmt.Errorf("failed to get executions by batch: %w", err)
 	}
 
 	return records, nil
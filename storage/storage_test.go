@@ -1,10 +1,14 @@
 package storage
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"go.etcd.io/bbolt"
 )
 
 func TestStore(t *testing.T) {
@@ -87,3 +91,127 @@ func TestStore(t *testing.T) {
 		t.Errorf("Expected result %s, got %s", record.Result, got.Result)
 	}
 }
+
+func TestGetMetricHistory(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	metric := func(v float64) *float64 { return &v }
+	records := []ExecutionRecord{
+		{RuleName: "orders", ServerName: "pg", StartTime: now.Add(-48 * time.Hour), Status: StatusSuccess, Metric: metric(1)},
+		{RuleName: "orders", ServerName: "pg", StartTime: now.Add(-4 * time.Hour), Status: StatusSuccess, Metric: metric(2)},
+		{RuleName: "orders", ServerName: "pg", StartTime: now.Add(-3 * time.Hour), Status: StatusViolation, Metric: metric(99)},
+		{RuleName: "orders", ServerName: "mysql", StartTime: now.Add(-3 * time.Hour), Status: StatusSuccess, Metric: metric(50)},
+		{RuleName: "orders", ServerName: "pg", StartTime: now.Add(-2 * time.Hour), Status: StatusError},
+		{RuleName: "orders", ServerName: "pg", StartTime: now.Add(-time.Hour), Status: StatusSuccess, Metric: metric(3)},
+	}
+	for i := range records {
+		if err := store.SaveExecutionRecord(&records[i]); err != nil {
+			t.Fatalf("Failed to save execution record: %v", err)
+		}
+	}
+
+	values := func(records []ExecutionRecord) []float64 {
+		var out []float64
+		for _, r := range records {
+			out = append(out, *r.Metric)
+		}
+		return out
+	}
+
+	got, err := store.GetMetricHistory("orders", "pg", now.Add(-24*time.Hour), 0)
+	if err != nil {
+		t.Fatalf("Failed to get metric history: %v", err)
+	}
+	if v := values(got); len(v) != 2 || v[0] != 2 || v[1] != 3 {
+		t.Errorf("Expected successful metrics since a day ago [2 3], got %v", v)
+	}
+
+	got, err = store.GetMetricHistory("orders", "pg", now.Add(-72*time.Hour), 2)
+	if err != nil {
+		t.Fatalf("Failed to get metric history: %v", err)
+	}
+	if v := values(got); len(v) != 2 || v[0] != 2 || v[1] != 3 {
+		t.Errorf("Expected the last two successful metrics [2 3], got %v", v)
+	}
+}
+
+func TestMigrateLegacyKeys(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "dataspy-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	// Write records the way older versions did. With a two-character rule
+	// and three-character server the key is as long as an ID.
+	legacyRecords := []ExecutionRecord{
+		{RuleName: "ab", ServerName: "pg1", StartTime: time.Now().Add(-2 * time.Hour), Status: "success"},
+		{RuleName: "legacy-rule", ServerName: "legacy-server", StartTime: time.Now().Add(-time.Hour), Status: "success"},
+	}
+	err = store.db.Update(func(tx *bbolt.Tx) error {
+		for _, legacy := range legacyRecords {
+			value, err := json.Marshal(legacy)
+			if err != nil {
+				return err
+			}
+			key := fmt.Sprintf("%d-%s-%s", legacy.StartTime.UnixNano(), legacy.RuleName, legacy.ServerName)
+			if err := tx.Bucket([]byte(ExecutionHistoryBucket)).Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to write legacy record: %v", err)
+	}
+	store.Close()
+
+	store, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.SaveExecutionRecord(&ExecutionRecord{
+		RuleName:  "new-rule",
+		StartTime: time.Now(),
+	}); err != nil {
+		t.Fatalf("Failed to save execution record: %v", err)
+	}
+
+	records, err := store.GetLatestExecutions(3)
+	if err != nil {
+		t.Fatalf("Failed to get latest executions: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	if records[0].RuleName != "new-rule" || records[1].RuleName != "legacy-rule" || records[2].RuleName != "ab" {
+		t.Errorf("Expected newest record first, got %s, %s then %s", records[0].RuleName, records[1].RuleName, records[2].RuleName)
+	}
+	for _, r := range records {
+		if len(r.ExecutionID) != IDLength {
+			t.Errorf("Expected %s to be re-keyed by ID, got %q", r.RuleName, r.ExecutionID)
+		}
+	}
+
+	got, err := store.GetExecution(records[1].ExecutionID)
+	if err != nil {
+		t.Fatalf("Failed to get migrated execution: %v", err)
+	}
+	if got.RuleName != "legacy-rule" {
+		t.Errorf("Expected rule name legacy-rule, got %s", got.RuleName)
+	}
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// watermarkPrefix namespaces watermark keys in the rule metadata bucket
+const watermarkPrefix = "watermark\x00"
+
+// Watermark is the high-water mark of a rule on a server: the point up to
+// which incremental checks have already looked
+type Watermark struct {
+	RuleName   string    `json:"rule_name"`
+	ServerName string    `json:"server_name"`
+	Value      time.Time `json:"value"`
+	// ExecutionID is the run that advanced the watermark, empty when it was
+	// set by hand
+	ExecutionID string    `json:"execution_id,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func watermarkKey(ruleName, serverName string) []byte {
+	return []byte(watermarkPrefix + ruleName + "\x00" + serverName)
+}
+
+// GetWatermark returns the watermark for a rule on a server, and false when
+// none has been recorded yet
+func (s *Store) GetWatermark(ruleName, serverName string) (Watermark, bool, error) {
+	var wm Watermark
+	var found bool
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(RuleMetadataBucket))
+		v := b.Get(watermarkKey(ruleName, serverName))
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &wm)
+	})
+
+	if err != nil {
+		return Watermark{}, false, fmt.Errorf("failed to get watermark: %w", err)
+	}
+	return wm, found, nil
+}
+
+// SetWatermark stores a watermark unconditionally, e.g. to backfill from an
+// earlier point
+func (s *Store) SetWatermark(wm Watermark) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return putWatermark(tx, wm)
+	})
+}
+
+// AdvanceWatermark moves a watermark forward to value. It never moves it
+// back, so an overlapping run that started earlier but finished later can't
+// undo a newer run's progress.
+func (s *Store) AdvanceWatermark(ruleName, serverName string, value time.Time, executionID string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(RuleMetadataBucket))
+		if v := b.Get(watermarkKey(ruleName, serverName)); v != nil {
+			var current Watermark
+			if err := json.Unmarshal(v, &current); err != nil {
+				return fmt.Errorf("failed to unmarshal watermark: %w", err)
+			}
+			if !value.After(current.Value) {
+				return nil
+			}
+		}
+		return putWatermark(tx, Watermark{
+			RuleName:    ruleName,
+			ServerName:  serverName,
+			Value:       value,
+			ExecutionID: executionID,
+		})
+	})
+}
+
+func putWatermark(tx *bbolt.Tx, wm Watermark) error {
+	wm.UpdatedAt = time.Now()
+	value, err := json.Marshal(wm)
+	if err != nil {
+		return fmt.Errorf("failed to marshal watermark: %w", err)
+	}
+	return tx.Bucket([]byte(RuleMetadataBucket)).Put(watermarkKey(wm.RuleName, wm.ServerName), value)
+}
+
+// DeleteWatermark removes a watermark so the rule's next run starts from
+// its default. It reports whether there was one to remove.
+func (s *Store) DeleteWatermark(ruleName, serverName string) (bool, error) {
+	var deleted bool
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(RuleMetadataBucket))
+		key := watermarkKey(ruleName, serverName)
+		if b.Get(key) == nil {
+			return nil
+		}
+		deleted = true
+		return b.Delete(key)
+	})
+	return deleted, err
+}
+
+// ResetWatermarks removes a rule's watermark on a server, or on every server
+// when serverName is empty, and returns how many it removed
+func (s *Store) ResetWatermarks(ruleName, serverName string) (int, error) {
+	var count int
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(RuleMetadataBucket))
+		if serverName != "" {
+			key := watermarkKey(ruleName, serverName)
+			if b.Get(key) == nil {
+				return nil
+			}
+			count = 1
+			return b.Delete(key)
+		}
+		// Keys are collected first, since deleting moves the cursor
+		var keys [][]byte
+		c := b.Cursor()
+		prefix := watermarkKey(ruleName, "")
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		count = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to reset watermarks: %w", err)
+	}
+	return count, nil
+}
+
+// ListWatermarks returns every stored watermark, ordered by rule then server
+func (s *Store) ListWatermarks() ([]Watermark, error) {
+	var watermarks []Watermark
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(RuleMetadataBucket)).Cursor()
+		prefix := []byte(watermarkPrefix)
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var wm Watermark
+			if err := json.Unmarshal(v, &wm); err != nil {
+				return fmt.Errorf("failed to unmarshal watermark: %w", err)
+			}
+			watermarks = append(watermarks, wm)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list watermarks: %w", err)
+	}
+	return watermarks, nil
+}
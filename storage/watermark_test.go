@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatermarks(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	if _, found, err := store.GetWatermark("orders", "pg"); err != nil || found {
+		t.Fatalf("Expected no watermark, got found=%v err=%v", found, err)
+	}
+
+	t1 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	if err := store.AdvanceWatermark("orders", "pg", t2, "exec-2"); err != nil {
+		t.Fatalf("Failed to advance watermark: %v", err)
+	}
+	// An earlier run finishing late must not move the watermark back
+	if err := store.AdvanceWatermark("orders", "pg", t1, "exec-1"); err != nil {
+		t.Fatalf("Failed to advance watermark: %v", err)
+	}
+	wm, found, err := store.GetWatermark("orders", "pg")
+	if err != nil || !found {
+		t.Fatalf("Expected watermark, got found=%v err=%v", found, err)
+	}
+	if !wm.Value.Equal(t2) || wm.ExecutionID != "exec-2" {
+		t.Errorf("Expected watermark %v from exec-2, got %v from %s", t2, wm.Value, wm.ExecutionID)
+	}
+
+	// Setting by hand may move it back for a backfill
+	if err := store.SetWatermark(Watermark{RuleName: "orders", ServerName: "pg", Value: t1}); err != nil {
+		t.Fatalf("Failed to set watermark: %v", err)
+	}
+	wm, _, _ = store.GetWatermark("orders", "pg")
+	if !wm.Value.Equal(t1) || wm.ExecutionID != "" {
+		t.Errorf("Expected hand-set watermark %v, got %v from %q", t1, wm.Value, wm.ExecutionID)
+	}
+
+	// Watermarks are kept per server
+	if err := store.AdvanceWatermark("orders", "mysql", t1, "exec-3"); err != nil {
+		t.Fatalf("Failed to advance watermark: %v", err)
+	}
+	all, err := store.ListWatermarks()
+	if err != nil {
+		t.Fatalf("Failed to list watermarks: %v", err)
+	}
+	if len(all) != 2 || all[0].ServerName != "mysql" || all[1].ServerName != "pg" {
+		t.Errorf("Expected mysql and pg watermarks, got %+v", all)
+	}
+
+	deleted, err := store.DeleteWatermark("orders", "pg")
+	if err != nil || !deleted {
+		t.Fatalf("Expected watermark to be deleted, got deleted=%v err=%v", deleted, err)
+	}
+	if deleted, _ := store.DeleteWatermark("orders", "pg"); deleted {
+		t.Error("Expected second delete to report nothing removed")
+	}
+	if _, found, _ := store.GetWatermark("orders", "pg"); found {
+		t.Error("Expected watermark to be gone after delete")
+	}
+}